@@ -0,0 +1,102 @@
+// Command export writes history of a room kept in "file" storage as JSON Lines, CSV,
+// a plain-text transcript or an HTML page. Run it while the server is stopped,
+// since both of them would write to the same data directory.
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/export"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+type config struct {
+	DataDir string
+	// Room ID or name.
+	Room   string
+	Format string
+	// Bounds of the exported period in RFC 3339, empty means no bound.
+	From string
+	To   string
+	// Output file, empty means standard output.
+	Output string
+}
+
+func main() {
+	config := &config{
+		DataDir: "data",
+		Format:  string(export.JSONLines),
+	}
+	flag.StringVar(&config.DataDir, "data-dir", config.DataDir, `data directory of "file" storage`)
+	flag.StringVar(&config.Room, "room", config.Room, "ID or name of the room to export")
+	flag.StringVar(&config.Format, "format", config.Format, `export format: "jsonl", "csv", "text" or "html"`)
+	flag.StringVar(&config.From, "from", config.From, "export messages sent at this time (RFC 3339) or later")
+	flag.StringVar(&config.To, "to", config.To, "export messages sent before this time (RFC 3339)")
+	flag.StringVar(&config.Output, "o", config.Output, "output file (default is standard output)")
+	flag.Parse()
+	logger := log.Default()
+
+	if config.Room == "" {
+		logger.Fatalf("Room to export is not set\n")
+	}
+	format, err := export.ParseFormat(config.Format)
+	if err != nil {
+		logger.Fatalf("%v\n", err)
+	}
+	var period export.Period
+	if config.From != "" {
+		if period.From, err = time.Parse(time.RFC3339, config.From); err != nil {
+			logger.Fatalf("Invalid start of the period: %v\n", err)
+		}
+	}
+	if config.To != "" {
+		if period.To, err = time.Parse(time.RFC3339, config.To); err != nil {
+			logger.Fatalf("Invalid end of the period: %v\n", err)
+		}
+	}
+
+	if err := run(context.Background(), config, format, period, logger); err != nil {
+		logger.Fatalf("Export failed: %v\n", err)
+	}
+}
+
+func run(ctx context.Context, config *config, format export.Format, period export.Period, logger *log.Logger) error {
+	repo, err := domain.NewFileRepository(config.DataDir, 0, logger)
+	if err != nil {
+		return err
+	}
+	defer repo.Close()
+
+	room := repo.GetRoom(ctx, config.Room)
+	if room == nil {
+		room = repo.FindRoom(ctx, strings.ToLower(config.Room))
+	}
+	if room == nil {
+		return domain.ErrRoomNotFound
+	}
+
+	store, err := message.NewFileStore(filepath.Join(config.DataDir, "messages"), 0, logger)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	out := os.Stdout
+	if config.Output != "" {
+		if out, err = os.Create(config.Output); err != nil {
+			return err
+		}
+		defer out.Close()
+	}
+	if err := export.Room(ctx, out, store, room, format, period); err != nil {
+		return err
+	}
+	return out.Sync()
+}
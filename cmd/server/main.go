@@ -2,41 +2,192 @@ package main
 
 import (
 	"context"
+	"flag"
 	"log"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 
+	"github.com/lennylebedinsky/chatter/internal/blob"
+	"github.com/lennylebedinsky/chatter/internal/chat"
 	"github.com/lennylebedinsky/chatter/internal/domain"
 	"github.com/lennylebedinsky/chatter/internal/gateway"
 	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/ratelimit"
+	"github.com/lennylebedinsky/chatter/internal/schedule"
 )
 
 type config struct {
 	Host string
 	Port string
+	// Storage is either "memory" (everything is lost on shutdown) or "file".
+	Storage string
+	DataDir string
+	// Default retention of in-memory message history, zero means no limit.
+	RetentionMaxCount int
+	RetentionMaxAge   time.Duration
+	RetentionMaxBytes int
+	// Directory of compressed archive for messages evicted from memory, empty means no archive.
+	ArchiveDir string
+	// Directory of uploaded files, by default within data directory.
+	BlobDir     string
+	BlobMaxSize int64
+	BlobTypes   string
+	// Whether room participants are let know who has read messages.
+	SeenBy bool
+	// Limits of messages posted by users, zero rate means no limit.
+	RateLimits chat.RateLimits
 }
 
 func main() {
 	config := &config{
-		Host: "localhost",
-		Port: "8080",
+		Host:        "localhost",
+		Port:        "8080",
+		Storage:     "memory",
+		DataDir:     "data",
+		BlobMaxSize: 10 << 20,
+		BlobTypes:   strings.Join(blob.DefaultAllowedTypes, ","),
+		SeenBy:      true,
+		RateLimits: chat.RateLimits{
+			Connection: ratelimit.Limit{Rate: 5, Burst: 10},
+			User:       ratelimit.Limit{Rate: 5, Burst: 10},
+			Room:       ratelimit.Limit{Rate: 50, Burst: 100},
+		},
 	}
+	flag.StringVar(&config.Storage, "storage", config.Storage, `storage type: "memory" or "file"`)
+	flag.StringVar(&config.DataDir, "data-dir", config.DataDir, `data directory for "file" storage`)
+	flag.IntVar(&config.RetentionMaxCount, "retention-max-count", config.RetentionMaxCount, "maximum number of messages kept per room in memory, only with \"memory\" storage")
+	flag.DurationVar(&config.RetentionMaxAge, "retention-max-age", config.RetentionMaxAge, "maximum age of messages kept in memory, only with \"memory\" storage")
+	flag.IntVar(&config.RetentionMaxBytes, "retention-max-bytes", config.RetentionMaxBytes, "maximum size of messages kept per room in memory, only with \"memory\" storage")
+	flag.StringVar(&config.ArchiveDir, "archive-dir", config.ArchiveDir, "directory for archive of messages evicted from memory, only with \"memory\" storage; rooms of \"memory\" storage get new IDs on restart, so archive is only read back while server runs")
+	flag.StringVar(&config.BlobDir, "blob-dir", config.BlobDir, "directory for uploaded files (default is blobs within data directory)")
+	flag.Int64Var(&config.BlobMaxSize, "blob-max-size", config.BlobMaxSize, "maximum size of uploaded file in bytes")
+	flag.StringVar(&config.BlobTypes, "blob-types", config.BlobTypes, "comma-separated MIME types of files allowed for upload")
+	flag.BoolVar(&config.SeenBy, "seen-by", config.SeenBy, `broadcast "seen by" updates when users read messages`)
+	flag.Float64Var(&config.RateLimits.Connection.Rate, "connection-rate", config.RateLimits.Connection.Rate, "messages per second allowed from a single connection, zero means no limit")
+	flag.IntVar(&config.RateLimits.Connection.Burst, "connection-burst", config.RateLimits.Connection.Burst, "messages allowed at once from a single connection")
+	flag.Float64Var(&config.RateLimits.User.Rate, "user-rate", config.RateLimits.User.Rate, "messages per second allowed from a user, zero means no limit")
+	flag.IntVar(&config.RateLimits.User.Burst, "user-burst", config.RateLimits.User.Burst, "messages allowed at once from a user")
+	flag.Float64Var(&config.RateLimits.Room.Rate, "room-rate", config.RateLimits.Room.Rate, "messages per second allowed to a room, zero means no limit")
+	flag.IntVar(&config.RateLimits.Room.Burst, "room-burst", config.RateLimits.Room.Burst, "messages allowed at once to a room")
+	flag.Parse()
 	logger := log.Default()
 
+	var repo domain.Repository
+	var messageStore message.Store
+	// Scheduled messages are kept only in memory, unless file storage is used.
+	var scheduledPath string
+	// Background goroutines run until the server is shut down.
+	running, stopRunning := context.WithCancel(context.Background())
+	defer stopRunning()
+	var background sync.WaitGroup
+	switch config.Storage {
+	case "memory":
+		repo = domain.NewInMemoryRepository()
+
+		var archive *message.Archive
+		if config.ArchiveDir != "" {
+			// Archive outlives the process, but rooms do not: history archived by a previous run is not reachable.
+			logger.Printf("Archive %s keeps history evicted while server runs; it is not read back after restart, since rooms are kept in memory.\n", config.ArchiveDir)
+			var err error
+			if archive, err = message.NewArchive(config.ArchiveDir, 0); err != nil {
+				logger.Fatalf("Cannot open message archive: %v\n", err)
+			}
+		}
+		memoryStore := message.NewInMemoryStore(message.RetentionPolicy{
+			MaxCount: config.RetentionMaxCount,
+			MaxAge:   config.RetentionMaxAge,
+			MaxBytes: config.RetentionMaxBytes,
+		}, archive, logger)
+		defer func() {
+			if err := memoryStore.Close(); err != nil {
+				logger.Printf("Message store closed with error: %v\n", err)
+			}
+		}()
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			memoryStore.RunSweeper(running, time.Minute)
+		}()
+		messageStore = memoryStore
+	case "file":
+		// File store keeps whole history on disk, it is neither bounded nor archived.
+		flag.Visit(func(f *flag.Flag) {
+			if strings.HasPrefix(f.Name, "retention-") {
+				logger.Fatalf("Flag -%s is only supported with \"memory\" storage\n", f.Name)
+			}
+		})
+		if config.ArchiveDir != "" {
+			logger.Fatalf("Message archive is only supported with \"memory\" storage\n")
+		}
+		fileRepo, err := domain.NewFileRepository(config.DataDir, 0, logger)
+		if err != nil {
+			logger.Fatalf("Cannot open repository: %v\n", err)
+		}
+		defer func() {
+			if err := fileRepo.Close(); err != nil {
+				logger.Printf("Repository closed with error: %v\n", err)
+			}
+		}()
+		repo = fileRepo
+
+		fileStore, err := message.NewFileStore(filepath.Join(config.DataDir, "messages"), 0, logger)
+		if err != nil {
+			logger.Fatalf("Cannot open message store: %v\n", err)
+		}
+		defer func() {
+			if err := fileStore.Close(); err != nil {
+				logger.Printf("Message store closed with error: %v\n", err)
+			}
+		}()
+		messageStore = fileStore
+		scheduledPath = filepath.Join(config.DataDir, "scheduled.json")
+	default:
+		logger.Fatalf("Unknown storage type %q\n", config.Storage)
+	}
+
+	if config.BlobDir == "" {
+		config.BlobDir = filepath.Join(config.DataDir, "blobs")
+	}
+	blobStore, err := blob.NewStore(config.BlobDir, config.BlobMaxSize, strings.Split(config.BlobTypes, ","))
+	if err != nil {
+		logger.Fatalf("Cannot open blob store: %v\n", err)
+	}
+
+	scheduler, err := schedule.NewScheduler(repo, scheduledPath, logger)
+	if err != nil {
+		logger.Fatalf("Cannot open scheduled messages: %v\n", err)
+	}
+
 	gw := gateway.New(
-		domain.NewInMemoryRepository(),
-		message.NewInMemoryStore(),
+		repo,
+		messageStore,
+		blobStore,
+		scheduler,
 		logger)
+	gw.SetSeenBy(config.SeenBy)
+	gw.SetRateLimits(config.RateLimits)
 
 	httpServer := &http.Server{
 		Addr:    net.JoinHostPort(config.Host, config.Port),
 		Handler: gw.Router(),
 	}
 
+	if err := gw.LoadHistory(context.Background()); err != nil {
+		// Not fatal, history is still available, though older messages are not searchable.
+		logger.Printf("History could not be indexed for search: %v\n", err)
+	}
+
+	// Start gateway's broadcaster to support message exchange.
+	gw.StartBroadcaster(running)
+
+	// Requests are served only once history is loaded and broadcaster is running.
 	go func() {
 		logger.Printf("HTTP server is listening on %s\n", httpServer.Addr+" ...")
 		if err := httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
@@ -44,9 +195,6 @@ func main() {
 		}
 	}()
 
-	// Start gateway's broadcaster to support message exchange.
-	gw.StartBroadcaster(context.Background())
-
 	// Wait for interrupt signal to gracefully shutdown the server with a timeout of 5 seconds.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -63,4 +211,10 @@ func main() {
 	}
 
 	logger.Println("HTTP server had been shut down.")
+
+	// Background goroutines write to the storage, so it is closed by deferred calls only after they return.
+	stopRunning()
+	gw.Wait()
+	background.Wait()
+	logger.Println("Broadcaster and background jobs had been stopped.")
 }
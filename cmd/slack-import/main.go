@@ -0,0 +1,87 @@
+// Command slack-import imports users, channels and their history from a Slack workspace export,
+// either the zip archive as downloaded from Slack or a directory it was unpacked to,
+// into "file" storage. Run it while the server is stopped, since both of them would write
+// to the same data directory. Import can be repeated, e.g. with a newer export;
+// users, rooms and messages imported before are not duplicated.
+package main
+
+import (
+	"archive/zip"
+	"context"
+	"flag"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/slack"
+)
+
+type config struct {
+	DataDir string
+	// Slack export, zip archive or directory.
+	Export string
+}
+
+func main() {
+	config := &config{
+		DataDir: "data",
+	}
+	flag.StringVar(&config.DataDir, "data-dir", config.DataDir, `data directory of "file" storage`)
+	flag.Usage = func() {
+		flag.CommandLine.Output().Write([]byte("Usage: slack-import [-data-dir dir] <export.zip or directory>\n"))
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	config.Export = flag.Arg(0)
+	logger := log.Default()
+
+	if config.Export == "" {
+		flag.Usage()
+		os.Exit(2)
+	}
+	stats, err := run(context.Background(), config, logger)
+	if err != nil {
+		logger.Fatalf("Import failed: %v\n", err)
+	}
+	logger.Printf("Imported %d messages, %d were imported before and %d skipped; created %d users and %d rooms.\n",
+		stats.Messages, stats.Existing, stats.Skipped, stats.Users, stats.Rooms)
+}
+
+func run(ctx context.Context, config *config, logger *log.Logger) (slack.Stats, error) {
+	var export fs.FS
+	if strings.HasSuffix(strings.ToLower(config.Export), ".zip") {
+		archive, err := zip.OpenReader(config.Export)
+		if err != nil {
+			return slack.Stats{}, err
+		}
+		defer archive.Close()
+		export = archive
+	} else {
+		export = os.DirFS(config.Export)
+	}
+
+	repo, err := domain.NewFileRepository(config.DataDir, 0, logger)
+	if err != nil {
+		return slack.Stats{}, err
+	}
+	defer func() {
+		if err := repo.Close(); err != nil {
+			logger.Printf("Repository closed with error: %v\n", err)
+		}
+	}()
+	store, err := message.NewFileStore(filepath.Join(config.DataDir, "messages"), 0, logger)
+	if err != nil {
+		return slack.Stats{}, err
+	}
+	defer func() {
+		if err := store.Close(); err != nil {
+			logger.Printf("Message store closed with error: %v\n", err)
+		}
+	}()
+
+	return slack.NewImporter(repo, store, logger).Import(ctx, export)
+}
@@ -0,0 +1,256 @@
+package blob
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+const (
+	metaExt      = ".json"
+	thumbnailExt = ".thumb.png"
+
+	// Content type is detected by the first bytes of the file.
+	sniffSize = 512
+)
+
+// DefaultAllowedTypes are types of files accepted by default: images, documents and archives.
+var DefaultAllowedTypes = []string{
+	"image/png",
+	"image/jpeg",
+	"image/gif",
+	"image/webp",
+	"application/pdf",
+	"text/plain",
+	"application/zip",
+}
+
+var (
+	ErrNotFound       = errors.New("file not found")
+	ErrTooLarge       = errors.New("file is too large")
+	ErrTypeNotAllowed = errors.New("file type is not allowed")
+)
+
+// Blob describes stored file. Its ID is a hash of the content.
+type Blob struct {
+	ID           string   `json:"id"`
+	MIMEType     string   `json:"mimeType"`
+	Size         int64    `json:"size"`
+	HasThumbnail bool     `json:"hasThumbnail"`
+	Rooms        []string `json:"-"`
+}
+
+// InRoom tells if the file was uploaded to the room, so that its participants can download it.
+func (b *Blob) InRoom(roomID string) bool {
+	return slices.Contains(b.Rooms, roomID)
+}
+
+// meta is kept next to the blob content.
+type meta struct {
+	MIMEType     string   `json:"mimeType"`
+	Size         int64    `json:"size"`
+	HasThumbnail bool     `json:"hasThumbnail"`
+	Rooms        []string `json:"rooms"`
+}
+
+// Store keeps files on local disk addressed by SHA-256 of their content,
+// so the same file uploaded several times is stored once.
+// Files are fanned out into subdirectories by the first bytes of the hash.
+// Every file is granted to rooms it was uploaded to; access control is left to the caller.
+type Store struct {
+	dir          string
+	maxSize      int64
+	allowedTypes []string
+
+	mu sync.Mutex
+}
+
+// NewStore opens (or initializes) blob store in the directory.
+// Files larger than maxSize bytes or of types other than allowedTypes are rejected.
+func NewStore(dir string, maxSize int64, allowedTypes []string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create blob store directory: %w", err)
+	}
+	return &Store{
+		dir:          dir,
+		maxSize:      maxSize,
+		allowedTypes: allowedTypes,
+	}, nil
+}
+
+// Put stores file uploaded to the room and grants the room access to it.
+// Content type is detected from the content rather than trusted to the client.
+func (s *Store) Put(roomID string, r io.Reader) (*Blob, error) {
+	tmp, err := os.CreateTemp(s.dir, "upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("create upload file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	head := &bytes.Buffer{}
+	size, err := io.Copy(io.MultiWriter(tmp, hash, &limitedWriter{w: head, n: sniffSize}), io.LimitReader(r, s.maxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("write upload file: %w", err)
+	}
+	if size > s.maxSize {
+		return nil, ErrTooLarge
+	}
+	mimeType, _, err := mime.ParseMediaType(http.DetectContentType(head.Bytes()))
+	if err != nil || !slices.Contains(s.allowedTypes, mimeType) {
+		return nil, fmt.Errorf("%w: %s", ErrTypeNotAllowed, mimeType)
+	}
+	if err := tmp.Sync(); err != nil {
+		return nil, fmt.Errorf("sync upload file: %w", err)
+	}
+
+	id := hex.EncodeToString(hash.Sum(nil))
+	path := s.path(id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.meta(id)
+	if errors.Is(err, ErrNotFound) {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return nil, fmt.Errorf("create blob directory: %w", err)
+		}
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return nil, fmt.Errorf("rename upload file: %w", err)
+		}
+		m = &meta{MIMEType: mimeType, Size: size}
+		if err := writeThumbnail(path, path+thumbnailExt); err == nil {
+			m.HasThumbnail = true
+		}
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !slices.Contains(m.Rooms, roomID) {
+		m.Rooms = append(m.Rooms, roomID)
+	}
+	if err := s.writeMeta(id, m); err != nil {
+		return nil, err
+	}
+	return m.blob(id), nil
+}
+
+// Stat returns description of the stored file.
+func (s *Store) Stat(id string) (*Blob, error) {
+	if !validID(id) {
+		return nil, ErrNotFound
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	m, err := s.meta(id)
+	if err != nil {
+		return nil, err
+	}
+	return m.blob(id), nil
+}
+
+// Open returns content of the file, or of its thumbnail.
+func (s *Store) Open(id string, thumbnail bool) (*os.File, *Blob, error) {
+	b, err := s.Stat(id)
+	if err != nil {
+		return nil, nil, err
+	}
+	path := s.path(id)
+	if thumbnail {
+		if !b.HasThumbnail {
+			return nil, nil, ErrNotFound
+		}
+		path += thumbnailExt
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("open blob: %w", err)
+	}
+	return f, b, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id[:2], id)
+}
+
+// meta reads description of the file. Caller must hold the lock.
+func (s *Store) meta(id string) (*meta, error) {
+	data, err := os.ReadFile(s.path(id) + metaExt)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read blob meta: %w", err)
+	}
+	m := &meta{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("decode blob meta: %w", err)
+	}
+	return m, nil
+}
+
+// writeMeta replaces description of the file atomically. Caller must hold the lock.
+func (s *Store) writeMeta(id string, m *meta) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("encode blob meta: %w", err)
+	}
+	path := s.path(id) + metaExt
+	if err := os.WriteFile(path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("write blob meta: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("rename blob meta: %w", err)
+	}
+	return nil
+}
+
+func (m *meta) blob(id string) *Blob {
+	return &Blob{
+		ID:           id,
+		MIMEType:     m.MIMEType,
+		Size:         m.Size,
+		HasThumbnail: m.HasThumbnail,
+		Rooms:        slices.Clone(m.Rooms),
+	}
+}
+
+// validID checks that ID is a hex-encoded SHA-256, so that it cannot escape the store directory.
+func validID(id string) bool {
+	if len(id) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// limitedWriter keeps only first n bytes written to it.
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if l.n > 0 {
+		chunk := p[:min(len(p), l.n)]
+		l.n -= len(chunk)
+		l.w.Write(chunk)
+	}
+	return len(p), nil
+}
@@ -0,0 +1,167 @@
+package blob
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+)
+
+const (
+	testRoomID      = "room-1"
+	testOtherRoomID = "room-2"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for x := 0; x < width; x++ {
+		img.Set(x, 0, color.NRGBA{R: 0xff, A: 0xff})
+	}
+	buf := &bytes.Buffer{}
+	if err := png.Encode(buf, img); err != nil {
+		t.Fatalf("png.Encode() error = %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestStore_Put(t *testing.T) {
+	tests := []struct {
+		name          string
+		data          []byte
+		wantMIMEType  string
+		wantThumbnail bool
+		wantErr       error
+	}{
+		{
+			name:         "Text file should be stored",
+			data:         []byte("Meow!"),
+			wantMIMEType: "text/plain",
+		},
+		{
+			name:          "Image should be stored with thumbnail",
+			data:          testPNG(t, 600, 300),
+			wantMIMEType:  "image/png",
+			wantThumbnail: true,
+		},
+		{
+			name:    "File larger than limit should be rejected",
+			data:    bytes.Repeat([]byte("a"), 64<<10+1),
+			wantErr: ErrTooLarge,
+		},
+		{
+			name:    "File of type not allowed should be rejected",
+			data:    []byte("<html><body>Meow!</body></html>"),
+			wantErr: ErrTypeNotAllowed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewStore(t.TempDir(), 64<<10, DefaultAllowedTypes)
+			if err != nil {
+				t.Fatalf("NewStore() error = %v", err)
+			}
+			got, err := s.Put(testRoomID, bytes.NewReader(tt.data))
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Store.Put() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got.MIMEType != tt.wantMIMEType || got.Size != int64(len(tt.data)) || got.HasThumbnail != tt.wantThumbnail {
+				t.Errorf("Store.Put() = %+v, want type %s, size %d, thumbnail %v", got, tt.wantMIMEType, len(tt.data), tt.wantThumbnail)
+			}
+
+			f, _, err := s.Open(got.ID, false)
+			if err != nil {
+				t.Fatalf("Store.Open() error = %v", err)
+			}
+			defer f.Close()
+			if content, _ := io.ReadAll(f); !bytes.Equal(content, tt.data) {
+				t.Errorf("Store.Open() content differs from stored one")
+			}
+
+			thumbnail, _, err := s.Open(got.ID, true)
+			if !tt.wantThumbnail {
+				if !errors.Is(err, ErrNotFound) {
+					t.Errorf("Store.Open() thumbnail error = %v, want %v", err, ErrNotFound)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Store.Open() thumbnail error = %v", err)
+			}
+			defer thumbnail.Close()
+			config, err := png.DecodeConfig(thumbnail)
+			if err != nil {
+				t.Fatalf("png.DecodeConfig() error = %v", err)
+			}
+			if config.Width != thumbnailSize || config.Height != thumbnailSize/2 {
+				t.Errorf("thumbnail is %dx%d, want %dx%d", config.Width, config.Height, thumbnailSize, thumbnailSize/2)
+			}
+		})
+	}
+}
+
+func TestStore_PutDeduplicates(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, 1<<10, DefaultAllowedTypes)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	first, err := s.Put(testRoomID, strings.NewReader("Meow!"))
+	if err != nil {
+		t.Fatalf("Store.Put() error = %v", err)
+	}
+	second, err := s.Put(testOtherRoomID, strings.NewReader("Meow!"))
+	if err != nil {
+		t.Fatalf("Store.Put() error = %v", err)
+	}
+	if first.ID != second.ID {
+		t.Errorf("Store.Put() of the same content = %s, want %s", second.ID, first.ID)
+	}
+
+	// Grants should survive reopening the store.
+	s, err = NewStore(dir, 1<<10, DefaultAllowedTypes)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	got, err := s.Stat(first.ID)
+	if err != nil {
+		t.Fatalf("Store.Stat() error = %v", err)
+	}
+	if !got.InRoom(testRoomID) || !got.InRoom(testOtherRoomID) || got.InRoom("room-3") {
+		t.Errorf("Store.Stat() rooms = %v, want %v", got.Rooms, []string{testRoomID, testOtherRoomID})
+	}
+}
+
+func TestStore_Stat(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 1<<10, DefaultAllowedTypes)
+	if err != nil {
+		t.Fatalf("NewStore() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		id   string
+	}{
+		{
+			name: "Missing file should not be found",
+			id:   strings.Repeat("ab", 32),
+		},
+		{
+			name: "Malformed ID should not be found",
+			id:   "../../etc/passwd",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := s.Stat(tt.id); !errors.Is(err, ErrNotFound) {
+				t.Errorf("Store.Stat() error = %v, want %v", err, ErrNotFound)
+			}
+		})
+	}
+}
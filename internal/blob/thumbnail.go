@@ -0,0 +1,100 @@
+package blob
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	"image/png"
+	"os"
+)
+
+const (
+	thumbnailSize = 256
+
+	// Images are decoded into memory, so too large ones are not thumbnailed.
+	maxThumbnailSourcePixels = 50_000_000
+)
+
+var errNotImage = errors.New("file is not a supported image")
+
+// writeThumbnail scales image down to fit thumbnail size, keeping aspect ratio, and writes it as PNG.
+func writeThumbnail(src, dst string) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	config, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return errNotImage
+	}
+	if config.Width*config.Height > maxThumbnailSourcePixels {
+		return fmt.Errorf("image of %dx%d pixels is too large for thumbnail", config.Width, config.Height)
+	}
+	if _, err := f.Seek(0, 0); err != nil {
+		return err
+	}
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return errNotImage
+	}
+
+	out, err := os.Create(dst + ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst + ".tmp")
+	defer out.Close()
+
+	if err := png.Encode(out, scale(img, thumbnailSize)); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Rename(dst+".tmp", dst)
+}
+
+// scale shrinks image to fit into size x size box, averaging source pixels covered by each target pixel.
+// Images which already fit are not enlarged.
+func scale(src image.Image, size int) image.Image {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w <= size && h <= size {
+		return src
+	}
+	tw, th := size, size
+	if w > h {
+		th = max(1, h*size/w)
+	} else {
+		tw = max(1, w*size/h)
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for y := 0; y < th; y++ {
+		y0, y1 := bounds.Min.Y+y*h/th, bounds.Min.Y+(y+1)*h/th
+		for x := 0; x < tw; x++ {
+			x0, x1 := bounds.Min.X+x*w/tw, bounds.Min.X+(x+1)*w/tw
+			var r, g, b, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := src.At(sx, sy).RGBA()
+					r, g, b, a, n = r+uint64(cr), g+uint64(cg), b+uint64(cb), a+uint64(ca), n+1
+				}
+			}
+			i := dst.PixOffset(x, y)
+			if a == 0 {
+				continue
+			}
+			// Colors are premultiplied by alpha, NRGBA is not.
+			dst.Pix[i+0] = uint8(r * 0xff / a)
+			dst.Pix[i+1] = uint8(g * 0xff / a)
+			dst.Pix[i+2] = uint8(b * 0xff / a)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
@@ -3,11 +3,18 @@ package chat
 import (
 	"context"
 	"errors"
+	"fmt"
 	"log"
+	"path"
+	"slices"
 	"time"
 
+	"github.com/lennylebedinsky/chatter/internal/blob"
 	"github.com/lennylebedinsky/chatter/internal/domain"
 	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/moderation"
+	"github.com/lennylebedinsky/chatter/internal/ratelimit"
+	"github.com/lennylebedinsky/chatter/internal/search"
 )
 
 type Broadcaster struct {
@@ -17,21 +24,55 @@ type Broadcaster struct {
 	unregister chan *UserSocket
 
 	message chan *message.Message
+	// Messages coming from user sockets, to be answered with ack or error.
+	requests chan *request
 
 	repo         domain.Repository
 	messageStore message.Store
+	clock        *message.Clock
+	searchIndex  *search.Index
+	blobStore    *blob.Store
+	// Filters messages before they are accepted; nil lets every message in.
+	moderation *moderation.Pipeline
+	// Limits of messages posted by users, and times of their latest posts to rooms in slow mode.
+	rateLimits  RateLimits
+	userLimiter *ratelimit.Limiter
+	roomLimiter *ratelimit.Limiter
+	posted      map[postKey]time.Time
+	// Read cursors moved by authors' own messages, stored in batches by storeAuthorReads.
+	authorReads map[postKey]uint64
+	// Whether room participants get "seen by" updates when users read messages.
+	seenBy bool
+	// Ephemeral messages to remove from history once they expire.
+	expiry expiryQueue
 
 	logger *log.Logger
 }
 
-func NewBroadcaster(repo domain.Repository, messageStore message.Store, logger *log.Logger) *Broadcaster {
+func NewBroadcaster(
+	repo domain.Repository,
+	messageStore message.Store,
+	searchIndex *search.Index,
+	blobStore *blob.Store,
+	moderation *moderation.Pipeline,
+	logger *log.Logger) *Broadcaster {
 	return &Broadcaster{
 		sockets:      make(map[*UserSocket]bool),
 		register:     make(chan *UserSocket),
 		unregister:   make(chan *UserSocket),
 		message:      make(chan *message.Message),
+		requests:     make(chan *request),
 		repo:         repo,
 		messageStore: messageStore,
+		clock:        message.NewClock(messageStore),
+		searchIndex:  searchIndex,
+		blobStore:    blobStore,
+		moderation:   moderation,
+		userLimiter:  ratelimit.NewLimiter(ratelimit.Limit{}),
+		roomLimiter:  ratelimit.NewLimiter(ratelimit.Limit{}),
+		posted:       make(map[postKey]time.Time),
+		authorReads:  make(map[postKey]uint64),
+		seenBy:       true,
 		logger:       logger,
 	}
 
@@ -45,11 +86,16 @@ func (b *Broadcaster) Start(ctx context.Context) {
 		b.logger.Println("Message broadcaster stopped.")
 	}()
 
+	ticker := time.NewTicker(expiryInterval)
+	defer ticker.Stop()
+
 	b.logger.Println("Message broadcaster started.")
 	for {
 		select {
 		case socket := <-b.register:
+			socket.replay = b.replayRanges(ctx, socket)
 			b.sockets[socket] = true
+			close(socket.registered)
 			b.logger.Printf("User %s registered with broadcaster.\n", socket.user.Name)
 		case socket := <-b.unregister:
 			if _, ok := b.sockets[socket]; ok {
@@ -58,33 +104,136 @@ func (b *Broadcaster) Start(ctx context.Context) {
 				b.logger.Printf("User %s unregistered from broadcaster.\n", socket.user.Name)
 			}
 		case msg := <-b.message:
-			if err := b.validate(ctx, msg); err != nil {
-				// Not fatal, just log and continue listening for other messages.
-				b.logger.Printf("Message is not accepted by broadcaster: %v\n", err)
-			} else {
-				b.accept(ctx, msg)
-				b.logger.Printf("Broadcasting message %v", msg)
-				destination, err := b.dispatch(ctx, msg)
-				if err == nil {
-					for _, socket := range destination {
-						select {
-						case socket.outbound <- msg:
-						default:
-							// If send buffer is full, assume client is disconnected or hanged.
-							close(socket.outbound)
-							delete(b.sockets, socket)
-						}
-					}
-				}
+			b.handle(ctx, &request{msg: msg})
+		case req := <-b.requests:
+			err := b.handle(ctx, req)
+			if req.verdict != nil {
+				req.verdict <- err
 			}
+		case now := <-ticker.C:
+			b.expire(ctx, now)
+			b.forgetPosts(ctx, now)
+			b.storeAuthorReads(ctx)
 		case <-ctx.Done():
 			b.logger.Printf("Context canceled, stopping broadcaster...")
+			b.storeAuthorReads(context.WithoutCancel(ctx))
 			return
 		}
 	}
 
 }
 
+// handle validates, limits, moderates and accepts the message coming from user socket,
+// and broadcasts it along with events it results in.
+// Sender gets an ack once message is accepted, if they set client ID for it, or an error why it is not.
+// Sender of the message which moderation does not let in as is gets a notice why instead.
+// Returned error tells why message is not accepted; message held for review is not rejected.
+func (b *Broadcaster) handle(ctx context.Context, req *request) error {
+	msg := req.msg
+	if err := b.validate(ctx, msg); err != nil {
+		// Not fatal, just log and continue listening for other messages.
+		b.logger.Printf("Message is not accepted by broadcaster: %v\n", err)
+		b.broadcast([]*event{b.reply(req, rejection(msg, message.InvalidError, err.Error(), 0))})
+		return err
+	}
+
+	now := time.Now()
+	quota, limited := b.limit(ctx, msg, now)
+	if limited != nil {
+		reason := limited.Body.(*message.ErrorBody).Reason
+		b.logger.Printf("Message of user %s is rate limited: %s\n", msg.User, reason)
+		b.broadcast([]*event{b.reply(req, limited)})
+		return errors.New(reason)
+	}
+
+	var notices []*event
+	switch verdict := b.moderate(msg); verdict.Outcome {
+	case moderation.Reject:
+		b.logger.Printf("Message of user %s is rejected by moderation: %s\n", msg.User, verdict.Reason)
+		b.broadcast([]*event{b.reply(req, notice(msg, verdict.Outcome, verdict.Reason, ""))})
+		return fmt.Errorf("rejected by moderation: %s", verdict.Reason)
+	case moderation.Quarantine:
+		held, review := b.hold(ctx, msg, verdict.Reason)
+		b.logger.Printf("Message of user %s is held for review as %s: %s\n", msg.User, held.ID, verdict.Reason)
+		b.broadcast([]*event{review, b.reply(req, notice(msg, verdict.Outcome, verdict.Reason, held.ID))})
+		return nil
+	case moderation.Redact:
+		notices = append(notices, b.reply(req, notice(msg, verdict.Outcome, verdict.Reason, "")))
+	}
+
+	if err := b.accept(ctx, msg); err != nil {
+		b.logger.Printf("Message is not accepted by broadcaster: %v\n", err)
+		b.broadcast([]*event{b.reply(req, rejection(msg, message.NotAcceptedError, err.Error(), 0))})
+		return err
+	}
+	if quota != nil {
+		b.spend(quota, now)
+	}
+	events, err := b.dispatch(ctx, msg)
+	if err != nil {
+		b.logger.Printf("Message could not be dispatched: %v\n", err)
+		failure := rejection(msg, message.NotDeliveredError, err.Error(), 0)
+		failure.Body.(*message.ErrorBody).MessageID = msg.ID
+		notices = append(notices, b.reply(req, failure))
+	} else if req.clientID != "" {
+		notices = append(notices, b.reply(req, ack(msg)))
+	}
+	b.broadcast(append(events, notices...))
+	return nil
+}
+
+// broadcast sends events to their destination sockets.
+func (b *Broadcaster) broadcast(events []*event) {
+	for _, event := range events {
+		b.logger.Printf("Broadcasting message %v", event.msg)
+		for _, socket := range event.destination {
+			if _, ok := b.sockets[socket]; !ok {
+				// Socket was closed while broadcasting previous event.
+				continue
+			}
+			select {
+			case socket.outbound <- event.msg:
+			default:
+				// If send buffer is full, assume client is disconnected or hanged.
+				// Client is asked to reconnect and resume, so that messages are replayed from the store.
+				b.logger.Printf("User %s does not keep up with messages, closing connection.\n", socket.user.Name)
+				socket.overflowed = true
+				close(socket.outbound)
+				delete(b.sockets, socket)
+			}
+		}
+	}
+}
+
+// replayRanges determines messages missed by reconnected client in rooms it resumes.
+// Messages accepted after registration are delivered live, so replay stops at the latest message of the room.
+// Rooms which user does not participate in are not replayed.
+func (b *Broadcaster) replayRanges(ctx context.Context, socket *UserSocket) []replayRange {
+	ranges := []replayRange{}
+	for roomID, after := range socket.resume {
+		participants, err := b.repo.ListParticipants(ctx, roomID)
+		if err != nil || !slices.ContainsFunc(participants, func(u *domain.User) bool { return u.ID == socket.user.ID }) {
+			b.logger.Printf("User %s cannot resume room %s.\n", socket.user.Name, roomID)
+			continue
+		}
+		lastSeq, err := b.messageStore.LastSeq(ctx, roomID)
+		if err != nil {
+			b.logger.Printf("Room %s cannot be resumed: %v\n", roomID, err)
+			continue
+		}
+		if after < lastSeq {
+			ranges = append(ranges, replayRange{RoomID: roomID, After: after, Until: lastSeq})
+		}
+	}
+	return ranges
+}
+
+// SetSeenBy enables or disables "seen by" updates; read cursors are kept either way.
+// It is supposed to be called before broadcaster is started.
+func (b *Broadcaster) SetSeenBy(enabled bool) {
+	b.seenBy = enabled
+}
+
 func (b *Broadcaster) Register() chan *UserSocket {
 	return b.register
 }
@@ -93,9 +242,23 @@ func (b *Broadcaster) Message() chan *message.Message {
 	return b.message
 }
 
+// Submit passes message on behalf of its author, who did not send it via socket, e.g. a scheduled one,
+// and waits until broadcaster handles it. Returned error tells why message is not accepted.
+// Author's sockets get the same answers as if they sent the message.
+func (b *Broadcaster) Submit(ctx context.Context, msg *message.Message) error {
+	verdict := make(chan error, 1)
+	select {
+	case b.requests <- &request{msg: msg, verdict: verdict}:
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// Broadcaster answers every request it has taken, even if it is stopped meanwhile.
+	return <-verdict
+}
+
 func (b *Broadcaster) IsRegistered(user *domain.User) bool {
 	for socket := range b.sockets {
-		if socket.user == user {
+		if socket.user.ID == user.ID {
 			return true
 		}
 	}
@@ -104,60 +267,457 @@ func (b *Broadcaster) IsRegistered(user *domain.User) bool {
 }
 
 // validate checks if message is considered valid for broadcasting.
-func (b *Broadcaster) validate(_ context.Context, msg *message.Message) error {
+// Room destination is resolved by its ID or, if client provided only a name, by the name.
+func (b *Broadcaster) validate(ctx context.Context, msg *message.Message) error {
 	// Notifications potentially could have user or room missed.
-	if msg.IsNotification {
+	if msg.IsNotification() {
 		return nil
 	}
 
-	if msg.User == "" {
+	if msg.UserID == "" {
 		return errors.New("message does not have an author")
 	}
 
-	if msg.Room == "" {
+	if msg.RoomID == "" && msg.Room == "" {
 		return errors.New("message does not have room destination")
 	}
 
+	switch body := msg.Body.(type) {
+	case *message.TextBody, *message.MembershipBody:
+	case *message.AttachmentBody:
+		if body.BlobID == "" {
+			return errors.New("attachment does not reference a file")
+		}
+	case *message.EditBody:
+		if body.MessageID == "" {
+			return errors.New("changed message is not specified")
+		}
+	case *message.ReactionBody:
+		if body.MessageID == "" {
+			return errors.New("message to react to is not specified")
+		}
+		if err := message.ValidateReaction(body.Emoji); err != nil {
+			return err
+		}
+	case *message.ReadBody:
+		if body.Seq == 0 {
+			return errors.New("read message is not specified")
+		}
+	case *message.PinBody:
+		if body.MessageID == "" {
+			return errors.New("message to pin is not specified")
+		}
+	case *message.ReviewBody:
+		if body.MessageID == "" {
+			return errors.New("message to review is not specified")
+		}
+	default:
+		return fmt.Errorf("unsupported message kind %q", msg.Type())
+	}
+
+	room := b.repo.GetRoom(ctx, msg.RoomID)
+	if room == nil {
+		room = b.repo.FindRoom(ctx, msg.Room)
+	}
+	if room == nil {
+		return errors.New("message room destination does not exist")
+	}
+	msg.RoomID = room.ID
+	msg.Room = room.Name
+	// Socket keeps the user as of connecting, so the name is looked up in case user was renamed since then.
+	if user := b.repo.GetUser(ctx, msg.UserID); user != nil {
+		msg.User = user.Name
+	}
+
 	return nil
 }
 
 // accept marks that message is allowed into system.
-func (b *Broadcaster) accept(ctx context.Context, msg *message.Message) {
-	// Setup server timestamp.
+// Change of the message is accepted only if it is applied to the stored message.
+func (b *Broadcaster) accept(ctx context.Context, msg *message.Message) error {
+	switch body := msg.Body.(type) {
+	case *message.EditBody:
+		return b.change(ctx, msg, body.MessageID)
+	case *message.ReactionBody:
+		return b.change(ctx, msg, body.MessageID)
+	case *message.ReadBody:
+		return b.markRead(ctx, msg, body)
+	case *message.PinBody:
+		return b.pin(ctx, msg, body)
+	case *message.ReviewBody:
+		// Released message is accepted as a new one, having passed moderation.
+		released, err := b.review(ctx, msg, body)
+		if err != nil || released == nil {
+			return err
+		}
+		*msg = *released
+	case *message.AttachmentBody:
+		if err := b.attach(msg, body); err != nil {
+			return err
+		}
+	}
+
+	// New message has no history of changes, whatever client claims; it is changed by edits and deletes only.
+	msg.ResetChanges()
+	// Thread summary is kept by the server as replies are accepted.
+	msg.Thread = nil
+	// Reactions are added by users reacting to the accepted message.
+	msg.Reactions = nil
+
+	if msg.IsReply() {
+		// Replies to replies go to the same thread, threads are not nested.
+		parent, err := b.messageStore.GetMessage(ctx, msg.RoomID, msg.ParentID)
+		if err != nil {
+			return fmt.Errorf("parent message: %w", err)
+		}
+		if parent.IsDeleted() {
+			return fmt.Errorf("parent message: %w", message.ErrMessageDeleted)
+		}
+		if parent.IsReply() {
+			msg.ParentID = parent.ParentID
+		}
+	}
+
+	// Mentions are resolved by the server only.
+	msg.Mentions = nil
+	if err := b.mention(ctx, msg); err != nil {
+		// Not fatal, message is still delivered, though mentioned users are not notified.
+		b.logger.Printf("Mentions could not be resolved: %v\n", err)
+	}
+
+	// Setup server timestamp and identity.
 	msg.ServerTime = time.Now()
-	// TODO: assign unique ID, possibly logical clock.
+	msg.ID = domain.NewID()
+	if err := b.setExpiry(ctx, msg); err != nil {
+		return err
+	}
+	// Notifications not related to any room are not part of any history.
+	if msg.RoomID == "" {
+		return nil
+	}
+	seq, err := b.clock.Next(ctx, msg.RoomID)
+	if err != nil {
+		// Not fatal, message is still delivered, but without sequence it is not stored.
+		b.logger.Printf("Message sequence could not be assigned: %v\n", err)
+		return nil
+	}
+	msg.Seq = seq
 	// Add message to persistent storage.
-	if err := b.messageStore.SaveMessage(ctx, msg.Room, msg); err != nil {
+	if err := b.messageStore.SaveMessage(ctx, msg.RoomID, msg); err != nil {
 		// Not fatal, just continue without message retention.
 		b.logger.Printf("Message could not be stored: %v\n", err)
+		return nil
+	}
+	// Author has read everything up to own message; cursor is stored along with others moved meanwhile.
+	if msg.UserID != "" {
+		b.authorReads[postKey{roomID: msg.RoomID, userID: msg.UserID}] = msg.Seq
+	}
+	// Make stored message searchable.
+	b.searchIndex.Add(msg)
+	b.TrackExpiry(msg)
+	return nil
+}
+
+// storeAuthorReads moves read cursors of authors to their latest messages,
+// so that repository is written once per author and room rather than on every message.
+func (b *Broadcaster) storeAuthorReads(ctx context.Context) {
+	for key, seq := range b.authorReads {
+		if _, err := b.repo.MarkRead(ctx, key.userID, key.roomID, seq); err != nil {
+			b.logger.Printf("Read cursor could not be moved: %v\n", err)
+		}
+		delete(b.authorReads, key)
+	}
+}
+
+// checkParticipant makes sure that author of the message participates in its room.
+func (b *Broadcaster) checkParticipant(ctx context.Context, msg *message.Message) error {
+	participants, err := b.repo.ListParticipants(ctx, msg.RoomID)
+	if err != nil {
+		return err
+	}
+	if !slices.ContainsFunc(participants, func(u *domain.User) bool { return u.ID == msg.UserID }) {
+		return errors.New("user does not participate in the room")
+	}
+	return nil
+}
+
+// markRead moves read cursor of the room participant, not beyond the latest message of the room.
+func (b *Broadcaster) markRead(ctx context.Context, msg *message.Message, body *message.ReadBody) error {
+	if err := b.checkParticipant(ctx, msg); err != nil {
+		return err
+	}
+	lastSeq, err := b.messageStore.LastSeq(ctx, msg.RoomID)
+	if err != nil {
+		return err
+	}
+	body.Seq = min(body.Seq, lastSeq)
+	if _, err := b.repo.MarkRead(ctx, msg.UserID, msg.RoomID, body.Seq); err != nil {
+		return err
+	}
+	msg.ServerTime = time.Now()
+	return nil
+}
+
+// pin pins or unpins the message on behalf of the room creator or moderator.
+// Only messages which are stored and not deleted can be pinned.
+func (b *Broadcaster) pin(ctx context.Context, msg *message.Message, body *message.PinBody) error {
+	if !b.repo.CanModerate(ctx, msg.RoomID, msg.UserID) {
+		return errors.New("only room creator and moderators can pin messages")
+	}
+	if body.Unpin {
+		if err := b.repo.UnpinMessage(ctx, msg.RoomID, body.MessageID); err != nil {
+			return err
+		}
+	} else {
+		stored, err := b.messageStore.GetMessage(ctx, msg.RoomID, body.MessageID)
+		if err != nil {
+			return err
+		}
+		if stored.IsDeleted() {
+			return message.ErrMessageDeleted
+		}
+		pin := domain.Pin{MessageID: stored.ID, PinnedBy: msg.UserID, PinnedAt: time.Now()}
+		if err := b.repo.PinMessage(ctx, msg.RoomID, pin); err != nil {
+			return err
+		}
+	}
+	msg.ServerTime = time.Now()
+	return nil
+}
+
+// unpinGone drops pin of the deleted or expired message, if it was pinned.
+func (b *Broadcaster) unpinGone(ctx context.Context, roomID, msgID string) {
+	if err := b.repo.UnpinMessage(ctx, roomID, msgID); err != nil && !errors.Is(err, domain.ErrPinNotFound) {
+		b.logger.Printf("Pin of message %s could not be dropped: %v\n", msgID, err)
+	}
+}
+
+// attach checks that the file referenced by attachment was uploaded to the room of the message
+// and describes it by the stored file, so that clients cannot misrepresent it.
+func (b *Broadcaster) attach(msg *message.Message, body *message.AttachmentBody) error {
+	if b.blobStore == nil {
+		return errors.New("attachments are not supported")
+	}
+	stored, err := b.blobStore.Stat(body.BlobID)
+	if err != nil {
+		return fmt.Errorf("attachment: %w", err)
+	}
+	if !stored.InRoom(msg.RoomID) {
+		return fmt.Errorf("attachment: %w", blob.ErrNotFound)
+	}
+	msg.Body = &message.AttachmentBody{
+		BlobID:    stored.ID,
+		Name:      path.Base("/" + body.Name),
+		MIMEType:  stored.MIMEType,
+		Size:      stored.Size,
+		Thumbnail: stored.HasThumbnail,
+	}
+	return nil
+}
+
+// mention resolves users mentioned in the text to IDs of room participants, except the author:
+// @here addresses participants currently online, @room all of them.
+// Mentions of users not participating in the room are ignored.
+func (b *Broadcaster) mention(ctx context.Context, msg *message.Message) error {
+	names := message.ParseMentions(msg.Text())
+	if len(names) == 0 || msg.RoomID == "" {
+		return nil
+	}
+	participants, err := b.repo.ListParticipants(ctx, msg.RoomID)
+	if err != nil {
+		return err
+	}
+
+	mentions := &message.Mentions{}
+	mentioned := map[string]bool{msg.UserID: true}
+	add := func(user *domain.User) {
+		if !mentioned[user.ID] {
+			mentioned[user.ID] = true
+			mentions.UserIDs = append(mentions.UserIDs, user.ID)
+		}
+	}
+	for _, name := range names {
+		switch name {
+		case message.HereMention:
+			mentions.Here = true
+			for _, user := range participants {
+				if b.IsRegistered(user) {
+					add(user)
+				}
+			}
+		case message.RoomMention:
+			mentions.Room = true
+			for _, user := range participants {
+				add(user)
+			}
+		default:
+			for _, user := range participants {
+				if user.Name == name {
+					add(user)
+				}
+			}
+		}
+	}
+	if len(mentions.UserIDs) > 0 || mentions.Here || mentions.Room {
+		msg.Mentions = mentions
+	}
+	return nil
+}
+
+// change edits or deletes stored message on behalf of its author,
+// or adds or removes reaction of any room participant.
+// Inbound message is replaced with the new version of the stored one, marked with the action,
+// so that clients could update the message with the same ID in their logs.
+func (b *Broadcaster) change(ctx context.Context, msg *message.Message, targetID string) error {
+	stored, err := b.messageStore.GetMessage(ctx, msg.RoomID, targetID)
+	if err != nil {
+		return err
+	}
+
+	var (
+		changed *message.Message
+		action  string
+	)
+	switch body := msg.Body.(type) {
+	case *message.EditBody:
+		if body.Delete {
+			if err := stored.CanChange(msg.UserID); err != nil {
+				return err
+			}
+			changed, action = stored.Delete(time.Now()), message.DeleteAction
+		} else {
+			if err := stored.CanEdit(msg.UserID); err != nil {
+				return err
+			}
+			changed, action = stored.Edit(body.Text, time.Now()), message.EditAction
+		}
+	case *message.ReactionBody:
+		if err := b.checkParticipant(ctx, msg); err != nil {
+			return err
+		}
+		if stored.IsDeleted() {
+			return message.ErrMessageDeleted
+		}
+		if body.Remove {
+			changed, action = stored.Unreact(body.Emoji, msg.UserID), message.UnreactAction
+		} else {
+			changed, action = stored.React(body.Emoji, msg.UserID), message.ReactAction
+		}
+	}
+	// Repeated reaction does not change anything, but clients are still updated.
+	if changed != stored {
+		if err := b.messageStore.ReplaceMessage(ctx, msg.RoomID, changed); err != nil {
+			return err
+		}
 	}
+	switch {
+	case changed.IsDeleted():
+		b.searchIndex.Remove(changed.ID)
+		b.unpinGone(ctx, msg.RoomID, changed.ID)
+	case action == message.EditAction:
+		b.searchIndex.Add(changed)
+	}
+
+	*msg = *changed
+	msg.Action = action
+	return nil
 }
 
-// dispatch determines only those users to whom message will be broadcasted.
-func (b *Broadcaster) dispatch(ctx context.Context, msg *message.Message) ([]*UserSocket, error) {
+// event is a message with its destination.
+type event struct {
+	msg         *message.Message
+	destination []*UserSocket
+}
+
+// dispatch determines messages to broadcast for the accepted message and their destinations.
+// Besides the message itself, accepted reply results in thread update event,
+// which carries root message with updated summary of the thread.
+// Read reports are dispatched as "seen by" updates, unless those are disabled.
+func (b *Broadcaster) dispatch(ctx context.Context, msg *message.Message) ([]*event, error) {
+	if _, ok := msg.Body.(*message.ReadBody); ok && !b.seenBy {
+		return nil, nil
+	}
+	destination, err := b.destination(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	events := []*event{{msg: msg, destination: destination}}
+
+	if msg.Mentions != nil && msg.Action == "" {
+		events = append(events, b.mentionEvent(msg))
+	}
+
+	if msg.IsReply() && msg.Action == "" && msg.Seq != 0 {
+		root, err := b.updateThread(ctx, msg)
+		if err != nil {
+			// Not fatal, reply itself is delivered anyway.
+			b.logger.Printf("Thread of message %s could not be updated: %v\n", msg.ParentID, err)
+		} else {
+			events = append(events, &event{msg: root, destination: destination})
+		}
+	}
+	return events, nil
+}
+
+// mentionEvent delivers the message to sockets of mentioned users once more, marked as a mention,
+// so that clients could notify users even if they are looking at another room.
+func (b *Broadcaster) mentionEvent(msg *message.Message) *event {
+	mention := *msg
+	mention.Action = message.MentionAction
+	sockets := []*UserSocket{}
+	for socket := range b.sockets {
+		if msg.Mentioned(socket.user.ID) {
+			sockets = append(sockets, socket)
+		}
+	}
+	return &event{msg: &mention, destination: sockets}
+}
+
+// updateThread counts the reply on its root message and returns thread update event for it.
+func (b *Broadcaster) updateThread(ctx context.Context, reply *message.Message) (*message.Message, error) {
+	root, err := b.messageStore.GetMessage(ctx, reply.RoomID, reply.ParentID)
+	if err != nil {
+		return nil, err
+	}
+	root = root.WithReply(reply)
+	if err := b.messageStore.ReplaceMessage(ctx, reply.RoomID, root); err != nil {
+		return nil, err
+	}
+	update := *root
+	update.Action = message.ThreadAction
+	return &update, nil
+}
+
+// destination determines only those users to whom message will be broadcasted.
+func (b *Broadcaster) destination(ctx context.Context, msg *message.Message) ([]*UserSocket, error) {
 	sockets := []*UserSocket{}
 
 	// Notifications are going to everyone.
-	if msg.IsNotification {
+	if msg.IsNotification() {
 		for socket := range b.sockets {
 			sockets = append(sockets, socket)
 		}
 		return sockets, nil
 	}
 
+	// Moderation notices are going to their addressee only.
+	if _, ok := msg.Body.(*message.ModerationBody); ok {
+		return b.userSockets(msg.UserID), nil
+	}
+
 	// Main rule for this chat: message is broadcasted only to users who joined the same room.
-	usersInSameRoom, err := b.repo.ListParticipants(ctx, msg.Room)
+	usersInSameRoom, err := b.repo.ListParticipants(ctx, msg.RoomID)
 	if err != nil {
 		return nil, err
 	}
-	index := map[*domain.User]bool{}
+	index := map[string]bool{}
 	for _, user := range usersInSameRoom {
-		index[user] = true
+		index[user.ID] = true
 	}
 
 	// Dispatch message to active users participating in the same room.
 	for socket := range b.sockets {
-		if _, ok := index[socket.user]; ok {
+		if _, ok := index[socket.user.ID]; ok {
 			sockets = append(sockets, socket)
 		}
 	}
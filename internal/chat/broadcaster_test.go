@@ -0,0 +1,392 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/moderation"
+	"github.com/lennylebedinsky/chatter/internal/ratelimit"
+	"github.com/lennylebedinsky/chatter/internal/search"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+// testChat is a running broadcaster with a room created by alice, which bob joined and carol did not,
+// and a socket of each of them registered without a connection.
+type testChat struct {
+	broadcaster *Broadcaster
+	repo        domain.Repository
+	moderation  *moderation.Pipeline
+	room        *domain.Room
+	sockets     map[string]*UserSocket
+	requests    chan *request
+}
+
+func newTestChat(t *testing.T, limits RateLimits) *testChat {
+	t.Helper()
+	ctx := context.Background()
+	repo := domain.NewInMemoryRepository()
+	alice, _ := repo.CreateUser(ctx, "alice")
+	bob, _ := repo.CreateUser(ctx, "bob")
+	carol, _ := repo.CreateUser(ctx, "carol")
+	room, err := repo.CreateRoom(ctx, "lobby", alice.ID)
+	if err != nil {
+		t.Fatalf("Repository.CreateRoom() error = %v", err)
+	}
+	if err := repo.JoinRoom(ctx, bob.ID, room.ID); err != nil {
+		t.Fatalf("Repository.JoinRoom() error = %v", err)
+	}
+
+	pipeline := moderation.NewPipeline(moderation.DefaultFilters()...)
+	store := message.NewInMemoryStore(message.RetentionPolicy{}, nil, testLogger)
+	b := NewBroadcaster(repo, store, search.NewIndex(), nil, pipeline, testLogger)
+	b.SetRateLimits(limits)
+
+	ctx, cancel := context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	go func() {
+		b.Start(ctx)
+		close(stopped)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-stopped
+	})
+
+	c := &testChat{broadcaster: b, repo: repo, moderation: pipeline, room: room, sockets: map[string]*UserSocket{}, requests: b.requests}
+	for _, user := range []*domain.User{alice, bob, carol} {
+		socket := NewUserSocket(user, nil, b, nil, testLogger)
+		b.Register() <- socket
+		<-socket.registered
+		c.sockets[user.Name] = socket
+	}
+	return c
+}
+
+// send passes message to broadcaster the way read loop of the user socket does.
+func (c *testChat) send(userName, clientID string, msg *message.Message) {
+	socket := c.sockets[userName]
+	msg.UserID = socket.user.ID
+	msg.User = socket.user.Name
+	c.requests <- &request{msg: msg, sender: socket, clientID: clientID}
+}
+
+// next returns the next message queued for the user.
+func (c *testChat) next(t *testing.T, userName string) *message.Message {
+	t.Helper()
+	select {
+	case msg := <-c.sockets[userName].outbound:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatalf("no message for user %s", userName)
+		return nil
+	}
+}
+
+// answer returns the next ack, error or moderation notice queued for the user, skipping other messages.
+func (c *testChat) answer(t *testing.T, userName string) *message.Message {
+	t.Helper()
+	for {
+		msg := c.next(t, userName)
+		switch msg.Body.(type) {
+		case *message.AckBody, *message.ErrorBody, *message.ModerationBody:
+			return msg
+		}
+	}
+}
+
+// outcome tells how the message was answered: "ack", error code or moderation outcome.
+func outcome(answer *message.Message) string {
+	switch body := answer.Body.(type) {
+	case *message.AckBody:
+		return "ack"
+	case *message.ErrorBody:
+		return body.Code
+	case *message.ModerationBody:
+		return body.Outcome
+	}
+	return ""
+}
+
+func testText(roomID, text string) *message.Message {
+	return &message.Message{RoomID: roomID, Body: &message.TextBody{Text: text}}
+}
+
+func TestBroadcaster_Ack(t *testing.T) {
+	c := newTestChat(t, RateLimits{})
+	c.send("bob", "c1", testText(c.room.ID, "hello"))
+
+	delivered := c.next(t, "alice")
+	if delivered.Text() != "hello" || delivered.ClientID != "" {
+		t.Errorf("delivered message = %v with client ID %q, want text without client ID", delivered, delivered.ClientID)
+	}
+	own := c.next(t, "bob")
+	if own.ID != delivered.ID || own.ClientID != "" {
+		t.Errorf("message delivered to author = %v with client ID %q, want the same message without client ID", own, own.ClientID)
+	}
+	answer := c.answer(t, "bob")
+	ack, ok := answer.Body.(*message.AckBody)
+	if !ok || answer.ClientID != "c1" {
+		t.Fatalf("answer = %v with client ID %q, want ack with client ID c1", answer, answer.ClientID)
+	}
+	if ack.MessageID != delivered.ID || ack.Seq != delivered.Seq || ack.Seq == 0 {
+		t.Errorf("ack = %+v, want ID and sequence number of message %s #%d", ack, delivered.ID, delivered.Seq)
+	}
+}
+
+func TestBroadcaster_Rejections(t *testing.T) {
+	type step struct {
+		sender string
+		msg    func(c *testChat) *message.Message
+		want   string
+	}
+	text := func(text string) func(c *testChat) *message.Message {
+		return func(c *testChat) *message.Message { return testText(c.room.ID, text) }
+	}
+	tests := []struct {
+		name   string
+		limits RateLimits
+		setup  func(t *testing.T, c *testChat)
+		steps  []step
+	}{
+		{
+			name: "Message to unknown room should be invalid",
+			steps: []step{
+				{sender: "bob", msg: func(c *testChat) *message.Message { return testText("nowhere", "hello") }, want: message.InvalidError},
+			},
+		},
+		{
+			name: "Edit of unknown message should not be accepted",
+			steps: []step{
+				{sender: "bob", msg: func(c *testChat) *message.Message {
+					return &message.Message{RoomID: c.room.ID, Body: &message.EditBody{MessageID: "m0", Text: "hi"}}
+				}, want: message.NotAcceptedError},
+			},
+		},
+		{
+			name:   "Messages over user rate limit should be rate limited",
+			limits: RateLimits{User: ratelimit.Limit{Rate: 0.001, Burst: 2}},
+			steps: []step{
+				{sender: "bob", msg: text("one"), want: "ack"},
+				{sender: "bob", msg: text("two"), want: "ack"},
+				{sender: "bob", msg: text("three"), want: message.RateLimitedError},
+				{sender: "alice", msg: text("four"), want: "ack"},
+			},
+		},
+		{
+			name:   "Messages over room rate limit should be rate limited",
+			limits: RateLimits{Room: ratelimit.Limit{Rate: 0.001, Burst: 1}},
+			steps: []step{
+				{sender: "bob", msg: text("one"), want: "ack"},
+				{sender: "alice", msg: text("two"), want: message.RateLimitedError},
+			},
+		},
+		{
+			name: "Posts in slow mode should be slowed down, except those of moderators",
+			setup: func(t *testing.T, c *testChat) {
+				if err := c.repo.SetSlowMode(context.Background(), c.room.ID, time.Hour); err != nil {
+					t.Fatalf("Repository.SetSlowMode() error = %v", err)
+				}
+			},
+			steps: []step{
+				{sender: "bob", msg: text("one"), want: "ack"},
+				{sender: "bob", msg: text("two"), want: message.SlowModeError},
+				{sender: "alice", msg: text("three"), want: "ack"},
+				{sender: "alice", msg: text("four"), want: "ack"},
+			},
+		},
+		{
+			name:   "Messages rejected by moderation should not spend tokens",
+			limits: RateLimits{User: ratelimit.Limit{Rate: 0.001, Burst: 2}},
+			setup: func(t *testing.T, c *testChat) {
+				if err := c.moderation.SetRules(c.room.ID, moderation.Rules{SpamRepeats: 1, SpamWindow: time.Minute}); err != nil {
+					t.Fatalf("Pipeline.SetRules() error = %v", err)
+				}
+			},
+			steps: []step{
+				{sender: "bob", msg: text("buy now"), want: "ack"},
+				{sender: "bob", msg: text("buy now"), want: string(moderation.Reject)},
+				{sender: "bob", msg: text("sorry"), want: "ack"},
+				{sender: "bob", msg: text("again"), want: message.RateLimitedError},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestChat(t, tt.limits)
+			if tt.setup != nil {
+				tt.setup(t, c)
+			}
+			for i, step := range tt.steps {
+				clientID := string(rune('a' + i))
+				c.send(step.sender, clientID, step.msg(c))
+				answer := c.answer(t, step.sender)
+				if got := outcome(answer); got != step.want {
+					t.Errorf("step %d: answer = %s (%v), want %s", i+1, got, answer.Body, step.want)
+				}
+				if answer.ClientID != clientID {
+					t.Errorf("step %d: answer client ID = %q, want %q", i+1, answer.ClientID, clientID)
+				}
+				if body, ok := answer.Body.(*message.ErrorBody); ok && body.Code == message.RateLimitedError && body.RetryAfter <= 0 {
+					t.Errorf("step %d: rate limited answer does not tell when to retry", i+1)
+				}
+			}
+		})
+	}
+}
+
+func TestBroadcaster_ReactionOfNonParticipant(t *testing.T) {
+	c := newTestChat(t, RateLimits{})
+	c.send("alice", "", testText(c.room.ID, "hello"))
+	target := c.next(t, "bob")
+	c.next(t, "alice")
+
+	react := func(userName, clientID string) {
+		c.send(userName, clientID, &message.Message{RoomID: c.room.ID, Body: &message.ReactionBody{MessageID: target.ID, Emoji: "👍"}})
+	}
+	react("carol", "c1")
+	if answer := c.answer(t, "carol"); outcome(answer) != message.NotAcceptedError {
+		t.Errorf("answer to reaction of user who does not participate = %s (%v), want %s", outcome(answer), answer.Body, message.NotAcceptedError)
+	}
+	react("bob", "c2")
+	if answer := c.answer(t, "bob"); outcome(answer) != "ack" {
+		t.Errorf("answer to reaction of participant = %s (%v), want ack", outcome(answer), answer.Body)
+	}
+	if reacted := c.next(t, "alice"); !slices.Equal(reacted.Reactions["👍"], []string{c.sockets["bob"].user.ID}) {
+		t.Errorf("reactions = %v, want only the one of bob", reacted.Reactions)
+	}
+}
+
+func TestBroadcaster_Submit(t *testing.T) {
+	c := newTestChat(t, RateLimits{})
+	if err := c.repo.SetSlowMode(context.Background(), c.room.ID, time.Hour); err != nil {
+		t.Fatalf("Repository.SetSlowMode() error = %v", err)
+	}
+	bob := c.sockets["bob"].user
+	submit := func(text string) error {
+		msg := testText(c.room.ID, text)
+		msg.UserID, msg.User = bob.ID, bob.Name
+		return c.broadcaster.Submit(context.Background(), msg)
+	}
+
+	if err := submit("one"); err != nil {
+		t.Errorf("Broadcaster.Submit() error = %v, want message accepted", err)
+	}
+	if err := submit("two"); err == nil {
+		t.Errorf("Broadcaster.Submit() of message in slow mode accepted it")
+	}
+	// Author's socket is answered as well.
+	if answer := c.answer(t, "bob"); outcome(answer) != message.SlowModeError {
+		t.Errorf("answer = %s (%v), want %s", outcome(answer), answer.Body, message.SlowModeError)
+	}
+}
+
+func TestBroadcaster_AcceptDropsClaimedState(t *testing.T) {
+	c := newTestChat(t, RateLimits{})
+	claimed := time.Now().Add(-time.Hour)
+	msg := testText(c.room.ID, "hello")
+	msg.Reactions = map[string][]string{"👍": {"u1", "u2"}}
+	msg.Thread = &message.Thread{ReplyCount: 42, LastReplyAt: claimed}
+	msg.EditedAt = &claimed
+	msg.Revisions = []message.Revision{{Text: "hi", ReplacedAt: claimed}}
+	msg.DeletedAt = &claimed
+	c.send("bob", "", msg)
+
+	delivered := c.next(t, "alice")
+	if delivered.Reactions != nil || delivered.Thread != nil {
+		t.Errorf("delivered message has reactions %v and thread %v claimed by client", delivered.Reactions, delivered.Thread)
+	}
+	if delivered.EditedAt != nil || delivered.Revisions != nil || delivered.IsDeleted() {
+		t.Errorf("delivered message has changes claimed by client: %v", delivered)
+	}
+	if delivered.Text() != "hello" {
+		t.Errorf("delivered message text = %q, want hello", delivered.Text())
+	}
+}
+
+func TestUserSocket_QueueLive(t *testing.T) {
+	c := newTestChat(t, RateLimits{})
+	socket := NewUserSocket(c.sockets["bob"].user, nil, c.sockets["bob"].broadcaster, nil, testLogger)
+	for _, text := range []string{"one", "two", "three"} {
+		socket.outbound <- testText(c.room.ID, text)
+	}
+
+	queue, open := socket.queueLive([]*message.Message{testText(c.room.ID, "missed")}, 3)
+	if len(queue) != 3 || !open {
+		t.Fatalf("UserSocket.queueLive() = %d messages, open %v, want 3 messages up to the limit, open", len(queue), open)
+	}
+	if queue[0].Text() != "missed" || queue[1].Text() != "one" || queue[2].Text() != "two" {
+		t.Errorf("UserSocket.queueLive() queued live messages out of order")
+	}
+
+	close(socket.outbound)
+	queue, open = socket.queueLive(queue[1:], 10)
+	if len(queue) != 3 || open {
+		t.Errorf("UserSocket.queueLive() = %d messages, open %v, want the rest queued and channel closed", len(queue), open)
+	}
+}
+
+// removalFailingStore fails to remove the message with the given ID.
+type removalFailingStore struct {
+	message.Store
+	failing string
+}
+
+func (s *removalFailingStore) RemoveMessage(ctx context.Context, roomID string, msgID string) error {
+	if msgID == s.failing {
+		return errors.New("disk is full")
+	}
+	return s.Store.RemoveMessage(ctx, roomID, msgID)
+}
+
+func TestBroadcaster_ExpireRetriesFailedRemoval(t *testing.T) {
+	ctx := context.Background()
+	repo := domain.NewInMemoryRepository()
+	alice, _ := repo.CreateUser(ctx, "alice")
+	room, err := repo.CreateRoom(ctx, "lobby", alice.ID)
+	if err != nil {
+		t.Fatalf("Repository.CreateRoom() error = %v", err)
+	}
+	store := &removalFailingStore{Store: message.NewInMemoryStore(message.RetentionPolicy{}, nil, testLogger), failing: "m1"}
+	b := NewBroadcaster(repo, store, search.NewIndex(), nil, moderation.NewPipeline(), testLogger)
+
+	now := time.Now()
+	expiresAt := now.Add(-time.Minute)
+	for i, id := range []string{"m1", "m2"} {
+		msg := &message.Message{
+			ID: id, Seq: uint64(i + 1), UserID: alice.ID, User: alice.Name, RoomID: room.ID, Room: room.Name,
+			ServerTime: expiresAt.Add(-time.Hour), ExpiresAt: &expiresAt, Body: &message.TextBody{Text: id},
+		}
+		if err := store.SaveMessage(ctx, room.ID, msg); err != nil {
+			t.Fatalf("Store.SaveMessage() error = %v", err)
+		}
+		b.TrackExpiry(msg)
+	}
+
+	b.expire(ctx, now)
+	if _, err := store.GetMessage(ctx, room.ID, "m2"); !errors.Is(err, message.ErrMessageNotFound) {
+		t.Errorf("message expiring after the one which failed to be removed is still stored: %v", err)
+	}
+	if len(b.expiry) != 1 || b.expiry[0].msgID != "m1" || !b.expiry[0].expiresAt.After(now) {
+		t.Fatalf("expiry queue = %v, want failed message to be tried again later", b.expiry)
+	}
+
+	// Delay doubles with every failure, up to the limit.
+	for range 10 {
+		due := b.expiry[0].expiresAt
+		b.expire(ctx, due)
+		if delay := b.expiry[0].expiresAt.Sub(due); delay > maxExpiryBackoff {
+			t.Errorf("removal is tried again in %v, want at most %v", delay, maxExpiryBackoff)
+		}
+	}
+	if delay := b.expiry[0].expiresAt.Sub(now); delay < 2*maxExpiryBackoff {
+		t.Errorf("removal failing 11 times is tried again in %v since the first failure, want backoff", delay)
+	}
+}
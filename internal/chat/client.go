@@ -1,13 +1,43 @@
 package chat
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"log"
+	"time"
 
 	"github.com/gorilla/websocket"
 	"github.com/lennylebedinsky/chatter/internal/domain"
 	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/ratelimit"
 )
 
+// Websocket subprotocols defining wire format of messages.
+// JSON is used by default, if client does not ask for any subprotocol.
+const (
+	JSONProtocol   = "chatter.json.v1"
+	BinaryProtocol = "chatter.binary.v1"
+)
+
+// Subprotocols lists supported subprotocols in order of preference.
+var Subprotocols = []string{BinaryProtocol, JSONProtocol}
+
+// outboundBufferSize is a number of messages queued for the client before it is considered stuck.
+// Single accepted message can result in several messages to the same client, e.g. reply and thread update.
+const outboundBufferSize = 64
+
+// directBufferSize is a number of answers queued for the client by the socket itself;
+// client which sends messages faster than it reads answers does not get all of them.
+const directBufferSize = 8
+
+// liveQueueSize is a number of live messages queued by the socket while it replays missed ones.
+// Client which gets more of them during replay is considered stuck.
+const liveQueueSize = 1024
+
+// Close reason sent to the client which does not keep up with messages, so that it reconnects and resumes.
+const overflowCloseReason = "messages are not delivered in time, reconnect to resume"
+
 type UserSocket struct {
 	user *domain.User
 	conn *websocket.Conn
@@ -15,6 +45,20 @@ type UserSocket struct {
 	broadcaster *Broadcaster
 
 	outbound chan *message.Message
+	// Answers to the client sent by the socket itself, bypassing broadcaster.
+	direct chan *message.Message
+	// Limits messages coming from the connection.
+	limit *ratelimit.Bucket
+
+	// Latest sequence number per room acknowledged by the client before it reconnected.
+	resume map[string]uint64
+	// Missed messages to be sent before live ones, set by broadcaster on registration.
+	replay []replayRange
+	// Closed when socket is registered with broadcaster.
+	registered chan struct{}
+	// Set by broadcaster when it closes outbound channel because client does not keep up.
+	overflowed bool
+	delivery   *delivery
 
 	logger *log.Logger
 }
@@ -23,18 +67,26 @@ func NewUserSocket(
 	user *domain.User,
 	conn *websocket.Conn,
 	broadcaster *Broadcaster,
+	resume map[string]uint64,
 	logger *log.Logger) *UserSocket {
 	return &UserSocket{
 		user:        user,
 		conn:        conn,
 		broadcaster: broadcaster,
-		outbound:    make(chan *message.Message),
+		outbound:    make(chan *message.Message, outboundBufferSize),
+		direct:      make(chan *message.Message, directBufferSize),
+		limit:       ratelimit.NewBucket(broadcaster.rateLimits.Connection),
+		resume:      resume,
+		registered:  make(chan struct{}),
+		delivery:    newDelivery(),
 		logger:      logger,
 	}
 }
 
 // ReadLoop listens to messages coming from client's side of Websocket connection
-// and redirects them to broadcaster.
+// and redirects them to broadcaster along with client IDs to answer them with.
+// Acknowledgements are consumed by the socket itself, and messages which are malformed,
+// sent on behalf of the service or exceed rate limit of the connection are answered with an error right away.
 // It is supposed to run as goroutine, one read loop per client.
 func (s *UserSocket) ReadLoop() {
 	defer func() {
@@ -42,48 +94,191 @@ func (s *UserSocket) ReadLoop() {
 		s.conn.Close()
 	}()
 	for {
-		msg := &message.Message{}
-		err := s.conn.ReadJSON(msg)
+		messageType, data, err := s.conn.ReadMessage()
 		if err != nil {
-			// If connection had been closed from client's side, break the loop.
+			// Connection cannot be read any further, whether it was closed from client's side or failed.
 			if closeErr, ok := err.(*websocket.CloseError); ok {
 				s.logger.Printf("Connection closed for user %s: %v\n", s.user.Name, closeErr)
-				return
 			} else {
 				s.logger.Printf("Error reading message for user %s: %v\n", s.user.Name, err)
 			}
+			return
+		}
+		msg := &message.Message{}
+		if err := s.decode(messageType, data, msg); err != nil {
+			s.logger.Printf("Discarding malformed message from user %s: %v\n", s.user.Name, err)
+			s.reply(&request{sender: s}, rejection(msg, message.MalformedError, err.Error(), 0))
+			continue
+		}
+		if ack, ok := msg.Body.(*message.AckBody); ok {
+			s.delivery.ack(msg.RoomID, ack.Seq)
+			continue
+		}
+		// Client ID is only used to answer the client, it is not part of the message.
+		req := &request{msg: msg, sender: s, clientID: msg.ClientID}
+		msg.ClientID = ""
+		// Clients are not allowed to send system messages on behalf of the service.
+		if msg.IsNotification() || msg.Type() == message.MembershipKind {
+			s.logger.Printf("Discarding %s message from user %s.\n", msg.Type(), s.user.Name)
+			s.reply(req, rejection(msg, message.InvalidError, "system messages cannot be sent by clients", 0))
+			continue
+		}
+		// Author is always the user who owns the socket, regardless of what client claims.
+		msg.UserID = s.user.ID
+		msg.User = s.user.Name
+		// Action is only set by broadcaster for new versions of changed messages.
+		msg.Action = ""
+		if ok, wait := s.limit.Take(time.Now()); !ok {
+			rateLimited.Add("connection", 1)
+			s.logger.Printf("Discarding message from user %s exceeding connection rate limit.\n", s.user.Name)
+			s.reply(req, rejection(msg, message.RateLimitedError, "you are sending messages too fast", wait))
+			continue
 		}
 		s.logger.Printf("Received message: %v\n", msg)
-		s.broadcaster.message <- msg
+		s.broadcaster.requests <- req
 	}
 }
 
 // Write listens to messages coming from broadcaster and
 // redirects them  client's side of Websocket connection.
+// Messages missed by reconnected client are replayed from the store before live ones.
 // It is supposed to run as goroutine, one read loop per client.
 func (s *UserSocket) WriteLoop() {
 	defer func() {
 		s.conn.Close()
 	}()
+	<-s.registered
+	missed, err := s.missed(context.Background())
+	if err != nil {
+		// Client resumes again after reconnect, so nothing is lost.
+		s.logger.Printf("Error replaying messages for user %s: %v\n", s.user.Name, err)
+		return
+	}
+	// Live messages coming while missed ones are replayed are queued after them, so that outbound channel
+	// does not fill up meanwhile and broadcaster does not take resuming client for stuck one.
+	open := true
+	limit := len(missed) + liveQueueSize
+	for len(missed) > 0 {
+		if open {
+			missed, open = s.queueLive(missed, limit)
+		}
+		if err := s.send(missed[0]); err != nil {
+			return
+		}
+		missed = missed[1:]
+	}
+	if !open {
+		s.close()
+		return
+	}
 	for {
 		select {
 		case message, ok := <-s.outbound:
 			// If broadcaster closed channel from its side, initiate closing handshake.
 			if !ok {
-				s.conn.WriteMessage(websocket.CloseMessage, []byte{})
+				s.close()
 				return
 			}
-			err := s.conn.WriteJSON(message)
-			if err != nil {
-				// If connection had been closed from client's side, break the loop.
-				if closeErr, ok := err.(*websocket.CloseError); ok {
-					s.logger.Printf("Connection closed for user %s: %v\n", s.user.Name, closeErr)
-					return
-				} else {
-					s.logger.Printf("Error writing message for user %s: %v\n", s.user.Name, err)
-				}
+			if err := s.send(message); err != nil {
+				return
+			}
+		case message := <-s.direct:
+			if err := s.send(message); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// missed returns messages of rooms client resumed, which it missed while it was away.
+func (s *UserSocket) missed(ctx context.Context) ([]*message.Message, error) {
+	all := []*message.Message{}
+	for _, r := range s.replay {
+		missed, err := message.Missed(ctx, s.broadcaster.messageStore, r.RoomID, r.After, r.Until)
+		if err != nil {
+			return nil, err
+		}
+		if len(missed) > 0 {
+			s.logger.Printf("Replaying %d messages of room %s to user %s\n", len(missed), r.RoomID, s.user.Name)
+		}
+		all = append(all, missed...)
+	}
+	return all, nil
+}
+
+// queueLive moves messages waiting in outbound channel to the queue, as long as it is shorter than limit.
+// It tells if the channel is still open, that is broadcaster did not close it.
+func (s *UserSocket) queueLive(queue []*message.Message, limit int) ([]*message.Message, bool) {
+	for len(queue) < limit {
+		select {
+		case msg, ok := <-s.outbound:
+			if !ok {
+				return queue, false
 			}
-			s.logger.Printf("Sent message %v to user %s\n", message, s.user.Name)
+			queue = append(queue, msg)
+		default:
+			return queue, true
 		}
 	}
+	return queue, true
+}
+
+// reply queues answer to the request for the client without passing it through broadcaster.
+// Answer is dropped if the queue is full, so that reading is never blocked by writing.
+func (s *UserSocket) reply(req *request, answer *message.Message) {
+	select {
+	case s.direct <- req.address(answer):
+	default:
+		s.logger.Printf("Dropping reply to user %s, too many replies are queued.\n", s.user.Name)
+	}
+}
+
+// send writes message to the connection and tracks its delivery.
+func (s *UserSocket) send(msg *message.Message) error {
+	if err := s.write(msg); err != nil {
+		// Connection cannot be written any further, whether it was closed from client's side or failed.
+		if closeErr, ok := err.(*websocket.CloseError); ok {
+			s.logger.Printf("Connection closed for user %s: %v\n", s.user.Name, closeErr)
+		} else {
+			s.logger.Printf("Error writing message for user %s: %v\n", s.user.Name, err)
+		}
+		return err
+	}
+	s.delivery.sent(msg)
+	s.logger.Printf("Sent message %v to user %s\n", msg, s.user.Name)
+	return nil
+}
+
+// close initiates closing handshake. Client which does not keep up with messages is asked to come back later,
+// presenting acknowledged messages, so that those not acknowledged are replayed.
+func (s *UserSocket) close() {
+	if !s.overflowed {
+		s.conn.WriteMessage(websocket.CloseMessage, []byte{})
+		return
+	}
+	s.logger.Printf("User %s does not keep up, %d sent messages are not acknowledged.\n", s.user.Name, s.delivery.unacked())
+	s.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseTryAgainLater, overflowCloseReason))
+}
+
+// decode reads message in the wire format negotiated for the connection.
+func (s *UserSocket) decode(messageType int, data []byte, msg *message.Message) error {
+	if s.conn.Subprotocol() != BinaryProtocol {
+		return json.Unmarshal(data, msg)
+	}
+	if messageType != websocket.BinaryMessage {
+		return errors.New("binary message expected")
+	}
+	return msg.UnmarshalBinary(data)
+}
+
+// write sends message in the wire format negotiated for the connection.
+func (s *UserSocket) write(msg *message.Message) error {
+	if s.conn.Subprotocol() != BinaryProtocol {
+		return s.conn.WriteJSON(msg)
+	}
+	data, err := msg.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return s.conn.WriteMessage(websocket.BinaryMessage, data)
 }
@@ -0,0 +1,76 @@
+package chat
+
+import (
+	"sync"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+// delivery tracks messages sent through the socket and acknowledged by the client, per room.
+// Only messages kept in history are tracked, as only those can be replayed when client reconnects.
+type delivery struct {
+	rooms map[string]*roomDelivery
+	mu    sync.Mutex
+}
+
+type roomDelivery struct {
+	sent  uint64
+	acked uint64
+}
+
+// replayRange is a range of room history (After, Until] missed by the client while it was away.
+type replayRange struct {
+	RoomID string
+	After  uint64
+	Until  uint64
+}
+
+func newDelivery() *delivery {
+	return &delivery{
+		rooms: make(map[string]*roomDelivery),
+	}
+}
+
+// tracked tells if message is a new message of the room history, rather than an event about it.
+func tracked(msg *message.Message) bool {
+	return msg.RoomID != "" && msg.Seq != 0 && msg.Action == ""
+}
+
+// sent records message written to the connection.
+func (d *delivery) sent(msg *message.Message) {
+	if !tracked(msg) {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	room, ok := d.rooms[msg.RoomID]
+	if !ok {
+		// Messages of the room which were not sent through this socket are not expected to be acknowledged.
+		room = &roomDelivery{acked: msg.Seq - 1}
+		d.rooms[msg.RoomID] = room
+	}
+	room.sent = max(room.sent, msg.Seq)
+}
+
+// ack records acknowledgement of messages of the room up to the sequence number.
+// Client cannot acknowledge messages which were not sent to it.
+func (d *delivery) ack(roomID string, seq uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	room, ok := d.rooms[roomID]
+	if !ok {
+		return
+	}
+	room.acked = max(room.acked, min(seq, room.sent))
+}
+
+// unacked returns number of sent messages not acknowledged by the client yet.
+func (d *delivery) unacked() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var count uint64
+	for _, room := range d.rooms {
+		count += room.sent - room.acked
+	}
+	return count
+}
@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+// How often broadcaster looks for expired messages.
+const expiryInterval = time.Second
+
+// Longest delay before removal of expired message is tried again after it failed.
+const maxExpiryBackoff = time.Minute
+
+// expiring is an ephemeral message waiting for its removal.
+type expiring struct {
+	roomID    string
+	msgID     string
+	expiresAt time.Time
+	// Failed attempts to remove the message, each doubling delay before the next one.
+	failures int
+}
+
+// expiryQueue is a min-heap of ephemeral messages, the earliest to expire first.
+type expiryQueue []expiring
+
+func (q expiryQueue) Len() int           { return len(q) }
+func (q expiryQueue) Less(i, j int) bool { return q[i].expiresAt.Before(q[j].expiresAt) }
+func (q expiryQueue) Swap(i, j int)      { q[i], q[j] = q[j], q[i] }
+func (q *expiryQueue) Push(x any)        { *q = append(*q, x.(expiring)) }
+func (q *expiryQueue) Pop() any {
+	old := *q
+	last := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return last
+}
+
+// setExpiry resolves TTL of the new message: TTL asked by the author is capped by TTL of the room,
+// which applies to messages without their own TTL as well.
+// Clients do not get TTL back, they see when the message expires.
+func (b *Broadcaster) setExpiry(ctx context.Context, msg *message.Message) error {
+	ttl := msg.TTL
+	msg.TTL, msg.ExpiresAt = 0, nil
+	if ttl < 0 {
+		return errors.New("message TTL cannot be negative")
+	}
+	// Notifications are not part of conversation, so they do not expire.
+	if msg.RoomID == "" || msg.IsNotification() {
+		return nil
+	}
+	if room := b.repo.GetRoom(ctx, msg.RoomID); room != nil && room.MessageTTL > 0 {
+		if ttl == 0 || ttl > room.MessageTTL {
+			ttl = room.MessageTTL
+		}
+	}
+	if ttl > 0 {
+		expiresAt := msg.ServerTime.Add(ttl)
+		msg.ExpiresAt = &expiresAt
+	}
+	return nil
+}
+
+// TrackExpiry schedules removal of the stored ephemeral message; other messages are ignored.
+// Messages which have already expired, e.g. while the service was down, are removed right after start.
+// It is supposed to be called before broadcaster is started.
+func (b *Broadcaster) TrackExpiry(msg *message.Message) {
+	if !msg.IsEphemeral() || msg.ID == "" {
+		return
+	}
+	heap.Push(&b.expiry, expiring{roomID: msg.RoomID, msgID: msg.ID, expiresAt: *msg.ExpiresAt})
+}
+
+// expire removes messages expired by now from history and search,
+// and lets room participants know, so that clients drop them as well.
+// Message which could not be removed is tried again later, not holding up the others.
+func (b *Broadcaster) expire(ctx context.Context, now time.Time) {
+	for len(b.expiry) > 0 && !b.expiry[0].expiresAt.After(now) {
+		due := heap.Pop(&b.expiry).(expiring)
+		stored, err := b.messageStore.GetMessage(ctx, due.roomID, due.msgID)
+		if errors.Is(err, message.ErrMessageNotFound) {
+			continue
+		}
+		if err == nil {
+			err = b.messageStore.RemoveMessage(ctx, due.roomID, due.msgID)
+		}
+		if err != nil {
+			backoff := min(expiryInterval<<due.failures, maxExpiryBackoff)
+			b.logger.Printf("Expired message %s could not be removed, trying again in %v: %v\n", due.msgID, backoff, err)
+			if backoff < maxExpiryBackoff {
+				due.failures++
+			}
+			due.expiresAt = now.Add(backoff)
+			heap.Push(&b.expiry, due)
+			continue
+		}
+		b.searchIndex.Remove(due.msgID)
+		b.unpinGone(ctx, due.roomID, due.msgID)
+
+		expiration := stored.Expiration()
+		destination, err := b.destination(ctx, expiration)
+		if err != nil {
+			b.logger.Printf("Expiration of message %s could not be dispatched: %v\n", due.msgID, err)
+			continue
+		}
+		b.broadcast([]*event{{msg: expiration, destination: destination}})
+	}
+}
@@ -0,0 +1,112 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/moderation"
+)
+
+// QuarantineAction marks message held for review, as moderators of the room get it.
+const QuarantineAction = "quarantine"
+
+// moderate runs text of the new message, or of the edit, through moderation filters
+// and applies redaction to the message. Edits are already in the room, so they cannot be held
+// for review and are rejected instead.
+func (b *Broadcaster) moderate(msg *message.Message) moderation.Verdict {
+	allow := moderation.Verdict{Outcome: moderation.Allow}
+	if b.moderation == nil {
+		return allow
+	}
+
+	var verdict moderation.Verdict
+	switch body := msg.Body.(type) {
+	case *message.TextBody:
+		if verdict = b.moderation.Check(msg, body.Text); verdict.Outcome == moderation.Redact {
+			msg.Body = &message.TextBody{Text: verdict.Text}
+		}
+	case *message.EditBody:
+		if body.Delete {
+			return allow
+		}
+		verdict = b.moderation.Check(msg, body.Text)
+		switch verdict.Outcome {
+		case moderation.Redact:
+			redacted := *body
+			redacted.Text = verdict.Text
+			msg.Body = &redacted
+		case moderation.Quarantine:
+			verdict.Outcome = moderation.Reject
+		}
+	default:
+		return allow
+	}
+	return verdict
+}
+
+// hold keeps the message back for review and lets moderators of the room who are online know.
+func (b *Broadcaster) hold(ctx context.Context, msg *message.Message, reason string) (*moderation.Held, *event) {
+	held := b.moderation.Hold(msg, reason)
+
+	review := *msg
+	review.ID = held.ID
+	review.ServerTime = held.HeldAt
+	review.Action = QuarantineAction
+	sockets := []*UserSocket{}
+	for socket := range b.sockets {
+		if b.repo.CanModerate(ctx, msg.RoomID, socket.user.ID) {
+			sockets = append(sockets, socket)
+		}
+	}
+	return held, &event{msg: &review, destination: sockets}
+}
+
+// review releases the held message on behalf of the room creator or moderator,
+// and returns it to be accepted as a new one. Discarded message is replaced with the notice
+// telling its author, and nil is returned.
+func (b *Broadcaster) review(ctx context.Context, msg *message.Message, body *message.ReviewBody) (*message.Message, error) {
+	if b.moderation == nil {
+		return nil, errors.New("moderation is not enabled")
+	}
+	if !b.repo.CanModerate(ctx, msg.RoomID, msg.UserID) {
+		return nil, errors.New("only room creator and moderators can review messages")
+	}
+	held, err := b.moderation.Release(msg.RoomID, body.MessageID)
+	if err != nil {
+		return nil, err
+	}
+	if body.Discard {
+		*msg = *notice(held.Message, moderation.Discard, "discarded by moderator", held.ID)
+		return nil, nil
+	}
+	return held.Message, nil
+}
+
+// notice tells the author of the message what moderation has done with it.
+func notice(msg *message.Message, outcome moderation.Outcome, reason string, heldID string) *message.Message {
+	return &message.Message{
+		UserID:     msg.UserID,
+		User:       msg.User,
+		RoomID:     msg.RoomID,
+		Room:       msg.Room,
+		ServerTime: time.Now(),
+		Body: &message.ModerationBody{
+			Outcome:   string(outcome),
+			Reason:    reason,
+			MessageID: heldID,
+		},
+	}
+}
+
+// userSockets returns sockets of the user, who could be logged in from several clients.
+func (b *Broadcaster) userSockets(userID string) []*UserSocket {
+	sockets := []*UserSocket{}
+	for socket := range b.sockets {
+		if socket.user.ID == userID {
+			sockets = append(sockets, socket)
+		}
+	}
+	return sockets
+}
@@ -0,0 +1,110 @@
+package chat
+
+import (
+	"context"
+	"expvar"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/ratelimit"
+)
+
+// RateLimits of messages posted by users. Zero limits let every message through.
+type RateLimits struct {
+	// Messages coming from a single Websocket connection.
+	Connection ratelimit.Limit
+	// Messages of a user, from all of their connections to all rooms.
+	User ratelimit.Limit
+	// Messages to a room from all of its participants.
+	Room ratelimit.Limit
+}
+
+// rateLimited counts messages not let through by limit: connection, user, room or slow mode.
+var rateLimited = expvar.NewMap("chat.rateLimited")
+
+// postKey identifies posts of the user to the room for slow mode.
+type postKey struct {
+	roomID string
+	userID string
+}
+
+// quota is what the message takes from rate limits and slow mode once it is accepted.
+type quota struct {
+	userID string
+	roomID string
+	// Whether the message is a post slowed down by slow mode of the room.
+	slowed bool
+}
+
+// limit checks rate limits of the user and of the room, and slow mode of the room,
+// for messages posted by users. It returns error for the sender of the message exceeding them,
+// or quota to spend by the message once it is accepted, so that rejected messages do not count.
+func (b *Broadcaster) limit(ctx context.Context, msg *message.Message, now time.Time) (*quota, *message.Message) {
+	switch msg.Body.(type) {
+	case *message.TextBody, *message.AttachmentBody, *message.EditBody, *message.ReactionBody:
+	default:
+		// Read receipts, pins and reviews are not posted to the room, and system messages are not limited.
+		return nil, nil
+	}
+
+	if wait := b.userLimiter.Wait(msg.UserID, now); wait > 0 {
+		rateLimited.Add("user", 1)
+		return nil, rejection(msg, message.RateLimitedError, "you are sending messages too fast", wait)
+	}
+	if wait := b.roomLimiter.Wait(msg.RoomID, now); wait > 0 {
+		rateLimited.Add("room", 1)
+		return nil, rejection(msg, message.RateLimitedError, "room gets too many messages, try again later", wait)
+	}
+	q := &quota{userID: msg.UserID, roomID: msg.RoomID}
+	interval := b.slowMode(ctx, msg)
+	if interval > 0 {
+		if wait := interval - now.Sub(b.posted[postKey{roomID: q.roomID, userID: q.userID}]); wait > 0 {
+			rateLimited.Add("slowMode", 1)
+			return nil, rejection(msg, message.SlowModeError, "room is in slow mode", wait)
+		}
+		q.slowed = true
+	}
+	return q, nil
+}
+
+// spend takes tokens of the accepted message, and starts slow mode interval of its author.
+func (b *Broadcaster) spend(q *quota, now time.Time) {
+	b.userLimiter.Take(q.userID, now)
+	b.roomLimiter.Take(q.roomID, now)
+	if q.slowed {
+		b.posted[postKey{roomID: q.roomID, userID: q.userID}] = now
+	}
+}
+
+// slowMode returns slow mode interval applying to the message, zero if it is not slowed down.
+// Edits and reactions are not posts, and the room creator and moderators are not slowed down.
+func (b *Broadcaster) slowMode(ctx context.Context, msg *message.Message) time.Duration {
+	switch msg.Body.(type) {
+	case *message.TextBody, *message.AttachmentBody:
+	default:
+		return 0
+	}
+	room := b.repo.GetRoom(ctx, msg.RoomID)
+	if room == nil || room.SlowMode == 0 || b.repo.CanModerate(ctx, room.ID, msg.UserID) {
+		return 0
+	}
+	return room.SlowMode
+}
+
+// forgetPosts drops times of posts which do not slow their authors down anymore.
+func (b *Broadcaster) forgetPosts(ctx context.Context, now time.Time) {
+	for key, at := range b.posted {
+		room := b.repo.GetRoom(ctx, key.roomID)
+		if room == nil || now.Sub(at) >= room.SlowMode {
+			delete(b.posted, key)
+		}
+	}
+}
+
+// SetRateLimits sets limits of messages posted by users.
+// It is supposed to be called before broadcaster is started and sockets are created.
+func (b *Broadcaster) SetRateLimits(limits RateLimits) {
+	b.rateLimits = limits
+	b.userLimiter = ratelimit.NewLimiter(limits.User)
+	b.roomLimiter = ratelimit.NewLimiter(limits.Room)
+}
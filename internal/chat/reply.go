@@ -0,0 +1,66 @@
+package chat
+
+import (
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+// request is a message coming from the client along with the socket it came from,
+// so that the client could be answered whether the message is accepted.
+type request struct {
+	msg    *message.Message
+	sender *UserSocket
+	// ID client assigned to the message, to correlate answers with.
+	clientID string
+	// Gets why the message is not accepted, or nil if it is, when message is submitted rather than sent by socket.
+	verdict chan<- error
+}
+
+// address addresses the answer to the client which sent the request.
+func (req *request) address(answer *message.Message) *message.Message {
+	answer.ClientID = req.clientID
+	if req.sender != nil {
+		answer.UserID = req.sender.user.ID
+		answer.User = req.sender.user.Name
+	}
+	return answer
+}
+
+// reply delivers the answer back to the socket request came from, or to sockets of the author
+// if message did not come from a socket, e.g. it was scheduled or sent via HTTP API.
+func (b *Broadcaster) reply(req *request, answer *message.Message) *event {
+	req.address(answer)
+	if req.sender == nil {
+		return &event{msg: answer, destination: b.userSockets(answer.UserID)}
+	}
+	return &event{msg: answer, destination: []*UserSocket{req.sender}}
+}
+
+// ack tells the sender that their message is accepted, with ID and sequence number it got.
+func ack(msg *message.Message) *message.Message {
+	return &message.Message{
+		UserID:     msg.UserID,
+		User:       msg.User,
+		RoomID:     msg.RoomID,
+		Room:       msg.Room,
+		ServerTime: time.Now(),
+		Body:       &message.AckBody{Seq: msg.Seq, MessageID: msg.ID},
+	}
+}
+
+// rejection tells the sender why their message is not accepted and when they could try again.
+func rejection(msg *message.Message, code, reason string, retryAfter time.Duration) *message.Message {
+	return &message.Message{
+		UserID:     msg.UserID,
+		User:       msg.User,
+		RoomID:     msg.RoomID,
+		Room:       msg.Room,
+		ServerTime: time.Now(),
+		Body: &message.ErrorBody{
+			Code:       code,
+			Reason:     reason,
+			RetryAfter: retryAfter.Milliseconds(),
+		},
+	}
+}
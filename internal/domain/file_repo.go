@@ -0,0 +1,564 @@
+package domain
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	walFileName      = "repo.wal"
+	snapshotFileName = "repo.snapshot"
+
+	defaultSnapshotEvery = 1000
+)
+
+// Operations recorded in write-ahead log.
+const (
+	opCreateUser = "create-user"
+	opRenameUser = "rename-user"
+	opCreateRoom = "create-room"
+	opRenameRoom = "rename-room"
+	opJoinRoom   = "join-room"
+	opLeaveRoom  = "leave-room"
+	opMarkRead   = "mark-read"
+	opSetTTL     = "set-message-ttl"
+	opSlowMode   = "set-slow-mode"
+
+	opAddModerator    = "add-moderator"
+	opRemoveModerator = "remove-moderator"
+	opPinMessage      = "pin-message"
+	opUnpinMessage    = "unpin-message"
+)
+
+// walRecord is a single mutation of the repository.
+// Records are numbered, so that replay can skip those already included into snapshot.
+type walRecord struct {
+	LSN    uint64        `json:"lsn"`
+	Op     string        `json:"op"`
+	UserID string        `json:"userId,omitempty"`
+	RoomID string        `json:"roomId,omitempty"`
+	Name   string        `json:"name,omitempty"`
+	Seq    uint64        `json:"seq,omitempty"`
+	TTL    time.Duration `json:"ttl,omitempty"`
+	// Interval of slow mode.
+	Interval time.Duration `json:"interval,omitempty"`
+
+	MessageID string     `json:"messageId,omitempty"`
+	At        *time.Time `json:"at,omitempty"`
+}
+
+// snapshot is a full image of the repository state up to LSN.
+type snapshot struct {
+	LSN         uint64              `json:"lsn"`
+	Users       []snapshotUser      `json:"users"`
+	Rooms       []snapshotRoom      `json:"rooms"`
+	UserToRooms map[string][]string `json:"userToRooms"`
+	RoomToUsers map[string][]string `json:"roomToUsers"`
+	// Read cursors by user and room IDs.
+	ReadCursors map[string]map[string]uint64 `json:"readCursors,omitempty"`
+	// Moderators and pins by room IDs.
+	Moderators map[string][]string      `json:"moderators,omitempty"`
+	Pins       map[string][]snapshotPin `json:"pins,omitempty"`
+}
+
+type snapshotUser struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+type snapshotRoom struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	CreatorID  string        `json:"creatorId,omitempty"`
+	MessageTTL time.Duration `json:"messageTtl,omitempty"`
+	SlowMode   time.Duration `json:"slowMode,omitempty"`
+}
+
+type snapshotPin struct {
+	MessageID string    `json:"messageId"`
+	PinnedBy  string    `json:"pinnedBy"`
+	PinnedAt  time.Time `json:"pinnedAt"`
+}
+
+// FileRepository is a durable Repository keeping its state in a local data directory.
+// Every mutation is appended to write-ahead log and synced to disk before it is applied
+// to in-memory state, which serves all reads.
+// Periodically the whole state is written as a snapshot and the log is truncated.
+// On start the snapshot is loaded and the log is replayed on top of it;
+// torn record at the end of the log (e.g. after a crash during write) is discarded.
+type FileRepository struct {
+	*InMemoryRepository
+
+	dir           string
+	wal           *os.File
+	walSize       int64
+	lsn           uint64
+	sinceSnapshot int
+	snapshotEvery int
+
+	logger *log.Logger
+}
+
+// NewFileRepository opens (or initializes) repository in the data directory.
+// Snapshot is taken after every snapshotEvery logged mutations; zero means default.
+func NewFileRepository(dir string, snapshotEvery int, logger *log.Logger) (*FileRepository, error) {
+	if snapshotEvery <= 0 {
+		snapshotEvery = defaultSnapshotEvery
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create data directory: %w", err)
+	}
+
+	r := &FileRepository{
+		dir:           dir,
+		snapshotEvery: snapshotEvery,
+		logger:        logger,
+	}
+
+	fresh, err := r.loadSnapshot()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.replayLog(); err != nil {
+		return nil, err
+	}
+
+	r.wal, err = os.OpenFile(filepath.Join(dir, walFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open write-ahead log: %w", err)
+	}
+	info, err := r.wal.Stat()
+	if err != nil {
+		r.wal.Close()
+		return nil, fmt.Errorf("stat write-ahead log: %w", err)
+	}
+	r.walSize = info.Size()
+
+	// Persist initial state (default room) right away so that its ID survives restarts.
+	if fresh {
+		if err := r.snapshot(); err != nil {
+			r.wal.Close()
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// Close takes final snapshot and releases log file.
+func (r *FileRepository) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	err := r.snapshot()
+	if closeErr := r.wal.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+func (r *FileRepository) CreateUser(_ context.Context, userName string) (*User, error) {
+	var user *User
+	err := r.mutate(&walRecord{Op: opCreateUser, UserID: NewID(), Name: userName}, func(rec *walRecord) (err error) {
+		user, err = r.createUser(rec.UserID, rec.Name)
+		return err
+	})
+	return user.clone(), err
+}
+
+func (r *FileRepository) RenameUser(_ context.Context, userID, newName string) error {
+	return r.mutate(&walRecord{Op: opRenameUser, UserID: userID, Name: newName}, r.apply)
+}
+
+func (r *FileRepository) CreateRoom(_ context.Context, roomName, creatorUserID string) (*Room, error) {
+	var room *Room
+	rec := &walRecord{Op: opCreateRoom, RoomID: NewID(), UserID: creatorUserID, Name: roomName}
+	err := r.mutate(rec, func(rec *walRecord) (err error) {
+		room, err = r.createRoom(rec.RoomID, rec.Name, rec.UserID)
+		return err
+	})
+	return room.clone(), err
+}
+
+func (r *FileRepository) RenameRoom(_ context.Context, roomID, newName string) error {
+	return r.mutate(&walRecord{Op: opRenameRoom, RoomID: roomID, Name: newName}, r.apply)
+}
+
+func (r *FileRepository) SetMessageTTL(_ context.Context, roomID string, ttl time.Duration) error {
+	return r.mutate(&walRecord{Op: opSetTTL, RoomID: roomID, TTL: ttl}, r.apply)
+}
+
+func (r *FileRepository) SetSlowMode(_ context.Context, roomID string, interval time.Duration) error {
+	return r.mutate(&walRecord{Op: opSlowMode, RoomID: roomID, Interval: interval}, r.apply)
+}
+
+func (r *FileRepository) JoinRoom(_ context.Context, userID, roomID string) error {
+	return r.mutate(&walRecord{Op: opJoinRoom, UserID: userID, RoomID: roomID}, r.apply)
+}
+
+func (r *FileRepository) LeaveRoom(_ context.Context, userID, roomID string) error {
+	return r.mutate(&walRecord{Op: opLeaveRoom, UserID: userID, RoomID: roomID}, r.apply)
+}
+
+func (r *FileRepository) MarkRead(_ context.Context, userID, roomID string, seq uint64) (bool, error) {
+	// Clients report reads often, so reports not moving the cursor are not logged.
+	r.mu.RLock()
+	read := r.readCursors[userID][roomID]
+	r.mu.RUnlock()
+	if seq <= read {
+		return false, nil
+	}
+
+	var moved bool
+	err := r.mutate(&walRecord{Op: opMarkRead, UserID: userID, RoomID: roomID, Seq: seq}, func(rec *walRecord) (err error) {
+		moved, err = r.markRead(rec.UserID, rec.RoomID, rec.Seq)
+		return err
+	})
+	return moved, err
+}
+
+func (r *FileRepository) AddModerator(_ context.Context, roomID, userID string) error {
+	return r.mutate(&walRecord{Op: opAddModerator, RoomID: roomID, UserID: userID}, r.apply)
+}
+
+func (r *FileRepository) RemoveModerator(_ context.Context, roomID, userID string) error {
+	return r.mutate(&walRecord{Op: opRemoveModerator, RoomID: roomID, UserID: userID}, r.apply)
+}
+
+func (r *FileRepository) PinMessage(_ context.Context, roomID string, pin Pin) error {
+	rec := &walRecord{Op: opPinMessage, RoomID: roomID, UserID: pin.PinnedBy, MessageID: pin.MessageID, At: &pin.PinnedAt}
+	return r.mutate(rec, r.apply)
+}
+
+func (r *FileRepository) UnpinMessage(_ context.Context, roomID, messageID string) error {
+	return r.mutate(&walRecord{Op: opUnpinMessage, RoomID: roomID, MessageID: messageID}, r.apply)
+}
+
+// mutate logs the record and applies it to in-memory state.
+// Record is logged even if it is going to be rejected: replay reproduces the same outcome,
+// since state at this point of the log is exactly the same.
+func (r *FileRepository) mutate(rec *walRecord, apply func(*walRecord) error) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec.LSN = r.lsn + 1
+	if err := r.appendLog(rec); err != nil {
+		return err
+	}
+	r.lsn = rec.LSN
+
+	applyErr := apply(rec)
+
+	r.sinceSnapshot++
+	if r.sinceSnapshot >= r.snapshotEvery {
+		if err := r.snapshot(); err != nil {
+			// Not fatal, log still has all the records.
+			r.logger.Printf("Repository snapshot failed: %v\n", err)
+		}
+	}
+
+	return applyErr
+}
+
+// apply changes in-memory state according to the record.
+func (r *FileRepository) apply(rec *walRecord) error {
+	switch rec.Op {
+	case opCreateUser:
+		_, err := r.createUser(rec.UserID, rec.Name)
+		return err
+	case opRenameUser:
+		return r.renameUser(rec.UserID, rec.Name)
+	case opCreateRoom:
+		_, err := r.createRoom(rec.RoomID, rec.Name, rec.UserID)
+		return err
+	case opRenameRoom:
+		return r.renameRoom(rec.RoomID, rec.Name)
+	case opSetTTL:
+		return r.setMessageTTL(rec.RoomID, rec.TTL)
+	case opSlowMode:
+		return r.setSlowMode(rec.RoomID, rec.Interval)
+	case opJoinRoom:
+		return r.joinRoom(rec.UserID, rec.RoomID)
+	case opLeaveRoom:
+		return r.leaveRoom(rec.UserID, rec.RoomID)
+	case opMarkRead:
+		_, err := r.markRead(rec.UserID, rec.RoomID, rec.Seq)
+		return err
+	case opAddModerator:
+		return r.addModerator(rec.RoomID, rec.UserID)
+	case opRemoveModerator:
+		return r.removeModerator(rec.RoomID, rec.UserID)
+	case opPinMessage:
+		pin := Pin{MessageID: rec.MessageID, PinnedBy: rec.UserID}
+		if rec.At != nil {
+			pin.PinnedAt = *rec.At
+		}
+		return r.pinMessage(rec.RoomID, pin)
+	case opUnpinMessage:
+		return r.unpinMessage(rec.RoomID, rec.MessageID)
+	default:
+		return fmt.Errorf("unknown log operation %q", rec.Op)
+	}
+}
+
+// appendLog writes record as a line prefixed by its checksum and syncs the log.
+func (r *FileRepository) appendLog(rec *walRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("encode log record: %w", err)
+	}
+	line := fmt.Sprintf("%08x %s\n", crc32.ChecksumIEEE(data), data)
+	if _, err := r.wal.WriteString(line); err != nil {
+		// Do not leave partial record in the middle of the log.
+		r.wal.Truncate(r.walSize)
+		return fmt.Errorf("write log record: %w", err)
+	}
+	if err := r.wal.Sync(); err != nil {
+		r.wal.Truncate(r.walSize)
+		return fmt.Errorf("sync log: %w", err)
+	}
+	r.walSize += int64(len(line))
+	return nil
+}
+
+// replayLog applies all valid records past snapshot and cuts off torn tail, if any.
+func (r *FileRepository) replayLog() error {
+	path := filepath.Join(r.dir, walFileName)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open write-ahead log: %w", err)
+	}
+	defer f.Close()
+
+	var validSize int64
+	reader := bufio.NewReader(f)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			// Anything after the last newline is a partially written record.
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("read write-ahead log: %w", err)
+		}
+		rec, ok := decodeLogLine(line)
+		if !ok {
+			break
+		}
+		validSize += int64(len(line))
+
+		if rec.LSN <= r.lsn {
+			continue
+		}
+		r.lsn = rec.LSN
+		r.sinceSnapshot++
+		// Rejected records are rejected again, the error was reported to the caller originally.
+		r.apply(rec)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat write-ahead log: %w", err)
+	}
+	if info.Size() > validSize {
+		r.logger.Printf("Discarding %d bytes of torn write-ahead log tail.\n", info.Size()-validSize)
+		if err := os.Truncate(path, validSize); err != nil {
+			return fmt.Errorf("truncate write-ahead log: %w", err)
+		}
+	}
+	return nil
+}
+
+func decodeLogLine(line []byte) (*walRecord, bool) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	checksum, data, found := bytes.Cut(line, []byte(" "))
+	if !found {
+		return nil, false
+	}
+	var want uint32
+	if _, err := fmt.Sscanf(string(checksum), "%08x", &want); err != nil || crc32.ChecksumIEEE(data) != want {
+		return nil, false
+	}
+	rec := &walRecord{}
+	if err := json.Unmarshal(data, rec); err != nil {
+		return nil, false
+	}
+	return rec, true
+}
+
+// loadSnapshot restores state from snapshot file.
+// If there is no snapshot yet, state is initialized as for a new repository and true is returned.
+func (r *FileRepository) loadSnapshot() (bool, error) {
+	data, err := os.ReadFile(filepath.Join(r.dir, snapshotFileName))
+	if errors.Is(err, os.ErrNotExist) {
+		r.InMemoryRepository = newInMemoryRepository()
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("read snapshot: %w", err)
+	}
+
+	snap := &snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return false, fmt.Errorf("decode snapshot: %w", err)
+	}
+
+	mem := &InMemoryRepository{
+		users:       make(map[string]*User, len(snap.Users)),
+		rooms:       make(map[string]*Room, len(snap.Rooms)),
+		userNames:   make(map[string]*User, len(snap.Users)),
+		roomNames:   make(map[string]*Room, len(snap.Rooms)),
+		userToRooms: make(map[*User][]*Room, len(snap.UserToRooms)),
+		roomToUsers: make(map[*Room][]*User, len(snap.RoomToUsers)),
+		readCursors: make(map[string]map[string]uint64, len(snap.ReadCursors)),
+		moderators:  make(map[string]map[string]bool, len(snap.Moderators)),
+		pins:        make(map[string][]Pin, len(snap.Pins)),
+	}
+	for _, u := range snap.Users {
+		user := &User{ID: u.ID, Name: u.Name}
+		mem.users[user.ID] = user
+		mem.userNames[user.Name] = user
+	}
+	for _, rm := range snap.Rooms {
+		room := &Room{ID: rm.ID, Name: rm.Name, Creator: mem.users[rm.CreatorID], MessageTTL: rm.MessageTTL, SlowMode: rm.SlowMode}
+		mem.rooms[room.ID] = room
+		mem.roomNames[room.Name] = room
+	}
+	for userID, roomIDs := range snap.UserToRooms {
+		user := mem.users[userID]
+		for _, roomID := range roomIDs {
+			mem.userToRooms[user] = append(mem.userToRooms[user], mem.rooms[roomID])
+		}
+	}
+	for roomID, userIDs := range snap.RoomToUsers {
+		room := mem.rooms[roomID]
+		for _, userID := range userIDs {
+			mem.roomToUsers[room] = append(mem.roomToUsers[room], mem.users[userID])
+		}
+	}
+
+	for userID, cursors := range snap.ReadCursors {
+		mem.readCursors[userID] = cursors
+	}
+	for roomID, userIDs := range snap.Moderators {
+		mem.moderators[roomID] = make(map[string]bool, len(userIDs))
+		for _, userID := range userIDs {
+			mem.moderators[roomID][userID] = true
+		}
+	}
+	for roomID, pins := range snap.Pins {
+		for _, p := range pins {
+			mem.pins[roomID] = append(mem.pins[roomID], Pin{MessageID: p.MessageID, PinnedBy: p.PinnedBy, PinnedAt: p.PinnedAt})
+		}
+	}
+
+	r.InMemoryRepository = mem
+	r.lsn = snap.LSN
+	return false, nil
+}
+
+// snapshot atomically replaces snapshot file with current state and truncates the log.
+// Caller must hold the lock.
+func (r *FileRepository) snapshot() error {
+	snap := &snapshot{
+		LSN:         r.lsn,
+		Users:       make([]snapshotUser, 0, len(r.users)),
+		Rooms:       make([]snapshotRoom, 0, len(r.rooms)),
+		UserToRooms: make(map[string][]string, len(r.userToRooms)),
+		RoomToUsers: make(map[string][]string, len(r.roomToUsers)),
+		ReadCursors: r.readCursors,
+		Moderators:  make(map[string][]string, len(r.moderators)),
+		Pins:        make(map[string][]snapshotPin, len(r.pins)),
+	}
+	for _, user := range r.users {
+		snap.Users = append(snap.Users, snapshotUser{ID: user.ID, Name: user.Name})
+	}
+	for _, room := range r.rooms {
+		rm := snapshotRoom{ID: room.ID, Name: room.Name, MessageTTL: room.MessageTTL, SlowMode: room.SlowMode}
+		if room.Creator != nil {
+			rm.CreatorID = room.Creator.ID
+		}
+		snap.Rooms = append(snap.Rooms, rm)
+	}
+	for user, rooms := range r.userToRooms {
+		for _, room := range rooms {
+			snap.UserToRooms[user.ID] = append(snap.UserToRooms[user.ID], room.ID)
+		}
+	}
+	for room, users := range r.roomToUsers {
+		for _, user := range users {
+			snap.RoomToUsers[room.ID] = append(snap.RoomToUsers[room.ID], user.ID)
+		}
+	}
+
+	for roomID, moderators := range r.moderators {
+		for userID := range moderators {
+			snap.Moderators[roomID] = append(snap.Moderators[roomID], userID)
+		}
+	}
+	for roomID, pins := range r.pins {
+		for _, pin := range pins {
+			snap.Pins[roomID] = append(snap.Pins[roomID], snapshotPin{MessageID: pin.MessageID, PinnedBy: pin.PinnedBy, PinnedAt: pin.PinnedAt})
+		}
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return fmt.Errorf("encode snapshot: %w", err)
+	}
+	if err := writeFileAtomic(filepath.Join(r.dir, snapshotFileName), data); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+
+	// Records up to snapshot LSN are not needed anymore;
+	// if truncation fails, they are skipped on replay anyway.
+	if err := r.wal.Truncate(0); err != nil {
+		return fmt.Errorf("truncate write-ahead log: %w", err)
+	}
+	r.walSize = 0
+	r.sinceSnapshot = 0
+	return nil
+}
+
+// writeFileAtomic writes data to temporary file and renames it over the target,
+// so that target is never observed partially written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return err
+	}
+	// Make rename itself durable.
+	if dir, err := os.Open(filepath.Dir(path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+}
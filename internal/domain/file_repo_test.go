@@ -0,0 +1,137 @@
+package domain
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+func TestFileRepository_Recovery(t *testing.T) {
+	tests := []struct {
+		name          string
+		snapshotEvery int
+		tornTail      string
+	}{
+		{
+			name:          "State should be recovered from write-ahead log",
+			snapshotEvery: 1000,
+		},
+		{
+			name:          "State should be recovered from snapshot and write-ahead log",
+			snapshotEvery: 2,
+		},
+		{
+			name:          "Torn record at the end of the log should be discarded",
+			snapshotEvery: 1000,
+			tornTail:      `1234abcd {"lsn":100,"op":"create-us`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			r, err := NewFileRepository(dir, tt.snapshotEvery, testLogger)
+			if err != nil {
+				t.Fatalf("NewFileRepository() error = %v", err)
+			}
+			general := r.FindRoom(ctx, defaultRoomName)
+			userA, _ := r.CreateUser(ctx, testUserA.Name)
+			userB, _ := r.CreateUser(ctx, testUserB.Name)
+			room, _ := r.CreateRoom(ctx, testRoomA.Name, userA.ID)
+			r.JoinRoom(ctx, userB.ID, room.ID)
+			r.JoinRoom(ctx, userB.ID, general.ID)
+			r.LeaveRoom(ctx, userB.ID, general.ID)
+			r.RenameUser(ctx, userA.ID, "vision")
+			r.RenameRoom(ctx, room.ID, "avengers")
+			r.SetMessageTTL(ctx, room.ID, time.Hour)
+			r.SetSlowMode(ctx, room.ID, 10*time.Second)
+			r.MarkRead(ctx, userB.ID, room.ID, 7)
+			r.MarkRead(ctx, userB.ID, room.ID, 5)
+			r.AddModerator(ctx, room.ID, userB.ID)
+			pinnedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+			r.PinMessage(ctx, room.ID, Pin{MessageID: "first", PinnedBy: userB.ID, PinnedAt: pinnedAt})
+			r.PinMessage(ctx, room.ID, Pin{MessageID: "second", PinnedBy: userA.ID, PinnedAt: pinnedAt})
+			r.UnpinMessage(ctx, room.ID, "first")
+			// Rejected mutation should be rejected on replay as well.
+			if _, err := r.CreateUser(ctx, testUserB.Name); err == nil {
+				t.Fatalf("CreateUser() with duplicate name should fail")
+			}
+			// Simulate crash: leave log as is, without final snapshot.
+			r.wal.Close()
+
+			if tt.tornTail != "" {
+				f, err := os.OpenFile(filepath.Join(dir, walFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.WriteString(tt.tornTail)
+				f.Close()
+			}
+
+			r, err = NewFileRepository(dir, tt.snapshotEvery, testLogger)
+			if err != nil {
+				t.Fatalf("NewFileRepository() on restart error = %v", err)
+			}
+
+			if got := r.FindRoom(ctx, defaultRoomName); got == nil || got.ID != general.ID {
+				t.Errorf("default room = %v, want ID %s", got, general.ID)
+			}
+			gotA := r.FindUser(ctx, "vision")
+			if gotA == nil || gotA.ID != userA.ID {
+				t.Fatalf("renamed user = %v, want ID %s", gotA, userA.ID)
+			}
+			gotRoom := r.GetRoom(ctx, room.ID)
+			if gotRoom == nil || gotRoom.Name != "avengers" || !reflect.DeepEqual(gotRoom.Creator, gotA) {
+				t.Fatalf("renamed room = %v, want name avengers created by %v", gotRoom, gotA)
+			}
+			if gotRoom.MessageTTL != time.Hour {
+				t.Errorf("room message lifetime = %v, want %v", gotRoom.MessageTTL, time.Hour)
+			}
+			if gotRoom.SlowMode != 10*time.Second {
+				t.Errorf("room slow mode = %v, want %v", gotRoom.SlowMode, 10*time.Second)
+			}
+			participants, _ := r.ListParticipants(ctx, room.ID)
+			if len(participants) != 2 || participants[0].ID != userA.ID || participants[1].ID != userB.ID {
+				t.Errorf("room participants = %v, want %s and %s", participants, userA.ID, userB.ID)
+			}
+			participants, _ = r.ListParticipants(ctx, general.ID)
+			if len(participants) != 0 {
+				t.Errorf("default room participants = %v, want none", participants)
+			}
+			if cursors, _ := r.ReadCursors(ctx, userB.ID); cursors[room.ID] != 7 {
+				t.Errorf("read cursors = %v, want %d in room %s", cursors, 7, room.ID)
+			}
+			if !r.CanModerate(ctx, room.ID, userB.ID) {
+				t.Errorf("user %s should moderate room %s", userB.ID, room.ID)
+			}
+			wantPins := []Pin{{MessageID: "second", PinnedBy: userA.ID, PinnedAt: pinnedAt}}
+			if pins, _ := r.ListPins(ctx, room.ID); !reflect.DeepEqual(pins, wantPins) {
+				t.Errorf("pins = %v, want %v", pins, wantPins)
+			}
+
+			// Log should accept new records after recovery.
+			if _, err := r.CreateUser(ctx, "thor"); err != nil {
+				t.Errorf("CreateUser() after recovery error = %v", err)
+			}
+			if err := r.Close(); err != nil {
+				t.Fatalf("Close() error = %v", err)
+			}
+			r, err = NewFileRepository(dir, tt.snapshotEvery, testLogger)
+			if err != nil {
+				t.Fatalf("NewFileRepository() on second restart error = %v", err)
+			}
+			defer r.Close()
+			if got := r.FindUser(ctx, "thor"); got == nil {
+				t.Errorf("user created after recovery is lost")
+			}
+		})
+	}
+}
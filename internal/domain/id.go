@@ -0,0 +1,18 @@
+package domain
+
+import (
+	"crypto/rand"
+	"fmt"
+)
+
+// NewID generates random (version 4) UUID to be used as an opaque identifier.
+func NewID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		// Random source failing is not recoverable for the service.
+		panic(fmt.Sprintf("cannot generate ID: %v", err))
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
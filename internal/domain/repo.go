@@ -3,8 +3,11 @@ package domain
 import (
 	"context"
 	"errors"
+	"maps"
 	"slices"
+	"strings"
 	"sync"
+	"time"
 )
 
 type RoomParticipation struct {
@@ -13,86 +16,278 @@ type RoomParticipation struct {
 }
 
 // Repository stores and retrieves relations between users and rooms.
+// Users and rooms are identified by their IDs; names are unique as well and
+// can be used for lookups, but could be changed over time.
+// Returned users and rooms are copies, so they do not change along with the repository;
+// they are compared by IDs, and looked up again to get the latest state.
 // TODO: implement as a persistent storage, preferrably Redis cache plus SQL server on background.
 type Repository interface {
 	CreateUser(ctx context.Context, userName string) (*User, error)
+	GetUser(ctx context.Context, userID string) *User
 	FindUser(ctx context.Context, userName string) *User
+	RenameUser(ctx context.Context, userID, newName string) error
 
-	CreateRoom(ctx context.Context, roomName, creatorUserName string) (*Room, error)
+	CreateRoom(ctx context.Context, roomName, creatorUserID string) (*Room, error)
+	GetRoom(ctx context.Context, roomID string) *Room
 	FindRoom(ctx context.Context, roomName string) *Room
-	JoinRoom(ctx context.Context, userName, roomName string) error
-	LeaveRoom(ctx context.Context, userName, roomName string) error
+	RenameRoom(ctx context.Context, roomID, newName string) error
+	// SetMessageTTL sets lifetime of messages sent to the room from now on; zero means messages are kept.
+	SetMessageTTL(ctx context.Context, roomID string, ttl time.Duration) error
+	// SetSlowMode sets minimum interval between new messages of a participant in the room;
+	// zero turns slow mode off.
+	SetSlowMode(ctx context.Context, roomID string, interval time.Duration) error
+	JoinRoom(ctx context.Context, userID, roomID string) error
+	LeaveRoom(ctx context.Context, userID, roomID string) error
 
 	ListRooms(ctx context.Context) ([]*Room, error)
-	ListParticipants(ctx context.Context, roomName string) ([]*User, error)
+	ListParticipants(ctx context.Context, roomID string) ([]*User, error)
 	ListParticipantsForAllRooms(ctx context.Context) ([]*RoomParticipation, error)
+
+	// MarkRead moves read cursor of the user in the room forward to the message sequence number
+	// and tells if it moved; cursor never goes back.
+	MarkRead(ctx context.Context, userID, roomID string, seq uint64) (bool, error)
+	// ReadCursors returns sequence numbers of the latest messages read by the user by room IDs.
+	ReadCursors(ctx context.Context, userID string) (map[string]uint64, error)
+
+	// AddModerator and RemoveModerator manage users who moderate the room along with its creator.
+	AddModerator(ctx context.Context, roomID, userID string) error
+	RemoveModerator(ctx context.Context, roomID, userID string) error
+	// CanModerate tells if the user is the creator or a moderator of the room.
+	CanModerate(ctx context.Context, roomID, userID string) bool
+
+	// PinMessage adds message to pins of the room; caller is expected to check the message and the user.
+	PinMessage(ctx context.Context, roomID string, pin Pin) error
+	UnpinMessage(ctx context.Context, roomID, messageID string) error
+	// ListPins returns pins of the room, the earliest first.
+	ListPins(ctx context.Context, roomID string) ([]Pin, error)
 }
 
+var (
+	ErrUserExists      = errors.New("user with this name already exists")
+	ErrUserNotFound    = errors.New("no user registered under this ID")
+	ErrRoomExists      = errors.New("room with this name already exists")
+	ErrRoomNotFound    = errors.New("no room with this ID exists")
+	ErrInvalidTTL      = errors.New("message lifetime cannot be negative")
+	ErrInvalidSlowMode = errors.New("slow mode interval cannot be negative")
+	ErrPinExists       = errors.New("message is already pinned")
+	ErrPinNotFound     = errors.New("message is not pinned")
+)
+
 type InMemoryRepository struct {
+	// Users and rooms by ID.
 	users map[string]*User
 	rooms map[string]*Room
 
+	// Name indexes.
+	userNames map[string]*User
+	roomNames map[string]*Room
+
 	userToRooms map[*User][]*Room
 	roomToUsers map[*Room][]*User
 
+	// Read cursors by user and room IDs.
+	readCursors map[string]map[string]uint64
+
+	// Moderators by room and user IDs.
+	moderators map[string]map[string]bool
+	// Pinned messages by room IDs in order of pinning.
+	pins map[string][]Pin
+
 	mu sync.RWMutex
 }
 
 func NewInMemoryRepository() Repository {
+	return newInMemoryRepository()
+}
+
+func newInMemoryRepository() *InMemoryRepository {
 	r := &InMemoryRepository{
 		users: make(map[string]*User),
 		rooms: make(map[string]*Room),
 
+		userNames: make(map[string]*User),
+		roomNames: make(map[string]*Room),
+
 		userToRooms: make(map[*User][]*Room),
 		roomToUsers: make(map[*Room][]*User),
+
+		readCursors: make(map[string]map[string]uint64),
+
+		moderators: make(map[string]map[string]bool),
+		pins:       make(map[string][]Pin),
 	}
 
-	r.rooms[defaultRoom.Name] = defaultRoom
+	defaultRoom := &Room{
+		ID:   NewID(),
+		Name: defaultRoomName,
+	}
+	r.rooms[defaultRoom.ID] = defaultRoom
+	r.roomNames[defaultRoom.Name] = defaultRoom
 
 	return r
 }
 
-func (r *InMemoryRepository) CreateUser(ctx context.Context, userName string) (*User, error) {
-	if r.FindUser(ctx, userName) != nil {
-		return nil, errors.New("user with this name already exists")
+func (r *InMemoryRepository) CreateUser(_ context.Context, userName string) (*User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	user, err := r.createUser(NewID(), userName)
+	return user.clone(), err
+}
+
+func (r *InMemoryRepository) createUser(userID, userName string) (*User, error) {
+	if _, ok := r.userNames[userName]; ok {
+		return nil, ErrUserExists
 	}
 
 	newUser := &User{
+		ID:   userID,
 		Name: userName,
 	}
-	r.mu.Lock()
-	r.users[userName] = newUser
-	r.mu.Unlock()
+	r.users[userID] = newUser
+	r.userNames[userName] = newUser
 
 	return newUser, nil
 }
 
+func (r *InMemoryRepository) GetUser(_ context.Context, userID string) *User {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.users[userID].clone()
+}
+
 func (r *InMemoryRepository) FindUser(_ context.Context, userName string) *User {
-	if user, ok := r.users[userName]; ok {
-		return user
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.userNames[userName].clone()
+}
+
+func (r *InMemoryRepository) RenameUser(_ context.Context, userID, newName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.renameUser(userID, newName)
+}
+
+func (r *InMemoryRepository) renameUser(userID, newName string) error {
+	user, ok := r.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	if other, ok := r.userNames[newName]; ok {
+		if other == user {
+			return nil
+		}
+		return ErrUserExists
 	}
+
+	// Memberships are kept by reference, so only name index has to be updated;
+	// users returned before are copies and keep the old name.
+	delete(r.userNames, user.Name)
+	user.Name = newName
+	r.userNames[newName] = user
+
 	return nil
 }
 
+func (r *InMemoryRepository) GetRoom(_ context.Context, roomID string) *Room {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.rooms[roomID].clone()
+}
+
 func (r *InMemoryRepository) FindRoom(_ context.Context, roomName string) *Room {
-	if room, ok := r.rooms[roomName]; ok {
-		return room
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.roomNames[roomName].clone()
+}
+
+func (r *InMemoryRepository) RenameRoom(_ context.Context, roomID, newName string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.renameRoom(roomID, newName)
+}
+
+func (r *InMemoryRepository) renameRoom(roomID, newName string) error {
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if other, ok := r.roomNames[newName]; ok {
+		if other == room {
+			return nil
+		}
+		return ErrRoomExists
 	}
+
+	delete(r.roomNames, room.Name)
+	room.Name = newName
+	r.roomNames[newName] = room
+
 	return nil
 }
 
-func (r *InMemoryRepository) JoinRoom(ctx context.Context, userName, roomName string) error {
-	user := r.FindUser(ctx, userName)
-	if user == nil {
-		return errors.New("no user registered under this name")
+func (r *InMemoryRepository) SetMessageTTL(_ context.Context, roomID string, ttl time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.setMessageTTL(roomID, ttl)
+}
+
+func (r *InMemoryRepository) setMessageTTL(roomID string, ttl time.Duration) error {
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return ErrRoomNotFound
 	}
-	room := r.FindRoom(ctx, roomName)
-	if room == nil {
-		return errors.New("no room with this name exists")
+	if ttl < 0 {
+		return ErrInvalidTTL
 	}
+	room.MessageTTL = ttl
+	return nil
+}
 
-	// Update indexes.
+func (r *InMemoryRepository) SetSlowMode(_ context.Context, roomID string, interval time.Duration) error {
 	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.setSlowMode(roomID, interval)
+}
+
+func (r *InMemoryRepository) setSlowMode(roomID string, interval time.Duration) error {
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+	if interval < 0 {
+		return ErrInvalidSlowMode
+	}
+	room.SlowMode = interval
+	return nil
+}
+
+func (r *InMemoryRepository) JoinRoom(_ context.Context, userID, roomID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.joinRoom(userID, roomID)
+}
+
+func (r *InMemoryRepository) joinRoom(userID, roomID string) error {
+	user, ok := r.users[userID]
+	if !ok {
+		return ErrUserNotFound
+	}
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	// Update indexes.
 	if _, ok := r.userToRooms[user]; ok {
 		if slices.Index(r.userToRooms[user], room) < 0 {
 			r.userToRooms[user] = append(r.userToRooms[user], room)
@@ -108,23 +303,28 @@ func (r *InMemoryRepository) JoinRoom(ctx context.Context, userName, roomName st
 	} else {
 		r.roomToUsers[room] = []*User{user}
 	}
-	r.mu.Unlock()
 
 	return nil
 }
 
-func (r *InMemoryRepository) LeaveRoom(ctx context.Context, userName, roomName string) error {
-	user := r.FindUser(ctx, userName)
-	if user == nil {
-		return errors.New("no user registered under this name")
+func (r *InMemoryRepository) LeaveRoom(_ context.Context, userID, roomID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.leaveRoom(userID, roomID)
+}
+
+func (r *InMemoryRepository) leaveRoom(userID, roomID string) error {
+	user, ok := r.users[userID]
+	if !ok {
+		return ErrUserNotFound
 	}
-	room := r.FindRoom(ctx, roomName)
-	if room == nil {
-		return errors.New("no room with this name exists")
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return ErrRoomNotFound
 	}
 
 	// Update indexes.
-	r.mu.Lock()
 	if _, ok := r.userToRooms[user]; ok {
 		index := slices.Index(r.userToRooms[user], room)
 		if index >= 0 {
@@ -138,38 +338,46 @@ func (r *InMemoryRepository) LeaveRoom(ctx context.Context, userName, roomName s
 			r.roomToUsers[room] = slices.Delete(r.roomToUsers[room], index, index+1)
 		}
 	}
-	r.mu.Unlock()
 
 	return nil
 }
 
-func (r *InMemoryRepository) CreateRoom(ctx context.Context, roomName, creatorUserName string) (*Room, error) {
-	creatorUser := r.FindUser(ctx, creatorUserName)
-	if creatorUser == nil {
-		return nil, errors.New("no user registered under this name")
+func (r *InMemoryRepository) CreateRoom(_ context.Context, roomName, creatorUserID string) (*Room, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	room, err := r.createRoom(NewID(), roomName, creatorUserID)
+	return room.clone(), err
+}
+
+func (r *InMemoryRepository) createRoom(roomID, roomName, creatorUserID string) (*Room, error) {
+	creatorUser, ok := r.users[creatorUserID]
+	if !ok {
+		return nil, ErrUserNotFound
 	}
 
-	if r.FindRoom(ctx, roomName) != nil {
-		return nil, errors.New("room with this name already exists")
+	if _, ok := r.roomNames[roomName]; ok {
+		return nil, ErrRoomExists
 	}
 
 	newRoom := &Room{
+		ID:      roomID,
 		Name:    roomName,
 		Creator: creatorUser,
 	}
 
-	r.mu.Lock()
-	r.rooms[roomName] = newRoom
-	r.mu.Unlock()
+	r.rooms[roomID] = newRoom
+	r.roomNames[roomName] = newRoom
 
 	// User who is creating room automatically joins it.
-	if err := r.JoinRoom(ctx, creatorUserName, roomName); err != nil {
+	if err := r.joinRoom(creatorUserID, roomID); err != nil {
 		return nil, err
 	}
 
 	return newRoom, nil
 }
 
+// ListRooms returns all rooms ordered by name.
 func (r *InMemoryRepository) ListRooms(_ context.Context) ([]*Room, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -177,23 +385,27 @@ func (r *InMemoryRepository) ListRooms(_ context.Context) ([]*Room, error) {
 	rooms := make([]*Room, len(r.rooms))
 	i := 0
 	for _, room := range r.rooms {
-		rooms[i] = room
+		rooms[i] = room.clone()
 		i++
 	}
+	slices.SortFunc(rooms, func(a, b *Room) int {
+		return strings.Compare(a.Name, b.Name)
+	})
 	return rooms, nil
 }
 
-func (r *InMemoryRepository) ListParticipants(ctx context.Context, roomName string) ([]*User, error) {
+func (r *InMemoryRepository) ListParticipants(_ context.Context, roomID string) ([]*User, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
-	room := r.FindRoom(ctx, roomName)
-	if room == nil {
-		return nil, errors.New("no room with this name exists")
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return nil, ErrRoomNotFound
 	}
-	return r.roomToUsers[room], nil
+	return cloneUsers(r.roomToUsers[room]), nil
 }
 
+// ListParticipantsForAllRooms returns participants of all rooms ordered by room name.
 func (r *InMemoryRepository) ListParticipantsForAllRooms(_ context.Context) ([]*RoomParticipation, error) {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
@@ -202,10 +414,164 @@ func (r *InMemoryRepository) ListParticipantsForAllRooms(_ context.Context) ([]*
 	i := 0
 	for _, room := range r.rooms {
 		roomsParticipation[i] = &RoomParticipation{
-			Room:         room,
-			Participants: r.roomToUsers[room],
+			Room:         room.clone(),
+			Participants: cloneUsers(r.roomToUsers[room]),
 		}
 		i++
 	}
+	slices.SortFunc(roomsParticipation, func(a, b *RoomParticipation) int {
+		return strings.Compare(a.Room.Name, b.Room.Name)
+	})
 	return roomsParticipation, nil
 }
+
+func (r *InMemoryRepository) MarkRead(_ context.Context, userID, roomID string, seq uint64) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.markRead(userID, roomID, seq)
+}
+
+func (r *InMemoryRepository) markRead(userID, roomID string, seq uint64) (bool, error) {
+	if _, ok := r.users[userID]; !ok {
+		return false, ErrUserNotFound
+	}
+	if _, ok := r.rooms[roomID]; !ok {
+		return false, ErrRoomNotFound
+	}
+
+	if r.readCursors == nil {
+		r.readCursors = make(map[string]map[string]uint64)
+	}
+	cursors, ok := r.readCursors[userID]
+	if !ok {
+		cursors = make(map[string]uint64)
+		r.readCursors[userID] = cursors
+	}
+	if seq <= cursors[roomID] {
+		return false, nil
+	}
+	cursors[roomID] = seq
+	return true, nil
+}
+
+func (r *InMemoryRepository) ReadCursors(_ context.Context, userID string) (map[string]uint64, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.users[userID]; !ok {
+		return nil, ErrUserNotFound
+	}
+	return maps.Clone(r.readCursors[userID]), nil
+}
+
+func (r *InMemoryRepository) AddModerator(_ context.Context, roomID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.addModerator(roomID, userID)
+}
+
+func (r *InMemoryRepository) addModerator(roomID, userID string) error {
+	if _, ok := r.users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	if _, ok := r.rooms[roomID]; !ok {
+		return ErrRoomNotFound
+	}
+
+	if r.moderators == nil {
+		r.moderators = make(map[string]map[string]bool)
+	}
+	moderators, ok := r.moderators[roomID]
+	if !ok {
+		moderators = make(map[string]bool)
+		r.moderators[roomID] = moderators
+	}
+	moderators[userID] = true
+	return nil
+}
+
+func (r *InMemoryRepository) RemoveModerator(_ context.Context, roomID, userID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.removeModerator(roomID, userID)
+}
+
+func (r *InMemoryRepository) removeModerator(roomID, userID string) error {
+	if _, ok := r.users[userID]; !ok {
+		return ErrUserNotFound
+	}
+	if _, ok := r.rooms[roomID]; !ok {
+		return ErrRoomNotFound
+	}
+
+	delete(r.moderators[roomID], userID)
+	return nil
+}
+
+func (r *InMemoryRepository) CanModerate(_ context.Context, roomID, userID string) bool {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	room, ok := r.rooms[roomID]
+	if !ok {
+		return false
+	}
+	if room.Creator != nil && room.Creator.ID == userID {
+		return true
+	}
+	return r.moderators[roomID][userID]
+}
+
+func (r *InMemoryRepository) PinMessage(_ context.Context, roomID string, pin Pin) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.pinMessage(roomID, pin)
+}
+
+func (r *InMemoryRepository) pinMessage(roomID string, pin Pin) error {
+	if _, ok := r.rooms[roomID]; !ok {
+		return ErrRoomNotFound
+	}
+	if slices.ContainsFunc(r.pins[roomID], func(p Pin) bool { return p.MessageID == pin.MessageID }) {
+		return ErrPinExists
+	}
+
+	if r.pins == nil {
+		r.pins = make(map[string][]Pin)
+	}
+	r.pins[roomID] = append(r.pins[roomID], pin)
+	return nil
+}
+
+func (r *InMemoryRepository) UnpinMessage(_ context.Context, roomID, messageID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.unpinMessage(roomID, messageID)
+}
+
+func (r *InMemoryRepository) unpinMessage(roomID, messageID string) error {
+	if _, ok := r.rooms[roomID]; !ok {
+		return ErrRoomNotFound
+	}
+	index := slices.IndexFunc(r.pins[roomID], func(p Pin) bool { return p.MessageID == messageID })
+	if index < 0 {
+		return ErrPinNotFound
+	}
+	r.pins[roomID] = slices.Delete(r.pins[roomID], index, index+1)
+	return nil
+}
+
+func (r *InMemoryRepository) ListPins(_ context.Context, roomID string) ([]Pin, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if _, ok := r.rooms[roomID]; !ok {
+		return nil, ErrRoomNotFound
+	}
+	return slices.Clone(r.pins[roomID]), nil
+}
@@ -4,25 +4,39 @@ import (
 	"context"
 	"reflect"
 	"testing"
+	"time"
 )
 
 var testUserA = &User{
+	ID:   "8a0cbbd1-5c4b-4f5e-9d1a-3f2e6b7c8d90",
 	Name: "jarvis",
 }
 
 var testUserB = &User{
+	ID:   "0f3d2c1b-a987-4e65-b432-10fedcba9876",
 	Name: "ultron",
 }
 
 var testRoomA = &Room{
+	ID:      "5e4d3c2b-1a09-4f8e-a7d6-c5b4a3928170",
 	Name:    "tower",
 	Creator: testUserA,
 }
 
 var testRoomB = &Room{
+	ID:   "d1c2b3a4-9586-4a7b-8c9d-0e1f2a3b4c5d",
 	Name: "sokovia",
 }
 
+// nameIndex builds name index for users or rooms keyed by ID.
+func nameIndex[T any](byID map[string]*T, name func(*T) string) map[string]*T {
+	index := make(map[string]*T, len(byID))
+	for _, v := range byID {
+		index[name(v)] = v
+	}
+	return index
+}
+
 func TestInMemoryRepository_CreateUser(t *testing.T) {
 	type fields struct {
 		users       map[string]*User
@@ -58,7 +72,7 @@ func TestInMemoryRepository_CreateUser(t *testing.T) {
 			name: "Registering user with the same name as already registered should fail",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms:       map[string]*Room{},
 				userToRooms: map[*User][]*Room{},
@@ -76,6 +90,8 @@ func TestInMemoryRepository_CreateUser(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
@@ -84,6 +100,15 @@ func TestInMemoryRepository_CreateUser(t *testing.T) {
 				t.Errorf("InMemoryRepository.CreateUser() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if got != nil && tt.want != nil {
+				// ID is generated on creation, so only check that it was assigned.
+				if got.ID == "" {
+					t.Errorf("InMemoryRepository.CreateUser() assigned empty ID")
+				}
+				withID := *got
+				withID.ID = tt.want.ID
+				got = &withID
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("InMemoryRepository.CreateUser() = %v, want %v", got, tt.want)
 			}
@@ -111,7 +136,7 @@ func TestInMemoryRepository_FindUser(t *testing.T) {
 			name: "Searching for existing user should succeed",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms:       map[string]*Room{},
 				userToRooms: map[*User][]*Room{},
@@ -141,6 +166,8 @@ func TestInMemoryRepository_FindUser(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
@@ -172,7 +199,7 @@ func TestInMemoryRepository_FindRoom(t *testing.T) {
 			fields: fields{
 				users: map[string]*User{},
 				rooms: map[string]*Room{
-					testRoomB.Name: testRoomB,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
@@ -201,6 +228,8 @@ func TestInMemoryRepository_FindRoom(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
@@ -219,8 +248,8 @@ func TestInMemoryRepository_JoinRoom(t *testing.T) {
 		roomToUsers map[*Room][]*User
 	}
 	type args struct {
-		userName string
-		roomName string
+		userID string
+		roomID string
 	}
 	tests := []struct {
 		name    string
@@ -232,17 +261,17 @@ func TestInMemoryRepository_JoinRoom(t *testing.T) {
 			name: "Existing user joining existing room should succeed",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms: map[string]*Room{
-					testRoomB.Name: testRoomB,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				userName: testUserA.Name,
-				roomName: testRoomB.Name,
+				userID: testUserA.ID,
+				roomID: testRoomB.ID,
 			},
 			wantErr: false,
 		},
@@ -250,10 +279,10 @@ func TestInMemoryRepository_JoinRoom(t *testing.T) {
 			name: "Existing user joining existing room which they previously joined should succeed (no-op)",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms: map[string]*Room{
-					testRoomB.Name: testRoomB,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{
 					testUserA: {testRoomB},
@@ -263,8 +292,8 @@ func TestInMemoryRepository_JoinRoom(t *testing.T) {
 				},
 			},
 			args: args{
-				userName: testUserA.Name,
-				roomName: testRoomB.Name,
+				userID: testUserA.ID,
+				roomID: testRoomB.ID,
 			},
 			wantErr: false,
 		},
@@ -273,14 +302,14 @@ func TestInMemoryRepository_JoinRoom(t *testing.T) {
 			fields: fields{
 				users: map[string]*User{},
 				rooms: map[string]*Room{
-					testRoomB.Name: testRoomB,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				userName: testUserA.Name,
-				roomName: testRoomB.Name,
+				userID: testUserA.ID,
+				roomID: testRoomB.ID,
 			},
 			wantErr: true,
 		},
@@ -288,15 +317,15 @@ func TestInMemoryRepository_JoinRoom(t *testing.T) {
 			name: "Existing user joining non-existing room should fail",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms:       map[string]*Room{},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				userName: testUserA.Name,
-				roomName: testRoomB.Name,
+				userID: testUserA.ID,
+				roomID: testRoomB.ID,
 			},
 			wantErr: true,
 		},
@@ -306,10 +335,12 @@ func TestInMemoryRepository_JoinRoom(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
-			if err := r.JoinRoom(context.Background(), tt.args.userName, tt.args.roomName); (err != nil) != tt.wantErr {
+			if err := r.JoinRoom(context.Background(), tt.args.userID, tt.args.roomID); (err != nil) != tt.wantErr {
 				t.Errorf("InMemoryRepository.JoinRoom() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -324,8 +355,8 @@ func TestInMemoryRepository_LeaveRoom(t *testing.T) {
 		roomToUsers map[*Room][]*User
 	}
 	type args struct {
-		userName string
-		roomName string
+		userID string
+		roomID string
 	}
 	tests := []struct {
 		name    string
@@ -337,10 +368,10 @@ func TestInMemoryRepository_LeaveRoom(t *testing.T) {
 			name: "Existing user leaving existing room they previously joined should succeed",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms: map[string]*Room{
-					testRoomB.Name: testRoomB,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{
 					testUserA: {testRoomB},
@@ -350,8 +381,8 @@ func TestInMemoryRepository_LeaveRoom(t *testing.T) {
 				},
 			},
 			args: args{
-				userName: testUserA.Name,
-				roomName: testRoomB.Name,
+				userID: testUserA.ID,
+				roomID: testRoomB.ID,
 			},
 			wantErr: false,
 		},
@@ -360,17 +391,17 @@ func TestInMemoryRepository_LeaveRoom(t *testing.T) {
 			name: "Existing user 'leaving' existing room which they never joined should succeed (no-op)",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms: map[string]*Room{
-					testRoomB.Name: testRoomB,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				userName: testUserA.Name,
-				roomName: testRoomB.Name,
+				userID: testUserA.ID,
+				roomID: testRoomB.ID,
 			},
 			wantErr: false,
 		},
@@ -379,14 +410,14 @@ func TestInMemoryRepository_LeaveRoom(t *testing.T) {
 			fields: fields{
 				users: map[string]*User{},
 				rooms: map[string]*Room{
-					testRoomB.Name: testRoomB,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				userName: testUserA.Name,
-				roomName: testRoomB.Name,
+				userID: testUserA.ID,
+				roomID: testRoomB.ID,
 			},
 			wantErr: true,
 		},
@@ -394,15 +425,15 @@ func TestInMemoryRepository_LeaveRoom(t *testing.T) {
 			name: "Existing user leaving non-existing room should fail",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms:       map[string]*Room{},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				userName: testUserA.Name,
-				roomName: testRoomB.Name,
+				userID: testUserA.ID,
+				roomID: testRoomB.ID,
 			},
 			wantErr: true,
 		},
@@ -412,10 +443,12 @@ func TestInMemoryRepository_LeaveRoom(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
-			if err := r.LeaveRoom(context.Background(), tt.args.userName, tt.args.roomName); (err != nil) != tt.wantErr {
+			if err := r.LeaveRoom(context.Background(), tt.args.userID, tt.args.roomID); (err != nil) != tt.wantErr {
 				t.Errorf("InMemoryRepository.LeaveRoom() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
@@ -430,8 +463,8 @@ func TestInMemoryRepository_CreateRoom(t *testing.T) {
 		roomToUsers map[*Room][]*User
 	}
 	type args struct {
-		roomName        string
-		creatorUserName string
+		roomName      string
+		creatorUserID string
 	}
 	tests := []struct {
 		name    string
@@ -444,15 +477,15 @@ func TestInMemoryRepository_CreateRoom(t *testing.T) {
 			name: "Existing user creating a new room should succeed",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms:       map[string]*Room{},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				roomName:        testRoomA.Name,
-				creatorUserName: testUserA.Name,
+				roomName:      testRoomA.Name,
+				creatorUserID: testUserA.ID,
 			},
 			want:    testRoomA,
 			wantErr: false,
@@ -461,10 +494,10 @@ func TestInMemoryRepository_CreateRoom(t *testing.T) {
 			name: "Existing user trying to create a room with the same name should fail",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
+					testUserA.ID: testUserA,
 				},
 				rooms: map[string]*Room{
-					testRoomA.Name: testRoomA,
+					testRoomA.ID: testRoomA,
 				},
 				userToRooms: map[*User][]*Room{
 					testUserA: {testRoomA},
@@ -474,8 +507,8 @@ func TestInMemoryRepository_CreateRoom(t *testing.T) {
 				},
 			},
 			args: args{
-				roomName:        testRoomA.Name,
-				creatorUserName: testUserA.Name,
+				roomName:      testRoomA.Name,
+				creatorUserID: testUserA.ID,
 			},
 			want:    nil,
 			wantErr: true,
@@ -489,8 +522,8 @@ func TestInMemoryRepository_CreateRoom(t *testing.T) {
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				roomName:        testRoomA.Name,
-				creatorUserName: testUserA.Name,
+				roomName:      testRoomA.Name,
+				creatorUserID: testUserA.ID,
 			},
 			want:    nil,
 			wantErr: true,
@@ -501,14 +534,25 @@ func TestInMemoryRepository_CreateRoom(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
-			got, err := r.CreateRoom(context.Background(), tt.args.roomName, tt.args.creatorUserName)
+			got, err := r.CreateRoom(context.Background(), tt.args.roomName, tt.args.creatorUserID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("InMemoryRepository.CreateRoom() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if got != nil && tt.want != nil {
+				// ID is generated on creation, so only check that it was assigned.
+				if got.ID == "" {
+					t.Errorf("InMemoryRepository.CreateRoom() assigned empty ID")
+				}
+				withID := *got
+				withID.ID = tt.want.ID
+				got = &withID
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("InMemoryRepository.CreateRoom() = %v, want %v", got, tt.want)
 			}
@@ -536,16 +580,16 @@ func TestInMemoryRepository_ListRooms(t *testing.T) {
 			fields: fields{
 				users: map[string]*User{},
 				rooms: map[string]*Room{
-					testRoomA.Name: testRoomA,
-					testRoomB.Name: testRoomB,
+					testRoomA.ID: testRoomA,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{},
 			want: []*Room{
-				testRoomA,
 				testRoomB,
+				testRoomA,
 			},
 			wantErr: false,
 		},
@@ -555,6 +599,8 @@ func TestInMemoryRepository_ListRooms(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
@@ -578,7 +624,7 @@ func TestInMemoryRepository_ListParticipants(t *testing.T) {
 		roomToUsers map[*Room][]*User
 	}
 	type args struct {
-		roomName string
+		roomID string
 	}
 	tests := []struct {
 		name    string
@@ -591,11 +637,11 @@ func TestInMemoryRepository_ListParticipants(t *testing.T) {
 			name: "Successful call should return list of users who have joined the room",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
-					testUserB.Name: testUserB,
+					testUserA.ID: testUserA,
+					testUserB.ID: testUserB,
 				},
 				rooms: map[string]*Room{
-					testRoomA.Name: testRoomA,
+					testRoomA.ID: testRoomA,
 				},
 				userToRooms: map[*User][]*Room{
 					testUserA: {testRoomA},
@@ -606,7 +652,7 @@ func TestInMemoryRepository_ListParticipants(t *testing.T) {
 				},
 			},
 			args: args{
-				roomName: testRoomA.Name,
+				roomID: testRoomA.ID,
 			},
 			want: []*User{
 				testUserA,
@@ -618,15 +664,15 @@ func TestInMemoryRepository_ListParticipants(t *testing.T) {
 			name: "Call for non-existent room should fail",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
-					testUserB.Name: testUserB,
+					testUserA.ID: testUserA,
+					testUserB.ID: testUserB,
 				},
 				rooms:       map[string]*Room{},
 				userToRooms: map[*User][]*Room{},
 				roomToUsers: map[*Room][]*User{},
 			},
 			args: args{
-				roomName: testRoomA.Name,
+				roomID: testRoomA.ID,
 			},
 			want:    nil,
 			wantErr: true,
@@ -637,10 +683,12 @@ func TestInMemoryRepository_ListParticipants(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
-			got, err := r.ListParticipants(context.Background(), tt.args.roomName)
+			got, err := r.ListParticipants(context.Background(), tt.args.roomID)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("InMemoryRepository.ListParticipants() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -671,12 +719,12 @@ func TestInMemoryRepository_ListParticipantsForAllRooms(t *testing.T) {
 			name: "Successful call should return list of all rooms and all users joinied each room",
 			fields: fields{
 				users: map[string]*User{
-					testUserA.Name: testUserA,
-					testUserB.Name: testUserB,
+					testUserA.ID: testUserA,
+					testUserB.ID: testUserB,
 				},
 				rooms: map[string]*Room{
-					testRoomA.Name: testRoomA,
-					testRoomB.Name: testRoomB,
+					testRoomA.ID: testRoomA,
+					testRoomB.ID: testRoomB,
 				},
 				userToRooms: map[*User][]*Room{
 					testUserA: {testRoomA},
@@ -689,14 +737,14 @@ func TestInMemoryRepository_ListParticipantsForAllRooms(t *testing.T) {
 			},
 			args: args{},
 			want: []*RoomParticipation{
-				{
-					Room:         testRoomA,
-					Participants: []*User{testUserA, testUserB},
-				},
 				{
 					Room:         testRoomB,
 					Participants: []*User{testUserB},
 				},
+				{
+					Room:         testRoomA,
+					Participants: []*User{testUserA, testUserB},
+				},
 			},
 			wantErr: false,
 		},
@@ -706,6 +754,8 @@ func TestInMemoryRepository_ListParticipantsForAllRooms(t *testing.T) {
 			r := &InMemoryRepository{
 				users:       tt.fields.users,
 				rooms:       tt.fields.rooms,
+				userNames:   nameIndex(tt.fields.users, func(u *User) string { return u.Name }),
+				roomNames:   nameIndex(tt.fields.rooms, func(r *Room) string { return r.Name }),
 				userToRooms: tt.fields.userToRooms,
 				roomToUsers: tt.fields.roomToUsers,
 			}
@@ -720,3 +770,560 @@ func TestInMemoryRepository_ListParticipantsForAllRooms(t *testing.T) {
 		})
 	}
 }
+
+func TestInMemoryRepository_GetUser(t *testing.T) {
+	type fields struct {
+		users map[string]*User
+	}
+	type args struct {
+		userID string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   *User
+	}{
+		{
+			name: "Getting existing user by ID should succeed",
+			fields: fields{
+				users: map[string]*User{
+					testUserA.ID: testUserA,
+				},
+			},
+			args: args{
+				userID: testUserA.ID,
+			},
+			want: testUserA,
+		},
+		{
+			name: "Getting user by name instead of ID should return nil",
+			fields: fields{
+				users: map[string]*User{
+					testUserA.ID: testUserA,
+				},
+			},
+			args: args{
+				userID: testUserA.Name,
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InMemoryRepository{
+				users: tt.fields.users,
+			}
+			if got := r.GetUser(context.Background(), tt.args.userID); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InMemoryRepository.GetUser() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_GetRoom(t *testing.T) {
+	type fields struct {
+		rooms map[string]*Room
+	}
+	type args struct {
+		roomID string
+	}
+	tests := []struct {
+		name   string
+		fields fields
+		args   args
+		want   *Room
+	}{
+		{
+			name: "Getting existing room by ID should succeed",
+			fields: fields{
+				rooms: map[string]*Room{
+					testRoomB.ID: testRoomB,
+				},
+			},
+			args: args{
+				roomID: testRoomB.ID,
+			},
+			want: testRoomB,
+		},
+		{
+			name: "Getting non-existing room should return nil",
+			fields: fields{
+				rooms: map[string]*Room{},
+			},
+			args: args{
+				roomID: testRoomB.ID,
+			},
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &InMemoryRepository{
+				rooms: tt.fields.rooms,
+			}
+			if got := r.GetRoom(context.Background(), tt.args.roomID); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("InMemoryRepository.GetRoom() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_RenameUser(t *testing.T) {
+	type args struct {
+		userID  string
+		newName string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "Renaming user to a free name should succeed and keep memberships",
+			args: args{
+				userID:  testUserA.ID,
+				newName: "vision",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Renaming user to the same name should succeed (no-op)",
+			args: args{
+				userID:  testUserA.ID,
+				newName: testUserA.Name,
+			},
+			wantErr: false,
+		},
+		{
+			name: "Renaming user to a name taken by other user should fail",
+			args: args{
+				userID:  testUserA.ID,
+				newName: testUserB.Name,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Renaming non-existing user should fail",
+			args: args{
+				userID:  "no-such-id",
+				newName: "vision",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Renaming changes user in place, so work on copies.
+			userA, userB := *testUserA, *testUserB
+			room := &Room{ID: testRoomA.ID, Name: testRoomA.Name}
+			r := &InMemoryRepository{
+				users:       map[string]*User{userA.ID: &userA, userB.ID: &userB},
+				rooms:       map[string]*Room{room.ID: room},
+				userNames:   map[string]*User{userA.Name: &userA, userB.Name: &userB},
+				roomNames:   map[string]*Room{room.Name: room},
+				userToRooms: map[*User][]*Room{&userA: {room}},
+				roomToUsers: map[*Room][]*User{room: {&userA}},
+			}
+			err := r.RenameUser(context.Background(), tt.args.userID, tt.args.newName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InMemoryRepository.RenameUser() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			got := r.FindUser(context.Background(), tt.args.newName)
+			if got == nil || got.ID != tt.args.userID {
+				t.Errorf("InMemoryRepository.RenameUser() user is not found by new name, got %v", got)
+				return
+			}
+			participants, _ := r.ListParticipants(context.Background(), room.ID)
+			if !reflect.DeepEqual(participants, []*User{got}) {
+				t.Errorf("InMemoryRepository.RenameUser() participants = %v, want %v", participants, []*User{got})
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_RenameRoom(t *testing.T) {
+	type args struct {
+		roomID  string
+		newName string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name: "Renaming room to a free name should succeed",
+			args: args{
+				roomID:  testRoomA.ID,
+				newName: "avengers",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Renaming room to a name taken by other room should fail",
+			args: args{
+				roomID:  testRoomA.ID,
+				newName: testRoomB.Name,
+			},
+			wantErr: true,
+		},
+		{
+			name: "Renaming non-existing room should fail",
+			args: args{
+				roomID:  "no-such-id",
+				newName: "avengers",
+			},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomA, roomB := *testRoomA, *testRoomB
+			r := &InMemoryRepository{
+				rooms:     map[string]*Room{roomA.ID: &roomA, roomB.ID: &roomB},
+				roomNames: map[string]*Room{roomA.Name: &roomA, roomB.Name: &roomB},
+			}
+			err := r.RenameRoom(context.Background(), tt.args.roomID, tt.args.newName)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InMemoryRepository.RenameRoom() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := r.FindRoom(context.Background(), tt.args.newName); !reflect.DeepEqual(got, &roomA) {
+				t.Errorf("InMemoryRepository.RenameRoom() room by new name = %v, want %v", got, &roomA)
+			}
+			if got := r.FindRoom(context.Background(), testRoomA.Name); got != nil {
+				t.Errorf("InMemoryRepository.RenameRoom() room by old name = %v, want nil", got)
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_SetMessageTTL(t *testing.T) {
+	type args struct {
+		roomID string
+		ttl    time.Duration
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name:    "Setting message lifetime should succeed",
+			args:    args{roomID: testRoomA.ID, ttl: time.Hour},
+			wantErr: false,
+		},
+		{
+			name:    "Resetting message lifetime should succeed",
+			args:    args{roomID: testRoomA.ID, ttl: 0},
+			wantErr: false,
+		},
+		{
+			name:    "Setting negative message lifetime should fail",
+			args:    args{roomID: testRoomA.ID, ttl: -time.Hour},
+			wantErr: true,
+		},
+		{
+			name:    "Setting message lifetime of non-existing room should fail",
+			args:    args{roomID: "no-such-id", ttl: time.Hour},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomA := *testRoomA
+			roomA.MessageTTL = time.Minute
+			r := &InMemoryRepository{
+				rooms: map[string]*Room{roomA.ID: &roomA},
+			}
+			err := r.SetMessageTTL(context.Background(), tt.args.roomID, tt.args.ttl)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InMemoryRepository.SetMessageTTL() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := r.GetRoom(context.Background(), tt.args.roomID); got.MessageTTL != tt.args.ttl {
+				t.Errorf("InMemoryRepository.SetMessageTTL() room message lifetime = %v, want %v", got.MessageTTL, tt.args.ttl)
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_SetSlowMode(t *testing.T) {
+	type args struct {
+		roomID   string
+		interval time.Duration
+	}
+	tests := []struct {
+		name    string
+		args    args
+		wantErr bool
+	}{
+		{
+			name:    "Setting slow mode should succeed",
+			args:    args{roomID: testRoomA.ID, interval: 30 * time.Second},
+			wantErr: false,
+		},
+		{
+			name:    "Turning slow mode off should succeed",
+			args:    args{roomID: testRoomA.ID, interval: 0},
+			wantErr: false,
+		},
+		{
+			name:    "Setting negative slow mode interval should fail",
+			args:    args{roomID: testRoomA.ID, interval: -time.Second},
+			wantErr: true,
+		},
+		{
+			name:    "Setting slow mode of non-existing room should fail",
+			args:    args{roomID: "no-such-id", interval: time.Second},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			roomA := *testRoomA
+			roomA.SlowMode = time.Minute
+			r := &InMemoryRepository{
+				rooms: map[string]*Room{roomA.ID: &roomA},
+			}
+			err := r.SetSlowMode(context.Background(), tt.args.roomID, tt.args.interval)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InMemoryRepository.SetSlowMode() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := r.GetRoom(context.Background(), tt.args.roomID); got.SlowMode != tt.args.interval {
+				t.Errorf("InMemoryRepository.SetSlowMode() room slow mode = %v, want %v", got.SlowMode, tt.args.interval)
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_CanModerate(t *testing.T) {
+	tests := []struct {
+		name       string
+		roomID     string
+		userID     string
+		moderators []string
+		want       bool
+	}{
+		{
+			name:   "Room creator should moderate the room",
+			roomID: testRoomA.ID,
+			userID: testUserA.ID,
+			want:   true,
+		},
+		{
+			name:       "Appointed moderator should moderate the room",
+			roomID:     testRoomA.ID,
+			userID:     testUserB.ID,
+			moderators: []string{testUserB.ID},
+			want:       true,
+		},
+		{
+			name:   "Other participant should not moderate the room",
+			roomID: testRoomA.ID,
+			userID: testUserB.ID,
+			want:   false,
+		},
+		{
+			name:       "Moderator of another room should not moderate the room",
+			roomID:     testRoomB.ID,
+			userID:     testUserB.ID,
+			moderators: []string{testUserB.ID},
+			want:       false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := &InMemoryRepository{
+				users: map[string]*User{testUserA.ID: testUserA, testUserB.ID: testUserB},
+				rooms: map[string]*Room{testRoomA.ID: testRoomA, testRoomB.ID: testRoomB},
+			}
+			for _, userID := range tt.moderators {
+				if err := r.AddModerator(ctx, testRoomA.ID, userID); err != nil {
+					t.Fatalf("InMemoryRepository.AddModerator() error = %v", err)
+				}
+			}
+			if got := r.CanModerate(ctx, tt.roomID, tt.userID); got != tt.want {
+				t.Errorf("InMemoryRepository.CanModerate() = %v, want %v", got, tt.want)
+			}
+			// Dismissed moderator does not moderate anymore, while creator always does.
+			for _, userID := range tt.moderators {
+				r.RemoveModerator(ctx, testRoomA.ID, userID)
+				if r.CanModerate(ctx, testRoomA.ID, userID) {
+					t.Errorf("InMemoryRepository.CanModerate() of dismissed moderator = true, want false")
+				}
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_PinMessage(t *testing.T) {
+	pinnedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	first := Pin{MessageID: "first", PinnedBy: testUserA.ID, PinnedAt: pinnedAt}
+	second := Pin{MessageID: "second", PinnedBy: testUserA.ID, PinnedAt: pinnedAt.Add(time.Minute)}
+	tests := []struct {
+		name     string
+		roomID   string
+		pin      Pin
+		wantErr  error
+		wantPins []Pin
+	}{
+		{
+			name:     "Pinned message should follow earlier pins",
+			roomID:   testRoomA.ID,
+			pin:      second,
+			wantPins: []Pin{first, second},
+		},
+		{
+			name:     "Pinning the message twice should fail",
+			roomID:   testRoomA.ID,
+			pin:      first,
+			wantErr:  ErrPinExists,
+			wantPins: []Pin{first},
+		},
+		{
+			name:    "Pinning message in non-existing room should fail",
+			roomID:  "no-such-id",
+			pin:     second,
+			wantErr: ErrRoomNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := &InMemoryRepository{
+				rooms: map[string]*Room{testRoomA.ID: testRoomA},
+			}
+			r.PinMessage(ctx, testRoomA.ID, first)
+			if err := r.PinMessage(ctx, tt.roomID, tt.pin); err != tt.wantErr {
+				t.Fatalf("InMemoryRepository.PinMessage() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr != nil && tt.roomID != testRoomA.ID {
+				return
+			}
+			if got, _ := r.ListPins(ctx, tt.roomID); !reflect.DeepEqual(got, tt.wantPins) {
+				t.Errorf("InMemoryRepository.ListPins() = %v, want %v", got, tt.wantPins)
+			}
+			if err := r.UnpinMessage(ctx, tt.roomID, first.MessageID); err != nil {
+				t.Errorf("InMemoryRepository.UnpinMessage() error = %v", err)
+			}
+			if err := r.UnpinMessage(ctx, tt.roomID, first.MessageID); err != ErrPinNotFound {
+				t.Errorf("InMemoryRepository.UnpinMessage() of unpinned message error = %v, want %v", err, ErrPinNotFound)
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_MarkRead(t *testing.T) {
+	type args struct {
+		userID string
+		roomID string
+		seq    uint64
+	}
+	tests := []struct {
+		name       string
+		args       args
+		want       bool
+		wantCursor uint64
+		wantErr    bool
+	}{
+		{
+			name:       "Reading newer message should move cursor",
+			args:       args{userID: testUserA.ID, roomID: testRoomA.ID, seq: 10},
+			want:       true,
+			wantCursor: 10,
+		},
+		{
+			name:       "Reading older message should not move cursor back",
+			args:       args{userID: testUserA.ID, roomID: testRoomA.ID, seq: 3},
+			want:       false,
+			wantCursor: 5,
+		},
+		{
+			name:       "Reading in another room should start its own cursor",
+			args:       args{userID: testUserA.ID, roomID: testRoomB.ID, seq: 3},
+			want:       true,
+			wantCursor: 3,
+		},
+		{
+			name:    "Non-existing user reading should fail",
+			args:    args{userID: "non-existing", roomID: testRoomA.ID, seq: 10},
+			wantErr: true,
+		},
+		{
+			name:    "Reading in non-existing room should fail",
+			args:    args{userID: testUserA.ID, roomID: "non-existing", seq: 10},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			r := &InMemoryRepository{
+				users: map[string]*User{testUserA.ID: testUserA},
+				rooms: map[string]*Room{testRoomA.ID: testRoomA, testRoomB.ID: testRoomB},
+				readCursors: map[string]map[string]uint64{
+					testUserA.ID: {testRoomA.ID: 5},
+				},
+			}
+			got, err := r.MarkRead(ctx, tt.args.userID, tt.args.roomID, tt.args.seq)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("InMemoryRepository.MarkRead() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("InMemoryRepository.MarkRead() = %v, want %v", got, tt.want)
+			}
+			cursors, _ := r.ReadCursors(ctx, tt.args.userID)
+			if cursors[tt.args.roomID] != tt.wantCursor {
+				t.Errorf("InMemoryRepository.ReadCursors() = %v, want %d in room %s", cursors, tt.wantCursor, tt.args.roomID)
+			}
+		})
+	}
+}
+
+func TestInMemoryRepository_ReturnsCopies(t *testing.T) {
+	ctx := context.Background()
+	r := newInMemoryRepository()
+	user, _ := r.CreateUser(ctx, testUserA.Name)
+	room, _ := r.CreateRoom(ctx, testRoomA.Name, user.ID)
+
+	// Copies could be read while repository changes, without locking.
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		r.RenameUser(ctx, user.ID, "vision")
+		r.RenameRoom(ctx, room.ID, "avengers")
+	}()
+	if room.Name != testRoomA.Name || room.Creator.Name != testUserA.Name {
+		t.Errorf("InMemoryRepository.CreateRoom() returned room changed to %v", room)
+	}
+	<-done
+
+	got := r.GetRoom(ctx, room.ID)
+	if got.Name != "avengers" || got.Creator.Name != "vision" {
+		t.Errorf("InMemoryRepository.GetRoom() = %v, want renamed room created by renamed user", got)
+	}
+	got.Name = "changed"
+	if r.GetRoom(ctx, room.ID).Name != "avengers" {
+		t.Errorf("InMemoryRepository.GetRoom() returned room shared with repository")
+	}
+}
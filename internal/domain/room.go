@@ -1,12 +1,36 @@
 package domain
 
-// User represents chat room for users to exchange messages.
-// TODO: Add unique ID (e.g., GUID).
+import "time"
+
+// Room represents chat room for users to exchange messages.
+// ID is stable for the whole room's lifetime, while name could be changed.
+// Messages of the room are removed after MessageTTL, if it is set.
+// In slow mode, participants post new messages no more often than once per SlowMode.
 type Room struct {
-	Name    string
-	Creator *User
+	ID         string
+	Name       string
+	Creator    *User
+	MessageTTL time.Duration
+	SlowMode   time.Duration
+}
+
+// Pin keeps message visible above the room log until it is unpinned.
+type Pin struct {
+	MessageID string
+	// ID of the user who pinned the message.
+	PinnedBy string
+	PinnedAt time.Time
 }
 
-var defaultRoom = &Room{
-	Name: "general",
+const defaultRoomName = "general"
+
+// clone detaches room, along with its creator, from the repository, so that it can be read
+// without locking while the repository changes its own copy.
+func (r *Room) clone() *Room {
+	if r == nil {
+		return nil
+	}
+	c := *r
+	c.Creator = r.Creator.clone()
+	return &c
 }
@@ -1,7 +1,26 @@
 package domain
 
 // User represents chat participant.
-// TODO: Add unique ID (e.g., GUID).
+// ID is stable for the whole user's lifetime, while name could be changed.
 type User struct {
+	ID   string
 	Name string
 }
+
+// clone detaches user from the repository, so that it can be read without locking
+// while the repository changes its own copy.
+func (u *User) clone() *User {
+	if u == nil {
+		return nil
+	}
+	c := *u
+	return &c
+}
+
+func cloneUsers(users []*User) []*User {
+	cloned := make([]*User, len(users))
+	for i, u := range users {
+		cloned[i] = u.clone()
+	}
+	return cloned
+}
@@ -0,0 +1,301 @@
+package export
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+// Format of the exported transcript.
+type Format string
+
+const (
+	JSONLines Format = "jsonl"
+	CSV       Format = "csv"
+	Text      Format = "text"
+	HTML      Format = "html"
+)
+
+var (
+	ErrUnknownFormat = errors.New("unknown export format")
+	ErrInvalidPeriod = errors.New("export period ends before it starts")
+)
+
+// Layout of message time in text and HTML transcripts; times are in UTC.
+const transcriptTimeLayout = "2006-01-02 15:04:05"
+
+// ParseFormat returns format by its name, JSON Lines if the name is empty.
+func ParseFormat(name string) (Format, error) {
+	switch format := Format(name); format {
+	case "":
+		return JSONLines, nil
+	case JSONLines, CSV, Text, HTML:
+		return format, nil
+	default:
+		return "", fmt.Errorf("%w %q", ErrUnknownFormat, name)
+	}
+}
+
+// ContentType returns MIME type of the transcript.
+func (f Format) ContentType() string {
+	switch f {
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case Text:
+		return "text/plain; charset=utf-8"
+	case HTML:
+		return "text/html; charset=utf-8"
+	default:
+		return "application/jsonl"
+	}
+}
+
+// Extension returns file name extension of the transcript.
+func (f Format) Extension() string {
+	if f == Text {
+		return "txt"
+	}
+	return string(f)
+}
+
+// Period selects messages sent at From or later, but before To; zero time means no bound.
+type Period struct {
+	From time.Time
+	To   time.Time
+}
+
+func (p Period) validate() error {
+	if !p.From.IsZero() && !p.To.IsZero() && p.To.Before(p.From) {
+		return ErrInvalidPeriod
+	}
+	return nil
+}
+
+// Room writes history of the room within the period to w, oldest message first.
+// Messages are written as they are read from the store page by page, so the transcript
+// is streamed rather than buffered, whatever the size of the history.
+func Room(ctx context.Context, w io.Writer, store message.Store, room *domain.Room, format Format, period Period) error {
+	if err := period.validate(); err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(w)
+	var t transcript
+	switch format {
+	case JSONLines:
+		t = &jsonLinesTranscript{encoder: json.NewEncoder(buffered)}
+	case CSV:
+		t = &csvTranscript{writer: csv.NewWriter(buffered)}
+	case Text:
+		t = &textTranscript{w: buffered, room: room, period: period}
+	case HTML:
+		t = &htmlTranscript{w: buffered, room: room, period: period}
+	default:
+		return fmt.Errorf("%w %q", ErrUnknownFormat, format)
+	}
+
+	if err := t.begin(); err != nil {
+		return err
+	}
+	err := message.Walk(ctx, store, room.ID, func(msg *message.Message) error {
+		// History is in order of sequence numbers, which is not chronological for imported messages,
+		// so the whole history is walked and messages out of the period are skipped.
+		if !period.To.IsZero() && !msg.ServerTime.Before(period.To) || msg.ServerTime.Before(period.From) {
+			return nil
+		}
+		return t.write(msg)
+	})
+	if err != nil {
+		return err
+	}
+	if err := t.end(); err != nil {
+		return err
+	}
+	return buffered.Flush()
+}
+
+// transcript renders messages in particular format.
+type transcript interface {
+	begin() error
+	write(msg *message.Message) error
+	end() error
+}
+
+// jsonLinesTranscript writes every message as a JSON object on its own line, same as in history pages.
+type jsonLinesTranscript struct {
+	encoder *json.Encoder
+}
+
+func (t *jsonLinesTranscript) begin() error { return nil }
+
+func (t *jsonLinesTranscript) write(msg *message.Message) error {
+	return t.encoder.Encode(msg)
+}
+
+func (t *jsonLinesTranscript) end() error { return nil }
+
+// csvTranscript writes a row per message with a header row first.
+type csvTranscript struct {
+	writer *csv.Writer
+}
+
+func (t *csvTranscript) begin() error {
+	return t.writer.Write([]string{"id", "seq", "time", "user_id", "user", "type", "parent_id", "content", "edited_at", "deleted_at"})
+}
+
+func (t *csvTranscript) write(msg *message.Message) error {
+	return t.writer.Write([]string{
+		msg.ID,
+		strconv.FormatUint(msg.Seq, 10),
+		formatTime(&msg.ServerTime),
+		msg.UserID,
+		msg.User,
+		msg.Type(),
+		msg.ParentID,
+		content(msg),
+		formatTime(msg.EditedAt),
+		formatTime(msg.DeletedAt),
+	})
+}
+
+func (t *csvTranscript) end() error {
+	t.writer.Flush()
+	return t.writer.Error()
+}
+
+// textTranscript writes a line per message, as people read chat logs.
+type textTranscript struct {
+	w      io.Writer
+	room   *domain.Room
+	period Period
+}
+
+func (t *textTranscript) begin() error {
+	_, err := fmt.Fprintf(t.w, "Transcript of room %s%s\n\n", t.room.Name, describePeriod(t.period))
+	return err
+}
+
+func (t *textTranscript) write(msg *message.Message) error {
+	reply := ""
+	if msg.IsReply() {
+		reply = "  > "
+	}
+	_, err := fmt.Fprintf(t.w, "[%s] %s%s: %s%s\n",
+		msg.ServerTime.UTC().Format(transcriptTimeLayout), reply, msg.User, content(msg), remark(msg))
+	return err
+}
+
+func (t *textTranscript) end() error { return nil }
+
+// htmlTranscript writes a self-contained page, which needs nothing but a browser to read.
+type htmlTranscript struct {
+	w      io.Writer
+	room   *domain.Room
+	period Period
+}
+
+const htmlHead = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>%s</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+.message { margin: 0.3em 0; }
+.reply { margin-left: 2em; }
+.deleted { color: gray; font-style: italic; }
+time { color: gray; font-size: smaller; margin-right: 0.5em; }
+</style>
+</head>
+<body>
+<h1>%s</h1>
+`
+
+func (t *htmlTranscript) begin() error {
+	title := html.EscapeString(fmt.Sprintf("Transcript of room %s%s", t.room.Name, describePeriod(t.period)))
+	_, err := fmt.Fprintf(t.w, htmlHead, title, title)
+	return err
+}
+
+func (t *htmlTranscript) write(msg *message.Message) error {
+	class := "message"
+	if msg.IsReply() {
+		class += " reply"
+	}
+	if msg.IsDeleted() {
+		class += " deleted"
+	}
+	_, err := fmt.Fprintf(t.w, "<div class=\"%s\" id=\"%s\"><time datetime=\"%s\">%s</time><b>%s:</b> %s%s</div>\n",
+		class,
+		html.EscapeString(msg.ID),
+		formatTime(&msg.ServerTime),
+		msg.ServerTime.UTC().Format(transcriptTimeLayout),
+		html.EscapeString(msg.User),
+		html.EscapeString(content(msg)),
+		html.EscapeString(remark(msg)))
+	return err
+}
+
+func (t *htmlTranscript) end() error {
+	_, err := io.WriteString(t.w, "</body>\n</html>\n")
+	return err
+}
+
+// content describes message in a human readable way, text messages by their text.
+func content(msg *message.Message) string {
+	if msg.IsDeleted() {
+		return "message deleted"
+	}
+	switch body := msg.Body.(type) {
+	case *message.TextBody:
+		return body.Text
+	case *message.AttachmentBody:
+		return fmt.Sprintf("[file %s]", body.Name)
+	case *message.MembershipBody:
+		if body.Event == message.JoinRoomEvent {
+			return "joined the room"
+		}
+		return "left the room"
+	case *message.NotificationBody:
+		return body.Event
+	default:
+		return fmt.Sprintf("[%s message]", msg.Type())
+	}
+}
+
+// remark notes that message was edited, which transcripts for people show after the text.
+func remark(msg *message.Message) string {
+	if msg.EditedAt != nil && !msg.IsDeleted() {
+		return " (edited)"
+	}
+	return ""
+}
+
+func describePeriod(period Period) string {
+	switch {
+	case !period.From.IsZero() && !period.To.IsZero():
+		return fmt.Sprintf(" from %s to %s UTC", period.From.UTC().Format(transcriptTimeLayout), period.To.UTC().Format(transcriptTimeLayout))
+	case !period.From.IsZero():
+		return fmt.Sprintf(" from %s UTC", period.From.UTC().Format(transcriptTimeLayout))
+	case !period.To.IsZero():
+		return fmt.Sprintf(" until %s UTC", period.To.UTC().Format(transcriptTimeLayout))
+	default:
+		return ""
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
@@ -0,0 +1,204 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+var (
+	testRoom = &domain.Room{ID: "b3d7a6a4-1f3e-4b8e-9a51-0c6f1e9d7a11", Name: "general"}
+	testTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func testStore(t *testing.T) message.Store {
+	t.Helper()
+	ctx := context.Background()
+	s := message.NewInMemoryStore(message.RetentionPolicy{}, nil, log.New(io.Discard, "", 0))
+	t.Cleanup(func() { s.Close() })
+
+	edited := testTime.Add(time.Minute)
+	deleted := testTime.Add(3 * time.Minute)
+	messages := []*message.Message{
+		{
+			ID: "00000000-0000-4000-8000-000000000001", Seq: 1, UserID: "u1", User: "alice",
+			RoomID: testRoom.ID, Room: testRoom.Name, ServerTime: testTime,
+			Body: &message.TextBody{Text: "<b>hi</b>, all"}, EditedAt: &edited,
+		},
+		{
+			ID: "00000000-0000-4000-8000-000000000002", Seq: 2, UserID: "u2", User: "bob",
+			RoomID: testRoom.ID, Room: testRoom.Name, ServerTime: testTime.Add(time.Hour),
+			Body: &message.TextBody{Text: "hello"}, ParentID: "00000000-0000-4000-8000-000000000001",
+		},
+		{
+			ID: "00000000-0000-4000-8000-000000000003", Seq: 3, UserID: "u2", User: "bob",
+			RoomID: testRoom.ID, Room: testRoom.Name, ServerTime: testTime.Add(2 * time.Hour),
+			Body: &message.TextBody{}, DeletedAt: &deleted,
+		},
+	}
+	for _, msg := range messages {
+		if err := s.SaveMessage(ctx, testRoom.ID, msg); err != nil {
+			t.Fatalf("Store.SaveMessage() error = %v", err)
+		}
+	}
+	return s
+}
+
+func TestRoom(t *testing.T) {
+	tests := []struct {
+		name    string
+		format  Format
+		period  Period
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "Text transcript should have a line per message",
+			format: Text,
+			want: "Transcript of room general\n\n" +
+				"[2024-05-01 12:00:00] alice: <b>hi</b>, all (edited)\n" +
+				"[2024-05-01 13:00:00]   > bob: hello\n" +
+				"[2024-05-01 14:00:00] bob: message deleted\n",
+		},
+		{
+			name:   "CSV transcript should have a header and a row per message",
+			format: CSV,
+			want: "id,seq,time,user_id,user,type,parent_id,content,edited_at,deleted_at\n" +
+				"00000000-0000-4000-8000-000000000001,1,2024-05-01T12:00:00Z,u1,alice,text,,\"<b>hi</b>, all\",2024-05-01T12:01:00Z,\n" +
+				"00000000-0000-4000-8000-000000000002,2,2024-05-01T13:00:00Z,u2,bob,text,00000000-0000-4000-8000-000000000001,hello,,\n" +
+				"00000000-0000-4000-8000-000000000003,3,2024-05-01T14:00:00Z,u2,bob,text,,message deleted,,2024-05-01T12:03:00Z\n",
+		},
+		{
+			name:   "Period should select messages from its start up to its end",
+			format: Text,
+			period: Period{From: testTime.Add(time.Minute), To: testTime.Add(2 * time.Hour)},
+			want: "Transcript of room general from 2024-05-01 12:01:00 to 2024-05-01 14:00:00 UTC\n\n" +
+				"[2024-05-01 13:00:00]   > bob: hello\n",
+		},
+		{
+			name:    "Period ending before its start should be rejected",
+			format:  Text,
+			period:  Period{From: testTime, To: testTime.Add(-time.Hour)},
+			wantErr: ErrInvalidPeriod,
+		},
+		{
+			name:    "Unknown format should be rejected",
+			format:  Format("pdf"),
+			wantErr: ErrUnknownFormat,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := Room(context.Background(), &out, testStore(t), testRoom, tt.format, tt.period)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Room() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("Room() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRoom_OutOfOrderTimes(t *testing.T) {
+	ctx := context.Background()
+	s := message.NewInMemoryStore(message.RetentionPolicy{}, nil, log.New(io.Discard, "", 0))
+	defer s.Close()
+	// Imported history may get sequence numbers out of chronological order.
+	for i, at := range []time.Time{testTime.Add(3 * time.Hour), testTime.Add(time.Hour)} {
+		msg := &message.Message{
+			ID: fmt.Sprintf("00000000-0000-4000-8000-00000000000%d", i+1), Seq: uint64(i + 1), UserID: "u1", User: "alice",
+			RoomID: testRoom.ID, Room: testRoom.Name, ServerTime: at, Body: &message.TextBody{Text: "hi"},
+		}
+		if err := s.SaveMessage(ctx, testRoom.ID, msg); err != nil {
+			t.Fatalf("Store.SaveMessage() error = %v", err)
+		}
+	}
+
+	var out bytes.Buffer
+	period := Period{From: testTime, To: testTime.Add(2 * time.Hour)}
+	if err := Room(ctx, &out, s, testRoom, Text, period); err != nil {
+		t.Fatalf("Room() error = %v", err)
+	}
+	if got := out.String(); !strings.Contains(got, "[2024-05-01 13:00:00] alice: hi") {
+		t.Errorf("Room() = %q, want message within the period following one after it", got)
+	}
+}
+
+func TestRoom_JSONLines(t *testing.T) {
+	var out bytes.Buffer
+	if err := Room(context.Background(), &out, testStore(t), testRoom, JSONLines, Period{}); err != nil {
+		t.Fatalf("Room() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("Room() wrote %d lines, want 3", len(lines))
+	}
+	for i, line := range lines {
+		var msg message.Message
+		if err := msg.UnmarshalJSON([]byte(line)); err != nil {
+			t.Fatalf("line %d is not a message: %v", i+1, err)
+		}
+		if msg.Seq != uint64(i+1) {
+			t.Errorf("line %d has message %d", i+1, msg.Seq)
+		}
+	}
+}
+
+func TestRoom_HTML(t *testing.T) {
+	var out bytes.Buffer
+	if err := Room(context.Background(), &out, testStore(t), testRoom, HTML, Period{}); err != nil {
+		t.Fatalf("Room() error = %v", err)
+	}
+	got := out.String()
+	for _, want := range []string{
+		"<!DOCTYPE html>",
+		"<title>Transcript of room general</title>",
+		"&lt;b&gt;hi&lt;/b&gt;, all (edited)",
+		`<div class="message reply"`,
+		`<div class="message deleted"`,
+		"</html>\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Room() = %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "<b>hi</b>") {
+		t.Errorf("Room() did not escape message text")
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	tests := []struct {
+		name    string
+		want    Format
+		wantErr bool
+	}{
+		{name: "", want: JSONLines},
+		{name: "csv", want: CSV},
+		{name: "text", want: Text},
+		{name: "html", want: HTML},
+		{name: "xml", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFormat(tt.name)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseFormat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseFormat() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
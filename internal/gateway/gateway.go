@@ -3,12 +3,17 @@ package gateway
 import (
 	"context"
 	"log"
+	"sync"
 	"sync/atomic"
 
 	"github.com/gorilla/mux"
+	"github.com/lennylebedinsky/chatter/internal/blob"
 	"github.com/lennylebedinsky/chatter/internal/chat"
 	"github.com/lennylebedinsky/chatter/internal/domain"
 	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/moderation"
+	"github.com/lennylebedinsky/chatter/internal/schedule"
+	"github.com/lennylebedinsky/chatter/internal/search"
 )
 
 // Gateway orchestrates HTTP and Websocket communications and data persistence.
@@ -16,33 +21,119 @@ type Gateway struct {
 	router             *mux.Router
 	repo               domain.Repository
 	messageStore       message.Store
+	searchIndex        *search.Index
+	moderation         *moderation.Pipeline
+	blobStore          *blob.Store
+	scheduler          *schedule.Scheduler
 	broadcaster        *chat.Broadcaster
 	broadcasterStarted atomic.Bool
+	// Broadcaster and scheduler goroutines, which write to the storage while running.
+	running sync.WaitGroup
 
 	logger *log.Logger
 }
 
-func New(repo domain.Repository, messageStore message.Store, logger *log.Logger) *Gateway {
+// New creates gateway over the storage. Blob store and scheduler are optional,
+// without them file attachments and scheduled messages are not supported.
+func New(repo domain.Repository, messageStore message.Store, blobStore *blob.Store, scheduler *schedule.Scheduler, logger *log.Logger) *Gateway {
+	searchIndex := search.NewIndex()
+	moderation := moderation.NewPipeline(moderation.DefaultFilters()...)
 	g := &Gateway{
 		router:       mux.NewRouter(),
 		repo:         repo,
 		messageStore: messageStore,
-		broadcaster:  chat.NewBroadcaster(repo, messageStore, logger),
+		searchIndex:  searchIndex,
+		moderation:   moderation,
+		blobStore:    blobStore,
+		scheduler:    scheduler,
+		broadcaster:  chat.NewBroadcaster(repo, messageStore, searchIndex, blobStore, moderation, logger),
 		logger:       logger,
 	}
 
 	g.broadcasterStarted.Store(false)
 
+	// Messages evicted from bounded history are not searched anymore, so that index stays bounded too.
+	if store, ok := messageStore.(message.RetentionStore); ok {
+		store.OnEvict(func(roomID string, evicted []*message.Message) {
+			for _, msg := range evicted {
+				searchIndex.Remove(msg.ID)
+			}
+		})
+	}
+
 	g.registerRoutes()
 
 	return g
 }
 
+// StartBroadcaster starts broadcaster along with scheduler, which passes due messages to it.
+// They run until the context is canceled, see Wait.
 func (g *Gateway) StartBroadcaster(ctx context.Context) {
 	// Just one broadcaster goroutine should run for the gateway.
 	if g.broadcasterStarted.CompareAndSwap(false, true) {
-		go g.broadcaster.Start(ctx)
+		g.running.Add(1)
+		go func() {
+			defer g.running.Done()
+			g.broadcaster.Start(ctx)
+		}()
+		if g.scheduler != nil {
+			g.running.Add(1)
+			go func() {
+				defer g.running.Done()
+				g.scheduler.Run(ctx, g.broadcaster.Submit)
+			}()
+		}
+	}
+}
+
+// Wait blocks until broadcaster and scheduler return once context they were started with is canceled,
+// so that the storage can be closed safely.
+func (g *Gateway) Wait() {
+	g.running.Wait()
+}
+
+// SetSeenBy enables or disables "seen by" updates broadcasted when users read messages.
+// It is supposed to be called before broadcaster is started.
+func (g *Gateway) SetSeenBy(enabled bool) {
+	g.broadcaster.SetSeenBy(enabled)
+}
+
+// SetRateLimits sets limits of messages posted by users.
+// It is supposed to be called before broadcaster is started.
+func (g *Gateway) SetRateLimits(limits chat.RateLimits) {
+	g.broadcaster.SetRateLimits(limits)
+}
+
+// LoadHistory makes messages already kept in the store searchable
+// and schedules removal of ephemeral ones, so that they expire even across restarts.
+// It is supposed to be called once before broadcaster is started.
+func (g *Gateway) LoadHistory(ctx context.Context) error {
+	rooms, err := g.repo.ListRooms(ctx)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, room := range rooms {
+		// Walk history back from the latest page.
+		req := message.PageRequest{Limit: maxPageLimit}
+		for {
+			page, err := g.messageStore.GetMessages(ctx, room.ID, req)
+			if err != nil {
+				return err
+			}
+			for _, msg := range page.Messages {
+				g.searchIndex.Add(msg)
+				g.broadcaster.TrackExpiry(msg)
+			}
+			count += len(page.Messages)
+			if page.Prev == "" {
+				break
+			}
+			req.Before = page.Prev
+		}
 	}
+	g.logger.Printf("Indexed %d messages for search.\n", count)
+	return nil
 }
 
 func (g *Gateway) Router() *mux.Router {
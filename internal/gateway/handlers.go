@@ -1,15 +1,43 @@
 package gateway
 
 import (
+	"context"
+	"errors"
+	"io"
+	"mime"
 	"net/http"
 	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/lennylebedinsky/chatter/internal/blob"
 	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/export"
 	"github.com/lennylebedinsky/chatter/internal/message"
+	"github.com/lennylebedinsky/chatter/internal/moderation"
+	"github.com/lennylebedinsky/chatter/internal/schedule"
+	"github.com/lennylebedinsky/chatter/internal/search"
 )
 
+// lookupUser resolves user by ID, falling back to the name
+// so that clients addressing users by name keep working.
+func (g *Gateway) lookupUser(ctx context.Context, key string) *domain.User {
+	if user := g.repo.GetUser(ctx, key); user != nil {
+		return user
+	}
+	return g.repo.FindUser(ctx, strings.ToLower(key))
+}
+
+// lookupRoom resolves room by ID, falling back to the name.
+func (g *Gateway) lookupRoom(ctx context.Context, key string) *domain.Room {
+	if room := g.repo.GetRoom(ctx, key); room != nil {
+		return room
+	}
+	return g.repo.FindRoom(ctx, strings.ToLower(key))
+}
+
 func (g *Gateway) handleListRooms(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	if r.Method == http.MethodOptions {
@@ -35,26 +63,44 @@ func (g *Gateway) handleListRoomsWithUser(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	userName := strings.ToLower(mux.Vars(r)["username"])
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
 	roomsParticipation, err := g.repo.ListParticipantsForAllRooms(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	var readCursors map[string]uint64
+	if user != nil {
+		if readCursors, err = g.repo.ReadCursors(r.Context(), user.ID); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Unread and mention counts are only set for rooms where user participates.
 	type roomWithUser struct {
 		Room              *domain.Room
 		UserIsParticipant bool
+		UnreadCount       int
+		MentionCount      int
 	}
 
 	response := make([]*roomWithUser, len(roomsParticipation))
 	for i, roomParticipation := range roomsParticipation {
 		response[i] = &roomWithUser{
 			Room: roomParticipation.Room,
-			UserIsParticipant: slices.IndexFunc(roomParticipation.Participants, func(u *domain.User) bool {
-				return u.Name == userName
+			UserIsParticipant: user != nil && slices.IndexFunc(roomParticipation.Participants, func(u *domain.User) bool {
+				return u.ID == user.ID
 			}) >= 0,
 		}
+		if response[i].UserIsParticipant {
+			read := readCursors[roomParticipation.Room.ID]
+			if response[i].UnreadCount, response[i].MentionCount, err = g.unreadCounts(r.Context(), roomParticipation.Room, user, read); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
 	}
 
 	err = encode(w, r, http.StatusOK, response)
@@ -64,40 +110,172 @@ func (g *Gateway) handleListRoomsWithUser(w http.ResponseWriter, r *http.Request
 	}
 }
 
+// unreadCounts returns number of messages in the room after the read one, and number of those mentioning the user.
+// Messages are counted by their sequence numbers rather than one by one;
+// mentions are counted within the latest page of them.
+func (g *Gateway) unreadCounts(ctx context.Context, room *domain.Room, user *domain.User, read uint64) (int, int, error) {
+	lastSeq, err := g.messageStore.LastSeq(ctx, room.ID)
+	if err != nil || lastSeq <= read {
+		return 0, 0, err
+	}
+	mentions, err := g.messageStore.GetMentions(ctx, room.ID, user.ID, message.PageRequest{Limit: maxPageLimit})
+	if err != nil {
+		return 0, 0, err
+	}
+	mentionCount := 0
+	for _, msg := range mentions.Messages {
+		if msg.Seq > read {
+			mentionCount++
+		}
+	}
+	return int(lastSeq - read), mentionCount, nil
+}
+
 func (g *Gateway) handleGetMessagesForRoom(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	roomName := strings.ToLower(mux.Vars(r)["roomname"])
-	messages, err := g.messageStore.GetMessages(r.Context(), roomName)
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	pageRequest, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := g.messageStore.GetMessages(r.Context(), room.ID, pageRequest)
+	if errors.Is(err, message.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = encode(w, r, http.StatusOK, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleGetThread returns page of replies to the message, paged the same way as room history.
+func (g *Gateway) handleGetThread(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	pageRequest, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	page, err := g.messageStore.GetThread(r.Context(), room.ID, mux.Vars(r)["message"], pageRequest)
+	if errors.Is(err, message.ErrMessageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, message.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	err = encode(w, r, http.StatusOK, messages)
+	err = encode(w, r, http.StatusOK, page)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 }
 
+// handleExport streams full history of the room to its participant, optionally limited to the period
+// between from and to, as a file in the requested format: jsonl (default), csv, text or html.
+func (g *Gateway) handleExport(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	room, user, ok := g.lookupParticipant(w, r)
+	if !ok {
+		return
+	}
+	params := r.URL.Query()
+	format, err := export.ParseFormat(params.Get("format"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var period export.Period
+	if from := params.Get("from"); from != "" {
+		if period.From, err = time.Parse(time.RFC3339, from); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if to := params.Get("to"); to != "" {
+		if period.To, err = time.Parse(time.RFC3339, to); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if !period.To.IsZero() && period.To.Before(period.From) {
+		http.Error(w, export.ErrInvalidPeriod.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{
+		"filename": room.Name + "." + format.Extension(),
+	}))
+	// Response is already under way, so failure can only cut the transcript short.
+	if err := export.Room(r.Context(), w, g.messageStore, room, format, period); err != nil {
+		g.logger.Printf("Export of room %s by %s failed: %v\n", room.Name, user.Name, err)
+		return
+	}
+	g.logger.Printf("Room %s exported by %s as %s.\n", room.Name, user.Name, format)
+}
+
 func (g *Gateway) handleJoinRoom(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Access-Control-Allow-Origin", "*")
 	if r.Method == http.MethodOptions {
 		return
 	}
 
-	roomName := strings.ToLower(mux.Vars(r)["roomname"])
-	userName := strings.ToLower(mux.Vars(r)["username"])
-	if err := g.repo.JoinRoom(r.Context(), userName, roomName); err != nil {
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err := g.repo.JoinRoom(r.Context(), user.ID, room.ID); err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	g.logger.Printf("User %s joined room %s.\n", userName, roomName)
+	g.logger.Printf("User %s joined room %s.\n", user.Name, room.Name)
+
+	// Let room participants know about newcomer.
+	g.broadcaster.Message() <- message.NewMembership(user.ID, user.Name, room.ID, room.Name, message.JoinRoomEvent)
 }
 
 func (g *Gateway) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
@@ -107,14 +285,1086 @@ func (g *Gateway) handleCreateRoom(w http.ResponseWriter, r *http.Request) {
 	}
 
 	roomName := strings.ToLower(mux.Vars(r)["roomname"])
-	userName := strings.ToLower(mux.Vars(r)["username"])
-	if _, err := g.repo.CreateRoom(r.Context(), roomName, userName); err != nil {
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	room, err := g.repo.CreateRoom(r.Context(), roomName, user.ID)
+	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	g.logger.Printf("User %s created and joined room %s.\n", userName, roomName)
+	g.logger.Printf("User %s created and joined room %s.\n", user.Name, room.Name)
 
 	// Notify other clients about room creation so that they could update room list.
-	g.broadcaster.Message() <- message.NewNotification(userName, roomName, message.CreateRoomEvent)
+	g.broadcaster.Message() <- message.NewNotification(user.ID, user.Name, room.ID, room.Name, message.CreateRoomEvent)
+}
+
+func (g *Gateway) handleRenameUser(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	oldName := user.Name
+	newName := strings.ToLower(mux.Vars(r)["newname"])
+	if err := g.repo.RenameUser(r.Context(), user.ID, newName); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	g.logger.Printf("User %s renamed to %s.\n", oldName, newName)
+
+	// Notify clients so that they could refresh displayed names.
+	g.broadcaster.Message() <- message.NewNotification(user.ID, newName, "", "", message.RenameUserEvent)
+}
+
+func (g *Gateway) handleRenameRoom(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	oldName := room.Name
+	newName := strings.ToLower(mux.Vars(r)["newname"])
+	if err := g.repo.RenameRoom(r.Context(), room.ID, newName); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	g.logger.Printf("Room %s renamed to %s.\n", oldName, newName)
+
+	// Notify clients so that they could update room list.
+	g.broadcaster.Message() <- message.NewNotification("", "", room.ID, newName, message.RenameRoomEvent)
+}
+
+type messageEdit struct {
+	Text string `json:"text"`
+}
+
+func (g *Gateway) handleEditMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	edit, err := decode[messageEdit](r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	g.changeMessage(w, r, &message.EditBody{Text: edit.Text})
+}
+
+func (g *Gateway) handleDeleteMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	g.changeMessage(w, r, &message.EditBody{Delete: true})
+}
+
+// changeMessage checks that the user may change the message and passes the change to broadcaster,
+// which applies it and lets room participants know, same as for changes coming over Websocket.
+func (g *Gateway) changeMessage(w http.ResponseWriter, r *http.Request, edit *message.EditBody) {
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	msg, err := g.messageStore.GetMessage(r.Context(), room.ID, mux.Vars(r)["message"])
+	if errors.Is(err, message.ErrMessageNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if edit.Delete {
+		err = msg.CanChange(user.ID)
+	} else {
+		err = msg.CanEdit(user.ID)
+	}
+	if errors.Is(err, message.ErrNotAuthor) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+
+	g.logger.Printf("User %s changes message %s in room %s.\n", user.Name, msg.ID, room.Name)
+
+	edit.MessageID = msg.ID
+	g.broadcaster.Message() <- &message.Message{
+		UserID: user.ID,
+		User:   user.Name,
+		RoomID: room.ID,
+		Room:   room.Name,
+		Body:   edit,
+	}
+}
+
+func (g *Gateway) handlePinMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	g.pinMessage(w, r, false)
+}
+
+func (g *Gateway) handleUnpinMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	g.pinMessage(w, r, true)
+}
+
+// pinMessage checks that the user moderates the room and passes pin request to broadcaster,
+// which applies it and lets room participants know, same as for requests coming over Websocket.
+func (g *Gateway) pinMessage(w http.ResponseWriter, r *http.Request, unpin bool) {
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if !g.repo.CanModerate(r.Context(), room.ID, user.ID) {
+		http.Error(w, "only room creator and moderators can pin messages", http.StatusForbidden)
+		return
+	}
+	pins, err := g.repo.ListPins(r.Context(), room.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	messageID := mux.Vars(r)["message"]
+	pinned := slices.ContainsFunc(pins, func(pin domain.Pin) bool { return pin.MessageID == messageID })
+	if unpin && !pinned {
+		http.Error(w, domain.ErrPinNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if !unpin {
+		if pinned {
+			http.Error(w, domain.ErrPinExists.Error(), http.StatusConflict)
+			return
+		}
+		msg, err := g.messageStore.GetMessage(r.Context(), room.ID, messageID)
+		if errors.Is(err, message.ErrMessageNotFound) {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if msg.IsDeleted() {
+			http.Error(w, message.ErrMessageDeleted.Error(), http.StatusConflict)
+			return
+		}
+	}
+
+	action := "pins"
+	if unpin {
+		action = "unpins"
+	}
+	g.logger.Printf("User %s %s message %s in room %s.\n", user.Name, action, messageID, room.Name)
+
+	g.broadcaster.Message() <- &message.Message{
+		UserID: user.ID,
+		User:   user.Name,
+		RoomID: room.ID,
+		Room:   room.Name,
+		Body:   &message.PinBody{MessageID: messageID, Unpin: unpin},
+	}
+}
+
+type pinnedMessage struct {
+	Message  *message.Message `json:"message"`
+	PinnedBy string           `json:"pinnedBy"`
+	PinnedAt time.Time        `json:"pinnedAt"`
+}
+
+// handleGetPins returns messages pinned in the room to its participant, the earliest pinned first.
+// Pinned messages which are no longer retained in history are skipped.
+func (g *Gateway) handleGetPins(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	room, _, ok := g.lookupParticipant(w, r)
+	if !ok {
+		return
+	}
+	pins, err := g.repo.ListPins(r.Context(), room.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	pinned := []*pinnedMessage{}
+	for _, pin := range pins {
+		msg, err := g.messageStore.GetMessage(r.Context(), room.ID, pin.MessageID)
+		if errors.Is(err, message.ErrMessageNotFound) {
+			continue
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		pinned = append(pinned, &pinnedMessage{Message: msg, PinnedBy: pin.PinnedBy, PinnedAt: pin.PinnedAt})
+	}
+
+	err = encode(w, r, http.StatusOK, pinned)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) handleAddModerator(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	g.changeModerator(w, r, g.repo.AddModerator)
+}
+
+func (g *Gateway) handleRemoveModerator(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	g.changeModerator(w, r, g.repo.RemoveModerator)
+}
+
+// changeModerator appoints or dismisses moderator of the room on behalf of its creator.
+func (g *Gateway) changeModerator(w http.ResponseWriter, r *http.Request, change func(ctx context.Context, roomID, userID string) error) {
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	moderator := g.lookupUser(r.Context(), mux.Vars(r)["moderator"])
+	if user == nil || moderator == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if room.Creator == nil || room.Creator.ID != user.ID {
+		http.Error(w, "only room creator can appoint moderators", http.StatusForbidden)
+		return
+	}
+	if err := change(r.Context(), room.ID, moderator.ID); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// lookupModerator resolves the room and the user, who should be its creator or moderator.
+func (g *Gateway) lookupModerator(w http.ResponseWriter, r *http.Request) (*domain.Room, *domain.User, bool) {
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return nil, nil, false
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return nil, nil, false
+	}
+	if !g.repo.CanModerate(r.Context(), room.ID, user.ID) {
+		http.Error(w, "only room creator and moderators can moderate the room", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return room, user, true
+}
+
+type moderationSettings struct {
+	Words        []string `json:"words"`
+	WordsOutcome string   `json:"wordsOutcome"`
+	BlockLinks   bool     `json:"blockLinks"`
+	SpamRepeats  int      `json:"spamRepeats"`
+	SpamWindow   string   `json:"spamWindow"`
+}
+
+// handleModeration reports (GET) or changes (POST) moderation rules of the room on behalf of its moderator.
+func (g *Gateway) handleModeration(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	room, user, ok := g.lookupModerator(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		settings, err := decode[moderationSettings](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		rules := moderation.Rules{
+			Words:        settings.Words,
+			WordsOutcome: moderation.Outcome(settings.WordsOutcome),
+			BlockLinks:   settings.BlockLinks,
+			SpamRepeats:  settings.SpamRepeats,
+		}
+		if settings.SpamWindow != "" {
+			if rules.SpamWindow, err = time.ParseDuration(settings.SpamWindow); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := g.moderation.SetRules(room.ID, rules); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		g.logger.Printf("User %s changed moderation rules of room %s.\n", user.Name, room.Name)
+	}
+
+	rules := g.moderation.Rules(room.ID)
+	response := &moderationSettings{
+		Words:        rules.Words,
+		WordsOutcome: string(rules.WordsOutcome),
+		BlockLinks:   rules.BlockLinks,
+		SpamRepeats:  rules.SpamRepeats,
+	}
+	if response.Words == nil {
+		response.Words = []string{}
+	}
+	if rules.SpamWindow > 0 {
+		response.SpamWindow = rules.SpamWindow.String()
+	}
+	err := encode(w, r, http.StatusOK, response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type heldMessage struct {
+	ID      string           `json:"id"`
+	Message *message.Message `json:"message"`
+	Reason  string           `json:"reason"`
+	HeldAt  time.Time        `json:"heldAt"`
+}
+
+// handleListHeld returns messages of the room held for review by moderation, the earliest first.
+func (g *Gateway) handleListHeld(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	room, _, ok := g.lookupModerator(w, r)
+	if !ok {
+		return
+	}
+
+	held := []*heldMessage{}
+	for _, h := range g.moderation.Held(room.ID) {
+		held = append(held, &heldMessage{ID: h.ID, Message: h.Message, Reason: h.Reason, HeldAt: h.HeldAt})
+	}
+	err := encode(w, r, http.StatusOK, held)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (g *Gateway) handleReleaseMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	g.reviewMessage(w, r, false)
+}
+
+func (g *Gateway) handleDiscardMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	g.reviewMessage(w, r, true)
+}
+
+// reviewMessage checks that the message is held for review and passes review to broadcaster,
+// which lets the message into the room or tells its author it was discarded,
+// same as for reviews coming over Websocket.
+func (g *Gateway) reviewMessage(w http.ResponseWriter, r *http.Request, discard bool) {
+	room, user, ok := g.lookupModerator(w, r)
+	if !ok {
+		return
+	}
+	heldID := mux.Vars(r)["message"]
+	if !slices.ContainsFunc(g.moderation.Held(room.ID), func(h *moderation.Held) bool { return h.ID == heldID }) {
+		http.Error(w, moderation.ErrNotHeld.Error(), http.StatusNotFound)
+		return
+	}
+
+	action := "releases"
+	if discard {
+		action = "discards"
+	}
+	g.logger.Printf("User %s %s held message %s in room %s.\n", user.Name, action, heldID, room.Name)
+
+	g.broadcaster.Message() <- &message.Message{
+		UserID: user.ID,
+		User:   user.Name,
+		RoomID: room.ID,
+		Room:   room.Name,
+		Body:   &message.ReviewBody{MessageID: heldID, Discard: discard},
+	}
+}
+
+type retentionSettings struct {
+	MaxCount int    `json:"maxCount"`
+	MaxAge   string `json:"maxAge"`
+	MaxBytes int    `json:"maxBytes"`
+}
+
+type retentionResponse struct {
+	Policy          retentionSettings `json:"policy"`
+	EvictedMessages int64             `json:"evictedMessages"`
+	EvictedBytes    int64             `json:"evictedBytes"`
+}
+
+// handleRetention reports (GET) or changes (POST) retention policy of the room on behalf of its moderator,
+// if message store supports retention.
+func (g *Gateway) handleRetention(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	store, ok := g.messageStore.(message.RetentionStore)
+	if !ok {
+		http.Error(w, "message store does not support retention", http.StatusNotImplemented)
+		return
+	}
+	room, user, ok := g.lookupModerator(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		settings, err := decode[retentionSettings](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		policy := message.RetentionPolicy{
+			MaxCount: settings.MaxCount,
+			MaxBytes: settings.MaxBytes,
+		}
+		if settings.MaxAge != "" {
+			if policy.MaxAge, err = time.ParseDuration(settings.MaxAge); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		store.SetRetention(room.ID, policy)
+		g.logger.Printf("User %s set retention of room %s to %+v.\n", user.Name, room.Name, policy)
+	}
+
+	policy, evicted := store.Retention(room.ID)
+	response := &retentionResponse{
+		Policy: retentionSettings{
+			MaxCount: policy.MaxCount,
+			MaxBytes: policy.MaxBytes,
+		},
+		EvictedMessages: evicted.Messages,
+		EvictedBytes:    evicted.Bytes,
+	}
+	if policy.MaxAge > 0 {
+		response.Policy.MaxAge = policy.MaxAge.String()
+	}
+
+	err := encode(w, r, http.StatusOK, response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type messageTTLSettings struct {
+	TTL string `json:"ttl"`
+}
+
+// handleMessageTTL reports (GET) or changes (POST) TTL of messages in the room on behalf of its moderator,
+// empty or zero TTL means messages do not expire unless their authors ask for it.
+// New TTL applies to messages sent afterwards.
+func (g *Gateway) handleMessageTTL(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	room, user, ok := g.lookupModerator(w, r)
+	if !ok {
+		return
+	}
+
+	ttl := room.MessageTTL
+	if r.Method == http.MethodPost {
+		settings, err := decode[messageTTLSettings](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ttl = 0
+		if settings.TTL != "" {
+			if ttl, err = time.ParseDuration(settings.TTL); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := g.repo.SetMessageTTL(r.Context(), room.ID, ttl); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, domain.ErrInvalidTTL) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		g.logger.Printf("User %s set message TTL of room %s to %v.\n", user.Name, room.Name, ttl)
+	}
+
+	response := &messageTTLSettings{}
+	if ttl > 0 {
+		response.TTL = ttl.String()
+	}
+	err := encode(w, r, http.StatusOK, response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+type slowModeSettings struct {
+	Interval string `json:"interval"`
+}
+
+// handleSlowMode reports (GET) or changes (POST) slow mode of the room on behalf of its moderator.
+// Empty or zero interval turns slow mode off.
+func (g *Gateway) handleSlowMode(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	room, user, ok := g.lookupModerator(w, r)
+	if !ok {
+		return
+	}
+
+	interval := room.SlowMode
+	if r.Method == http.MethodPost {
+		settings, err := decode[slowModeSettings](r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		interval = 0
+		if settings.Interval != "" {
+			if interval, err = time.ParseDuration(settings.Interval); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		if err := g.repo.SetSlowMode(r.Context(), room.ID, interval); err != nil {
+			status := http.StatusInternalServerError
+			if errors.Is(err, domain.ErrInvalidSlowMode) {
+				status = http.StatusBadRequest
+			}
+			http.Error(w, err.Error(), status)
+			return
+		}
+		g.logger.Printf("User %s set slow mode of room %s to %v.\n", user.Name, room.Name, interval)
+	}
+
+	response := &slowModeSettings{}
+	if interval > 0 {
+		response.Interval = interval.String()
+	}
+	err := encode(w, r, http.StatusOK, response)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleSearch looks for messages by text in the rooms where the user participates.
+// Optional filters are room, author and time range (from, to in RFC 3339).
+func (g *Gateway) handleSearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	params := r.URL.Query()
+	query := search.Query{
+		Text:  params.Get("q"),
+		Limit: defaultPageLimit,
+	}
+	if limit := params.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit should be a positive number", http.StatusBadRequest)
+			return
+		}
+		query.Limit = min(n, maxPageLimit)
+	}
+	if roomKey := params.Get("room"); roomKey != "" {
+		room := g.lookupRoom(r.Context(), roomKey)
+		if room == nil {
+			http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		query.RoomID = room.ID
+	}
+	if authorKey := params.Get("author"); authorKey != "" {
+		author := g.lookupUser(r.Context(), authorKey)
+		if author == nil {
+			http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		query.UserID = author.ID
+	}
+	var err error
+	if from := params.Get("from"); from != "" {
+		if query.From, err = time.Parse(time.RFC3339, from); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	if to := params.Get("to"); to != "" {
+		if query.To, err = time.Parse(time.RFC3339, to); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// Search is restricted to the rooms user participates in.
+	roomsParticipation, err := g.repo.ListParticipantsForAllRooms(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, roomParticipation := range roomsParticipation {
+		if slices.ContainsFunc(roomParticipation.Participants, func(u *domain.User) bool { return u.ID == user.ID }) {
+			query.AllowedRooms = append(query.AllowedRooms, roomParticipation.Room.ID)
+		}
+	}
+
+	err = encode(w, r, http.StatusOK, g.searchIndex.Search(query))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// handleUpload stores file sent as "file" field of multipart form, so that it can be attached to messages in the room.
+// Only room participants can upload files.
+func (g *Gateway) handleUpload(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if g.blobStore == nil {
+		http.Error(w, "file uploads are not supported", http.StatusNotImplemented)
+		return
+	}
+	room, user, ok := g.lookupParticipant(w, r)
+	if !ok {
+		return
+	}
+
+	// Form is streamed to the store rather than buffered.
+	reader, err := r.MultipartReader()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			http.Error(w, `form does not contain "file" field`, http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if part.FormName() != "file" {
+			continue
+		}
+
+		stored, err := g.blobStore.Put(room.ID, part)
+		switch {
+		case errors.Is(err, blob.ErrTooLarge):
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		case errors.Is(err, blob.ErrTypeNotAllowed):
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		g.logger.Printf("User %s uploaded file %s to room %s.\n", user.Name, stored.ID, room.Name)
+
+		err = encode(w, r, http.StatusCreated, stored)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+}
+
+func (g *Gateway) handleDownload(w http.ResponseWriter, r *http.Request) {
+	g.serveBlob(w, r, false)
+}
+
+func (g *Gateway) handleDownloadThumbnail(w http.ResponseWriter, r *http.Request) {
+	g.serveBlob(w, r, true)
+}
+
+// serveBlob sends file, or its thumbnail, to participants of the room it was uploaded to.
+func (g *Gateway) serveBlob(w http.ResponseWriter, r *http.Request, thumbnail bool) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if g.blobStore == nil {
+		http.Error(w, "file uploads are not supported", http.StatusNotImplemented)
+		return
+	}
+	room, _, ok := g.lookupParticipant(w, r)
+	if !ok {
+		return
+	}
+	f, stored, err := g.blobStore.Open(mux.Vars(r)["blob"], thumbnail)
+	// Files of other rooms are indistinguishable from missing ones.
+	if errors.Is(err, blob.ErrNotFound) || err == nil && !stored.InRoom(room.ID) {
+		if f != nil {
+			f.Close()
+		}
+		http.Error(w, blob.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	contentType := stored.MIMEType
+	if thumbnail {
+		contentType = "image/png"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	// Only images are displayed inline, other files are downloaded.
+	if !strings.HasPrefix(contentType, "image/") {
+		w.Header().Set("Content-Disposition", "attachment")
+	}
+	// Content never changes for the same ID.
+	w.Header().Set("Cache-Control", "private, max-age=31536000, immutable")
+	http.ServeContent(w, r, "", time.Time{}, f)
+}
+
+// lookupParticipant resolves room and user from the path and checks that the user participates in the room,
+// responding with an error otherwise.
+func (g *Gateway) lookupParticipant(w http.ResponseWriter, r *http.Request) (*domain.Room, *domain.User, bool) {
+	room := g.lookupRoom(r.Context(), mux.Vars(r)["room"])
+	if room == nil {
+		http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+		return nil, nil, false
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return nil, nil, false
+	}
+	participants, err := g.repo.ListParticipants(r.Context(), room.ID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, nil, false
+	}
+	if !slices.ContainsFunc(participants, func(u *domain.User) bool { return u.ID == user.ID }) {
+		http.Error(w, "user does not participate in the room", http.StatusForbidden)
+		return nil, nil, false
+	}
+	return room, user, true
+}
+
+// handleGetMentions returns messages mentioning the user in the rooms where the user participates.
+// Mentions in one room, given by optional room parameter, are paged the same way as room history;
+// otherwise the latest mentions from all rooms are returned, without cursors.
+func (g *Gateway) handleGetMentions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	pageRequest, err := parsePageRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	roomsParticipation, err := g.repo.ListParticipantsForAllRooms(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	rooms := []*domain.Room{}
+	for _, roomParticipation := range roomsParticipation {
+		if slices.ContainsFunc(roomParticipation.Participants, func(u *domain.User) bool { return u.ID == user.ID }) {
+			rooms = append(rooms, roomParticipation.Room)
+		}
+	}
+
+	var page *message.Page
+	if roomKey := r.URL.Query().Get("room"); roomKey != "" {
+		room := g.lookupRoom(r.Context(), roomKey)
+		if room == nil {
+			http.Error(w, domain.ErrRoomNotFound.Error(), http.StatusNotFound)
+			return
+		}
+		if !slices.ContainsFunc(rooms, func(r *domain.Room) bool { return r.ID == room.ID }) {
+			http.Error(w, "user does not participate in the room", http.StatusForbidden)
+			return
+		}
+		page, err = g.messageStore.GetMentions(r.Context(), room.ID, user.ID, pageRequest)
+	} else {
+		if pageRequest.Before != "" || pageRequest.After != "" {
+			http.Error(w, "cursors are only valid for mentions in the room", http.StatusBadRequest)
+			return
+		}
+		page, err = g.latestMentions(r.Context(), rooms, user, pageRequest.Limit)
+	}
+	if errors.Is(err, message.ErrInvalidCursor) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	err = encode(w, r, http.StatusOK, page)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+}
+
+// latestMentions merges the latest mentions of the user from the rooms in chronological order.
+func (g *Gateway) latestMentions(ctx context.Context, rooms []*domain.Room, user *domain.User, limit int) (*message.Page, error) {
+	messages := []*message.Message{}
+	for _, room := range rooms {
+		page, err := g.messageStore.GetMentions(ctx, room.ID, user.ID, message.PageRequest{Limit: limit})
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, page.Messages...)
+	}
+	slices.SortStableFunc(messages, func(a, b *message.Message) int {
+		return a.ServerTime.Compare(b.ServerTime)
+	})
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+	return &message.Page{Messages: messages}, nil
+}
+
+type scheduledMessageRequest struct {
+	Text   string    `json:"text"`
+	SendAt time.Time `json:"sendAt"`
+}
+
+// handleScheduleMessage schedules text message of the room participant to be sent at the given time.
+func (g *Gateway) handleScheduleMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if g.scheduler == nil {
+		http.Error(w, "scheduled messages are not supported", http.StatusNotImplemented)
+		return
+	}
+	room, user, ok := g.lookupParticipant(w, r)
+	if !ok {
+		return
+	}
+	req, err := decode[scheduledMessageRequest](r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scheduled, err := g.scheduler.Schedule(user.ID, room.ID, req.Text, req.SendAt)
+	if errors.Is(err, schedule.ErrEmptyText) || errors.Is(err, schedule.ErrPastTime) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	g.logger.Printf("User %s scheduled message %s to room %s at %v.\n", user.Name, scheduled.ID, room.Name, scheduled.SendAt)
+
+	err = encode(w, r, http.StatusCreated, scheduled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleListScheduledMessages returns messages scheduled by the user, including those which could not be sent.
+func (g *Gateway) handleListScheduledMessages(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if g.scheduler == nil {
+		http.Error(w, "scheduled messages are not supported", http.StatusNotImplemented)
+		return
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	err := encode(w, r, http.StatusOK, g.scheduler.List(user.ID))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleEditScheduledMessage changes text or time of the message scheduled by the user;
+// fields omitted in the request are left as is.
+func (g *Gateway) handleEditScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if g.scheduler == nil {
+		http.Error(w, "scheduled messages are not supported", http.StatusNotImplemented)
+		return
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	req, err := decode[scheduledMessageRequest](r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	scheduled, err := g.scheduler.Edit(user.ID, mux.Vars(r)["scheduled"], req.Text, req.SendAt)
+	if errors.Is(err, schedule.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, schedule.ErrPastTime) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	g.logger.Printf("User %s changed scheduled message %s.\n", user.Name, scheduled.ID)
+
+	err = encode(w, r, http.StatusOK, scheduled)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// handleCancelScheduledMessage removes the message scheduled by the user.
+func (g *Gateway) handleCancelScheduledMessage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	if r.Method == http.MethodOptions {
+		return
+	}
+
+	if g.scheduler == nil {
+		http.Error(w, "scheduled messages are not supported", http.StatusNotImplemented)
+		return
+	}
+	user := g.lookupUser(r.Context(), mux.Vars(r)["user"])
+	if user == nil {
+		http.Error(w, domain.ErrUserNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	id := mux.Vars(r)["scheduled"]
+	err := g.scheduler.Cancel(user.ID, id)
+	if errors.Is(err, schedule.ErrNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	g.logger.Printf("User %s canceled scheduled message %s.\n", user.Name, id)
 }
@@ -0,0 +1,126 @@
+package gateway
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+// testGateway serves a room created by alice, which bob joined, with a message of alice mentioning bob.
+// Carol does not participate in the room.
+type testGateway struct {
+	*Gateway
+	room *domain.Room
+}
+
+func newTestGateway(t *testing.T) *testGateway {
+	t.Helper()
+	ctx := context.Background()
+	repo := domain.NewInMemoryRepository()
+	alice, _ := repo.CreateUser(ctx, "alice")
+	bob, _ := repo.CreateUser(ctx, "bob")
+	if _, err := repo.CreateUser(ctx, "carol"); err != nil {
+		t.Fatalf("Repository.CreateUser() error = %v", err)
+	}
+	room, err := repo.CreateRoom(ctx, "lobby", alice.ID)
+	if err != nil {
+		t.Fatalf("Repository.CreateRoom() error = %v", err)
+	}
+	if err := repo.JoinRoom(ctx, bob.ID, room.ID); err != nil {
+		t.Fatalf("Repository.JoinRoom() error = %v", err)
+	}
+
+	store := message.NewInMemoryStore(message.RetentionPolicy{}, nil, testLogger)
+	msg := &message.Message{
+		ID: domain.NewID(), Seq: 1, UserID: alice.ID, User: alice.Name, RoomID: room.ID, Room: room.Name,
+		ServerTime: time.Now(), Body: &message.TextBody{Text: "@bob the meeting is at noon"},
+		Mentions: &message.Mentions{UserIDs: []string{bob.ID}},
+	}
+	if err := store.SaveMessage(ctx, room.ID, msg); err != nil {
+		t.Fatalf("Store.SaveMessage() error = %v", err)
+	}
+
+	g := New(repo, store, nil, nil, testLogger)
+	if err := g.LoadHistory(ctx); err != nil {
+		t.Fatalf("Gateway.LoadHistory() error = %v", err)
+	}
+	return &testGateway{Gateway: g, room: room}
+}
+
+// get serves GET request and decodes JSON response into v if it succeeds.
+func (g *testGateway) get(t *testing.T, path string, v any) int {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	g.Router().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(v); err != nil {
+			t.Fatalf("GET %s response cannot be decoded: %v", path, err)
+		}
+	}
+	return rec.Code
+}
+
+func TestGateway_handleSearch(t *testing.T) {
+	tests := []struct {
+		name      string
+		user      string
+		wantCount int
+	}{
+		{name: "Author should find own message", user: "alice", wantCount: 1},
+		{name: "Participant should find message in the room", user: "bob", wantCount: 1},
+		{name: "User who does not participate should not find message in the room", user: "carol", wantCount: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t)
+			var results []map[string]any
+			if code := g.get(t, "/search/"+tt.user+"?q=meeting", &results); code != http.StatusOK {
+				t.Fatalf("GET /search status = %d, want %d", code, http.StatusOK)
+			}
+			if len(results) != tt.wantCount {
+				t.Errorf("GET /search found %d messages, want %d", len(results), tt.wantCount)
+			}
+		})
+	}
+}
+
+func TestGateway_handleGetMentions(t *testing.T) {
+	tests := []struct {
+		name       string
+		user       string
+		room       bool
+		wantStatus int
+		wantCount  int
+	}{
+		{name: "Mentions from all rooms should be found", user: "bob", wantStatus: http.StatusOK, wantCount: 1},
+		{name: "Mentions in the room should be found", user: "bob", room: true, wantStatus: http.StatusOK, wantCount: 1},
+		{name: "User who is not mentioned should get none", user: "alice", room: true, wantStatus: http.StatusOK, wantCount: 0},
+		{name: "User who does not participate should not get mentions in the room", user: "carol", room: true, wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGateway(t)
+			path := "/mentions/" + tt.user
+			if tt.room {
+				path += "?room=" + g.room.ID
+			}
+			var page message.Page
+			if code := g.get(t, path, &page); code != tt.wantStatus {
+				t.Fatalf("GET %s status = %d, want %d", path, code, tt.wantStatus)
+			}
+			if len(page.Messages) != tt.wantCount {
+				t.Errorf("GET %s found %d mentions, want %d", path, len(page.Messages), tt.wantCount)
+			}
+		})
+	}
+}
@@ -2,8 +2,17 @@ package gateway
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"strconv"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
 )
 
 func encode[T any](w http.ResponseWriter, r *http.Request, status int, v T) error {
@@ -22,3 +31,21 @@ func decode[T any](r *http.Request) (T, error) {
 	}
 	return v, nil
 }
+
+// parsePageRequest reads history page parameters from the query: limit, before and after.
+func parsePageRequest(r *http.Request) (message.PageRequest, error) {
+	query := r.URL.Query()
+	req := message.PageRequest{
+		Limit:  defaultPageLimit,
+		Before: query.Get("before"),
+		After:  query.Get("after"),
+	}
+	if limit := query.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			return req, errors.New("limit should be a positive number")
+		}
+		req.Limit = min(n, maxPageLimit)
+	}
+	return req, nil
+}
@@ -1,19 +1,50 @@
 package gateway
 
 import (
+	"expvar"
 	"net/http"
 
 	"github.com/gorilla/mux"
 )
 
+// registerRoutes sets up HTTP API.
+// Users and rooms in the paths can be addressed either by ID or by name.
 func (g *Gateway) registerRoutes() {
 	g.router.HandleFunc("/rooms", g.handleListRooms).Methods(http.MethodGet, http.MethodOptions)
-	g.router.HandleFunc("/rooms/{username}", g.handleListRoomsWithUser).Methods(http.MethodGet, http.MethodOptions)
-	g.router.HandleFunc("/room/{roomname}/messages", g.handleGetMessagesForRoom).Methods(http.MethodGet, http.MethodOptions)
-	g.router.HandleFunc("/join-room/{roomname}/{username}", g.handleJoinRoom).Methods(http.MethodPost, http.MethodOptions)
-	g.router.HandleFunc("/create-room/{roomname}/{username}", g.handleCreateRoom).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/rooms/{user}", g.handleListRoomsWithUser).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/messages", g.handleGetMessagesForRoom).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/thread/{message}", g.handleGetThread).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/export/{user}", g.handleExport).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/join-room/{room}/{user}", g.handleJoinRoom).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/create-room/{roomname}/{user}", g.handleCreateRoom).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/rename-user/{user}/{newname}", g.handleRenameUser).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/rename-room/{room}/{newname}", g.handleRenameRoom).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/edit-message/{room}/{message}/{user}", g.handleEditMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/delete-message/{room}/{message}/{user}", g.handleDeleteMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/upload/{user}", g.handleUpload).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/blob/{blob}/{user}", g.handleDownload).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/thumbnail/{blob}/{user}", g.handleDownloadThumbnail).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/pin-message/{room}/{message}/{user}", g.handlePinMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/unpin-message/{room}/{message}/{user}", g.handleUnpinMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/pins/{user}", g.handleGetPins).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/add-moderator/{room}/{moderator}/{user}", g.handleAddModerator).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/remove-moderator/{room}/{moderator}/{user}", g.handleRemoveModerator).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/moderation/{user}", g.handleModeration).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/held/{user}", g.handleListHeld).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/release-message/{room}/{message}/{user}", g.handleReleaseMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/discard-message/{room}/{message}/{user}", g.handleDiscardMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/retention/{user}", g.handleRetention).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/message-ttl/{user}", g.handleMessageTTL).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/room/{room}/slow-mode/{user}", g.handleSlowMode).Methods(http.MethodGet, http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/mentions/{user}", g.handleGetMentions).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/schedule-message/{room}/{user}", g.handleScheduleMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/scheduled-messages/{user}", g.handleListScheduledMessages).Methods(http.MethodGet, http.MethodOptions)
+	g.router.HandleFunc("/edit-scheduled-message/{scheduled}/{user}", g.handleEditScheduledMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/cancel-scheduled-message/{scheduled}/{user}", g.handleCancelScheduledMessage).Methods(http.MethodPost, http.MethodOptions)
+	g.router.HandleFunc("/search/{user}", g.handleSearch).Methods(http.MethodGet, http.MethodOptions)
 	g.router.HandleFunc("/ws/{username}", g.serveUserWs)
+	// Metrics, e.g. of rate limited messages, along with runtime ones.
+	g.router.Handle("/debug/vars", expvar.Handler()).Methods(http.MethodGet)
 	g.router.Use(g.loggingMiddleware)
 	g.router.Use(mux.CORSMethodMiddleware(g.router))
-	http.Handle("/", g.router)
 }
@@ -3,6 +3,7 @@ package gateway
 import (
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -13,6 +14,8 @@ import (
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
+	// Wire format is negotiated by Sec-WebSocket-Protocol header, JSON is used if client asks for none.
+	Subprotocols: chat.Subprotocols,
 	// NB: Just for example allowing local clients to reach server, should take precaution in real environment.
 	CheckOrigin: func(r *http.Request) bool {
 		return true
@@ -21,10 +24,16 @@ var upgrader = websocket.Upgrader{
 
 // serveUserWs initiates Websocket upgrade when user logs in to chat server.
 // Socket is registered with the broadcaster, and read and write loops are started.
+// Reconnecting client passes sequence numbers of the latest acknowledged messages per room
+// as resume=<room ID>:<seq>,... query parameter, and gets messages it missed before live ones.
 func (g *Gateway) serveUserWs(w http.ResponseWriter, r *http.Request) {
 	userName := strings.ToLower(mux.Vars(r)["username"])
+	resume, err := parseResume(r.URL.Query().Get("resume"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 	user := g.repo.FindUser(r.Context(), userName)
-	var err error
 	if user == nil {
 		user, err = g.repo.CreateUser(r.Context(), userName)
 		if err != nil {
@@ -46,9 +55,32 @@ func (g *Gateway) serveUserWs(w http.ResponseWriter, r *http.Request) {
 		g.logError(err)
 		return
 	}
-	userSocket := chat.NewUserSocket(user, conn, g.broadcaster, g.logger)
+	if conn.Subprotocol() != "" {
+		g.logger.Printf("User %s connected with %s subprotocol.\n", user.Name, conn.Subprotocol())
+	}
+	userSocket := chat.NewUserSocket(user, conn, g.broadcaster, resume, g.logger)
 	g.broadcaster.Register() <- userSocket
 
 	go userSocket.ReadLoop()
 	go userSocket.WriteLoop()
 }
+
+// parseResume parses latest acknowledged sequence numbers per room, e.g. "<room ID>:42,<room ID>:7".
+func parseResume(value string) (map[string]uint64, error) {
+	resume := map[string]uint64{}
+	if value == "" {
+		return resume, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		roomID, seq, ok := strings.Cut(pair, ":")
+		if !ok || roomID == "" {
+			return nil, fmt.Errorf("invalid resume position %q", pair)
+		}
+		n, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resume position %q", pair)
+		}
+		resume[roomID] = n
+	}
+	return resume, nil
+}
@@ -0,0 +1,514 @@
+package message
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	archiveSegmentExt = ".jsonl.gz"
+	// Journal of messages not written to segments yet.
+	archiveJournalName = "pending.jsonl"
+
+	defaultArchiveSegmentSize = 1000
+)
+
+// Archive is a cold tier for history evicted from the hot store.
+// Messages of each room are batched and written as gzip-compressed segments of JSON lines,
+// named after positions of the messages they hold, so the index is restored from file names.
+// Messages are buffered until a segment is full; the buffer is readable and is written on Close.
+// Buffered messages are journaled to disk before Append returns, so they survive crash and are buffered again on open.
+type Archive struct {
+	dir         string
+	segmentSize int
+
+	rooms map[string]*archiveRoom
+	mu    sync.Mutex
+}
+
+type archiveRoom struct {
+	dir      string
+	segments []*archiveSegment
+	// Messages not written to segments yet, starting at pendingFirst position.
+	pending      []*Message
+	pendingFirst int
+	lastSeq      uint64
+	lastSeqKnown bool
+	// Positions of archived messages by their IDs, loaded on the first lookup.
+	ids map[string]int
+}
+
+// journalEntry is a buffered message along with its position.
+// Positions let entries already written to segments, or journaled twice, be skipped on open.
+type journalEntry struct {
+	Position int      `json:"position"`
+	Message  *Message `json:"message"`
+}
+
+type archiveSegment struct {
+	path  string
+	first int
+	count int
+}
+
+// NewArchive opens (or initializes) archive in the directory.
+// Segment holds up to segmentSize messages; zero means default.
+func NewArchive(dir string, segmentSize int) (*Archive, error) {
+	if segmentSize <= 0 {
+		segmentSize = defaultArchiveSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create archive directory: %w", err)
+	}
+
+	a := &Archive{
+		dir:         dir,
+		segmentSize: segmentSize,
+		rooms:       make(map[string]*archiveRoom),
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read archive directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		room, err := openArchiveRoom(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		a.rooms[entry.Name()] = room
+	}
+	return a, nil
+}
+
+func openArchiveRoom(dir string) (*archiveRoom, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read archive room directory: %w", err)
+	}
+	room := &archiveRoom{dir: dir}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, archiveSegmentExt) {
+			continue
+		}
+		seg := &archiveSegment{path: filepath.Join(dir, name)}
+		if _, err := fmt.Sscanf(strings.TrimSuffix(name, archiveSegmentExt), "%d-%d", &seg.first, &seg.count); err != nil {
+			continue
+		}
+		room.segments = append(room.segments, seg)
+	}
+	sort.Slice(room.segments, func(i, j int) bool {
+		return room.segments[i].first < room.segments[j].first
+	})
+	if n := len(room.segments); n > 0 {
+		room.pendingFirst = room.segments[n-1].first + room.segments[n-1].count
+	}
+	if err := room.readJournal(); err != nil {
+		return nil, err
+	}
+	return room, nil
+}
+
+// Append adds messages evicted from the hot store; first is position of the first of them.
+// Messages must follow those already archived without gaps.
+// Once Append returns without error, messages are kept on disk. Archive is not changed if it fails.
+// Failing to write a full segment is not an error, since its messages are journaled;
+// it is written again on the next Append or on Close.
+func (a *Archive) Append(roomID string, first int, messages []*Message) error {
+	if len(messages) == 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, err := a.room(roomID)
+	if err != nil {
+		return err
+	}
+	if end := room.end(); len(room.segments) == 0 && len(room.pending) == 0 {
+		room.pendingFirst = first
+	} else if first != end {
+		return fmt.Errorf("archive of room %s ends at %d, cannot append at %d", roomID, end, first)
+	}
+
+	if err := room.journal(first, messages); err != nil {
+		return err
+	}
+	if room.ids != nil {
+		for i, msg := range messages {
+			if msg.ID != "" {
+				room.ids[msg.ID] = first + i
+			}
+		}
+	}
+	room.pending = append(room.pending, messages...)
+	room.lastSeq = messages[len(messages)-1].Seq
+	room.lastSeqKnown = true
+
+	flushed := false
+	for len(room.pending) >= a.segmentSize {
+		if err := room.flush(a.segmentSize); err != nil {
+			break
+		}
+		flushed = true
+	}
+	if flushed {
+		// Stale journal is not harmful, entries already in segments are skipped on open.
+		_ = room.rewriteJournal()
+	}
+	return nil
+}
+
+// Bounds returns [first, end) range of positions held by the archive for the room.
+func (a *Archive) Bounds(roomID string) (int, int) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, ok := a.rooms[roomID]
+	if !ok {
+		return 0, 0
+	}
+	return room.first(), room.end()
+}
+
+// LastSeq returns sequence number of the latest archived message of the room, zero if there are none.
+func (a *Archive) LastSeq(roomID string) (uint64, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, ok := a.rooms[roomID]
+	if !ok {
+		return 0, nil
+	}
+	if !room.lastSeqKnown && len(room.segments) > 0 {
+		messages, err := room.segments[len(room.segments)-1].read()
+		if err != nil {
+			return 0, err
+		}
+		if len(messages) > 0 {
+			room.lastSeq = messages[len(messages)-1].Seq
+		}
+		room.lastSeqKnown = true
+	}
+	return room.lastSeq, nil
+}
+
+// Read returns archived messages at [from, to) positions.
+func (a *Archive) Read(roomID string, from, to int) ([]*Message, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, ok := a.rooms[roomID]
+	if !ok {
+		return []*Message{}, nil
+	}
+	return room.read(from, to)
+}
+
+// Find returns archived message by its ID, ErrMessageNotFound if it is not archived.
+// IDs of archived messages of the room are read from its segments on the first lookup, and kept since then.
+func (a *Archive) Find(roomID, msgID string) (*Message, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	room, ok := a.rooms[roomID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	if room.ids == nil {
+		if err := room.loadIDs(); err != nil {
+			return nil, err
+		}
+	}
+	position, ok := room.ids[msgID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	messages, err := room.read(position, position+1)
+	if err != nil {
+		return nil, err
+	}
+	if len(messages) == 0 {
+		return nil, ErrMessageNotFound
+	}
+	return messages[0], nil
+}
+
+// read returns archived messages of the room at [from, to) positions.
+func (r *archiveRoom) read(from, to int) ([]*Message, error) {
+	messages := []*Message{}
+	for _, seg := range r.segments {
+		if seg.first+seg.count <= from || seg.first >= to {
+			continue
+		}
+		segMessages, err := seg.read()
+		if err != nil {
+			return nil, err
+		}
+		lo, hi := max(from, seg.first)-seg.first, min(to, seg.first+seg.count)-seg.first
+		messages = append(messages, segMessages[lo:min(hi, len(segMessages))]...)
+	}
+	if from < r.end() && to > r.pendingFirst {
+		lo, hi := max(from, r.pendingFirst)-r.pendingFirst, min(to, r.end())-r.pendingFirst
+		messages = append(messages, r.pending[lo:hi]...)
+	}
+	return messages, nil
+}
+
+// loadIDs indexes archived messages of the room by their IDs.
+func (r *archiveRoom) loadIDs() error {
+	ids := make(map[string]int)
+	for _, seg := range r.segments {
+		messages, err := seg.read()
+		if err != nil {
+			return err
+		}
+		for i, msg := range messages {
+			if msg.ID != "" {
+				ids[msg.ID] = seg.first + i
+			}
+		}
+	}
+	for i, msg := range r.pending {
+		if msg.ID != "" {
+			ids[msg.ID] = r.pendingFirst + i
+		}
+	}
+	r.ids = ids
+	return nil
+}
+
+// Close writes buffered messages of all rooms to segments.
+func (a *Archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, room := range a.rooms {
+		if len(room.pending) == 0 {
+			continue
+		}
+		for len(room.pending) > 0 {
+			if err := room.flush(a.segmentSize); err != nil {
+				return err
+			}
+		}
+		if err := room.rewriteJournal(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// room returns archive of the room, creating it if needed. Caller must hold the lock.
+func (a *Archive) room(roomID string) (*archiveRoom, error) {
+	// Room ID becomes directory name, so it must not escape the archive directory.
+	if roomID == "" || roomID != filepath.Base(roomID) || strings.HasPrefix(roomID, ".") {
+		return nil, fmt.Errorf("invalid room ID %q", roomID)
+	}
+	if room, ok := a.rooms[roomID]; ok {
+		return room, nil
+	}
+	dir := filepath.Join(a.dir, roomID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create archive room directory: %w", err)
+	}
+	room := &archiveRoom{dir: dir}
+	a.rooms[roomID] = room
+	return room, nil
+}
+
+func (r *archiveRoom) first() int {
+	if len(r.segments) > 0 {
+		return r.segments[0].first
+	}
+	return r.pendingFirst
+}
+
+func (r *archiveRoom) end() int {
+	return r.pendingFirst + len(r.pending)
+}
+
+// flush writes up to segmentSize pending messages as a new segment.
+func (r *archiveRoom) flush(segmentSize int) error {
+	batch := r.pending[:min(segmentSize, len(r.pending))]
+	seg := &archiveSegment{
+		path:  filepath.Join(r.dir, fmt.Sprintf("%020d-%d%s", r.pendingFirst, len(batch), archiveSegmentExt)),
+		first: r.pendingFirst,
+		count: len(batch),
+	}
+	if err := seg.write(batch); err != nil {
+		return err
+	}
+
+	r.segments = append(r.segments, seg)
+	r.pendingFirst += len(batch)
+	r.pending = r.pending[len(batch):]
+	return nil
+}
+
+// journal appends messages at first position to the journal and syncs it.
+// If it fails, journal is truncated back, so that it does not end with partially written entry.
+func (r *archiveRoom) journal(first int, messages []*Message) error {
+	f, err := os.OpenFile(filepath.Join(r.dir, archiveJournalName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("open archive journal: %w", err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("stat archive journal: %w", err)
+	}
+
+	if err := writeJournal(f, first, messages); err != nil {
+		f.Truncate(info.Size())
+		return err
+	}
+	return nil
+}
+
+// rewriteJournal replaces journal with pending messages, removing it if there are none.
+func (r *archiveRoom) rewriteJournal() error {
+	path := filepath.Join(r.dir, archiveJournalName)
+	if len(r.pending) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("remove archive journal: %w", err)
+		}
+		return nil
+	}
+
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create archive journal: %w", err)
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	if err := writeJournal(f, r.pendingFirst, r.pending); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("rename archive journal: %w", err)
+	}
+	return nil
+}
+
+// readJournal buffers messages journaled after those in segments.
+// Reading stops at partially written entry or at a gap, and journal is rewritten without them.
+func (r *archiveRoom) readJournal() error {
+	f, err := os.Open(filepath.Join(r.dir, archiveJournalName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open archive journal: %w", err)
+	}
+	defer f.Close()
+
+	decoder := json.NewDecoder(bufio.NewReader(f))
+	for decoder.More() {
+		var entry journalEntry
+		if err := decoder.Decode(&entry); err != nil || entry.Message == nil {
+			break
+		}
+		if len(r.segments) == 0 && len(r.pending) == 0 {
+			r.pendingFirst = entry.Position
+		}
+		if entry.Position < r.end() {
+			continue
+		}
+		if entry.Position > r.end() {
+			break
+		}
+		r.pending = append(r.pending, entry.Message)
+	}
+	if n := len(r.pending); n > 0 {
+		r.lastSeq = r.pending[n-1].Seq
+		r.lastSeqKnown = true
+	}
+	return r.rewriteJournal()
+}
+
+func writeJournal(f *os.File, first int, messages []*Message) error {
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for i, msg := range messages {
+		if err := encoder.Encode(journalEntry{Position: first + i, Message: msg}); err != nil {
+			return fmt.Errorf("encode journaled message: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return fmt.Errorf("write archive journal: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync archive journal: %w", err)
+	}
+	return nil
+}
+
+// write stores messages to temporary file renamed into place when complete,
+// so that segment is never observed partially written.
+func (s *archiveSegment) write(messages []*Message) error {
+	tmp := s.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("create archive segment: %w", err)
+	}
+	defer os.Remove(tmp)
+	defer f.Close()
+
+	zw := gzip.NewWriter(f)
+	encoder := json.NewEncoder(zw)
+	for _, msg := range messages {
+		if err := encoder.Encode(msg); err != nil {
+			return fmt.Errorf("encode archived message: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("compress archive segment: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync archive segment: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		return fmt.Errorf("rename archive segment: %w", err)
+	}
+	return nil
+}
+
+func (s *archiveSegment) read() ([]*Message, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, fmt.Errorf("open archive segment: %w", err)
+	}
+	defer f.Close()
+
+	zr, err := gzip.NewReader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("decompress archive segment: %w", err)
+	}
+	defer zr.Close()
+
+	messages := make([]*Message, 0, s.count)
+	decoder := json.NewDecoder(zr)
+	for decoder.More() {
+		msg := &Message{}
+		if err := decoder.Decode(msg); err != nil {
+			return nil, fmt.Errorf("decode archived message: %w", err)
+		}
+		messages = append(messages, msg)
+	}
+	return messages, nil
+}
@@ -0,0 +1,241 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func testMessages(from, to int) []*Message {
+	messages := []*Message{}
+	for i := from; i < to; i++ {
+		msg := *testMessage1
+		msg.Seq = uint64(i + 1)
+		messages = append(messages, &msg)
+	}
+	return messages
+}
+
+func TestArchive_Read(t *testing.T) {
+	type args struct {
+		from int
+		to   int
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []*Message
+		wantErr bool
+	}{
+		{
+			name:    "Reading whole archive should return messages from segments and buffer",
+			args:    args{from: 0, to: 10},
+			want:    testMessages(0, 10),
+			wantErr: false,
+		},
+		{
+			name:    "Reading range spanning segments and buffer should succeed",
+			args:    args{from: 3, to: 9},
+			want:    testMessages(3, 9),
+			wantErr: false,
+		},
+		{
+			name:    "Reading range beyond archive should return only archived messages",
+			args:    args{from: 8, to: 20},
+			want:    testMessages(8, 10),
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Segments hold 4 messages, so there are two segments and 2 buffered messages.
+			a, err := NewArchive(t.TempDir(), 4)
+			if err != nil {
+				t.Fatalf("NewArchive() error = %v", err)
+			}
+			a.Append(testRoomID, 0, testMessages(0, 3))
+			a.Append(testRoomID, 3, testMessages(3, 10))
+
+			got, err := a.Read(testRoomID, tt.args.from, tt.args.to)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Archive.Read() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Archive.Read() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArchive_Append(t *testing.T) {
+	a, err := NewArchive(t.TempDir(), 4)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	if err := a.Append(testRoomID, 0, testMessages(0, 3)); err != nil {
+		t.Errorf("Archive.Append() error = %v", err)
+	}
+	if err := a.Append(testRoomID, 5, testMessages(5, 6)); err == nil {
+		t.Errorf("Archive.Append() leaving a gap should fail")
+	}
+}
+
+func TestInMemoryStore_ArchiveReadThrough(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	archive, err := NewArchive(dir, 4)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	s := NewInMemoryStore(RetentionPolicy{MaxCount: 3}, archive, testLogger)
+	for _, msg := range testMessages(0, 10) {
+		s.SaveMessage(ctx, testRoomID, msg)
+	}
+
+	page, err := s.GetMessages(ctx, testRoomID, PageRequest{})
+	if err != nil {
+		t.Fatalf("InMemoryStore.GetMessages() error = %v", err)
+	}
+	if !reflect.DeepEqual(page.Messages, testMessages(0, 10)) {
+		t.Errorf("InMemoryStore.GetMessages() = %v, want all messages including archived", page.Messages)
+	}
+	page, _ = s.GetMessages(ctx, testRoomID, PageRequest{Limit: 4, Before: "8"})
+	if !reflect.DeepEqual(page.Messages, testMessages(4, 8)) || page.Prev != "4" {
+		t.Errorf("InMemoryStore.GetMessages() page across tiers = %v (prev %q), want messages 4-7", page.Messages, page.Prev)
+	}
+
+	// After restart, history continues after archived messages.
+	if err := s.Close(); err != nil {
+		t.Fatalf("InMemoryStore.Close() error = %v", err)
+	}
+	archive, err = NewArchive(dir, 4)
+	if err != nil {
+		t.Fatalf("NewArchive() on restart error = %v", err)
+	}
+	s = NewInMemoryStore(RetentionPolicy{MaxCount: 3}, archive, testLogger)
+	if seq, err := s.LastSeq(ctx, testRoomID); err != nil || seq != 10 {
+		t.Errorf("InMemoryStore.LastSeq() after restart = %v, %v, want 10", seq, err)
+	}
+	s.SaveMessage(ctx, testRoomID, testMessages(10, 11)[0])
+	page, _ = s.GetMessages(ctx, testRoomID, PageRequest{})
+	if !reflect.DeepEqual(page.Messages, testMessages(0, 11)) {
+		t.Errorf("InMemoryStore.GetMessages() after restart = %v, want all messages", page.Messages)
+	}
+}
+
+func TestInMemoryStore_GetArchivedMessage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	messages := testMessages(0, 12)
+	for i, msg := range messages {
+		msg.ID = fmt.Sprintf("m%d", i)
+	}
+	archive, err := NewArchive(dir, 4)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	s := NewInMemoryStore(RetentionPolicy{MaxCount: 3}, archive, testLogger)
+	for _, msg := range messages[:10] {
+		s.SaveMessage(ctx, testRoomID, msg)
+	}
+
+	// Message 1 is in segment, 5 is buffered, 9 is retained.
+	for _, i := range []int{1, 5, 9} {
+		if got, err := s.GetMessage(ctx, testRoomID, messages[i].ID); err != nil || !reflect.DeepEqual(got, messages[i]) {
+			t.Errorf("InMemoryStore.GetMessage(%s) = %v, %v, want %v", messages[i].ID, got, err, messages[i])
+		}
+	}
+	if _, err := s.GetMessage(ctx, testRoomID, "m42"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("InMemoryStore.GetMessage() of unknown message error = %v, want %v", err, ErrMessageNotFound)
+	}
+
+	// Messages archived after the first lookup are found as well.
+	for _, msg := range messages[10:] {
+		s.SaveMessage(ctx, testRoomID, msg)
+	}
+	if got, err := s.GetMessage(ctx, testRoomID, "m8"); err != nil || !reflect.DeepEqual(got, messages[8]) {
+		t.Errorf("InMemoryStore.GetMessage(m8) = %v, %v, want %v", got, err, messages[8])
+	}
+
+	edited := *messages[1]
+	if err := s.ReplaceMessage(ctx, testRoomID, &edited); !errors.Is(err, ErrMessageArchived) {
+		t.Errorf("InMemoryStore.ReplaceMessage() of archived message error = %v, want %v", err, ErrMessageArchived)
+	}
+	if err := s.RemoveMessage(ctx, testRoomID, "m1"); !errors.Is(err, ErrMessageArchived) {
+		t.Errorf("InMemoryStore.RemoveMessage() of archived message error = %v, want %v", err, ErrMessageArchived)
+	}
+	if err := s.RemoveMessage(ctx, testRoomID, "m42"); !errors.Is(err, ErrMessageNotFound) {
+		t.Errorf("InMemoryStore.RemoveMessage() of unknown message error = %v, want %v", err, ErrMessageNotFound)
+	}
+}
+
+func TestArchive_JournalSurvivesCrash(t *testing.T) {
+	dir := t.TempDir()
+	a, err := NewArchive(dir, 4)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	if err := a.Append(testRoomID, 0, testMessages(0, 6)); err != nil {
+		t.Fatalf("Archive.Append() error = %v", err)
+	}
+
+	// Archive is opened again without being closed, so buffered messages are only in the journal.
+	a, err = NewArchive(dir, 4)
+	if err != nil {
+		t.Fatalf("NewArchive() on restart error = %v", err)
+	}
+	if first, end := a.Bounds(testRoomID); first != 0 || end != 6 {
+		t.Errorf("Archive.Bounds() = %d, %d, want 0, 6", first, end)
+	}
+	got, err := a.Read(testRoomID, 0, 6)
+	if err != nil || !reflect.DeepEqual(got, testMessages(0, 6)) {
+		t.Errorf("Archive.Read() = %v, %v, want all messages", got, err)
+	}
+	if seq, err := a.LastSeq(testRoomID); err != nil || seq != 6 {
+		t.Errorf("Archive.LastSeq() = %d, %v, want 6", seq, err)
+	}
+	if err := a.Append(testRoomID, 6, testMessages(6, 7)); err != nil {
+		t.Errorf("Archive.Append() after restart error = %v", err)
+	}
+}
+
+func TestInMemoryStore_ArchiveFailureKeepsMessages(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	archive, err := NewArchive(dir, 4)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	// File in place of the room directory makes archiving fail.
+	blocker := filepath.Join(dir, testRoomID)
+	if err := os.WriteFile(blocker, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s := NewInMemoryStore(RetentionPolicy{MaxCount: 3}, archive, testLogger)
+	for _, msg := range testMessages(0, 5) {
+		s.SaveMessage(ctx, testRoomID, msg)
+	}
+	if _, evicted := s.Retention(testRoomID); evicted.Messages != 0 {
+		t.Errorf("evicted messages = %d, want none while archive fails", evicted.Messages)
+	}
+
+	if err := os.Remove(blocker); err != nil {
+		t.Fatal(err)
+	}
+	s.SaveMessage(ctx, testRoomID, testMessages(5, 6)[0])
+	if _, evicted := s.Retention(testRoomID); evicted.Messages != 3 {
+		t.Errorf("evicted messages = %d, want 3 once archive works", evicted.Messages)
+	}
+	page, err := s.GetMessages(ctx, testRoomID, PageRequest{})
+	if err != nil || !reflect.DeepEqual(page.Messages, testMessages(0, 6)) {
+		t.Errorf("InMemoryStore.GetMessages() = %v, %v, want all messages", page.Messages, err)
+	}
+}
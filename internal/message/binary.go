@@ -0,0 +1,323 @@
+package message
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Binary format is a compact alternative to JSON for clients on constrained links.
+// Encoded message starts with format version byte followed by fields, each prefixed with a tag:
+// field number and wire type packed into varint. Integers are varints, everything else,
+// including nested records, is length-prefixed bytes. Fields with zero values are omitted,
+// and fields unknown to the decoder are skipped, so that fields can be added compatibly.
+// Body is kept in its JSON form along with kind and version, so that it is decoded by the codec
+// of its kind either way.
+const binaryFormatVersion = 1
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// Field numbers of the message. Numbers must never be reused for other fields.
+const (
+	fieldID = iota + 1
+	fieldSeq
+	fieldType
+	fieldVersion
+	fieldUserID
+	fieldUser
+	fieldRoomID
+	fieldRoom
+	fieldServerTime
+	fieldBody
+	fieldParentID
+	fieldThread
+	fieldReaction
+	fieldAction
+	fieldEditedAt
+	fieldRevision
+	fieldDeletedAt
+	fieldMentions
+	fieldTTL
+	fieldExpiresAt
+	fieldClientID
+)
+
+// Field numbers of nested records.
+const (
+	fieldThreadReplyCount  = 1
+	fieldThreadLastReplyAt = 2
+
+	fieldReactionEmoji  = 1
+	fieldReactionUserID = 2
+
+	fieldRevisionText       = 1
+	fieldRevisionReplacedAt = 2
+
+	fieldMentionsUserID = 1
+	fieldMentionsHere   = 2
+	fieldMentionsRoom   = 3
+)
+
+var ErrInvalidBinary = errors.New("invalid binary message")
+
+func (m *Message) MarshalBinary() ([]byte, error) {
+	w := &binaryWriter{buf: []byte{binaryFormatVersion}}
+	w.string(fieldID, m.ID)
+	w.varint(fieldSeq, m.Seq)
+	if m.Body != nil {
+		kind, version, data, err := encodeBody(m.Body)
+		if err != nil {
+			return nil, err
+		}
+		w.string(fieldType, kind)
+		w.varint(fieldVersion, uint64(version))
+		w.bytes(fieldBody, data)
+	}
+	w.string(fieldUserID, m.UserID)
+	w.string(fieldUser, m.User)
+	w.string(fieldRoomID, m.RoomID)
+	w.string(fieldRoom, m.Room)
+	if !m.ServerTime.IsZero() {
+		w.time(fieldServerTime, m.ServerTime)
+	}
+	w.string(fieldParentID, m.ParentID)
+	if m.Thread != nil {
+		thread := &binaryWriter{}
+		thread.varint(fieldThreadReplyCount, uint64(m.Thread.ReplyCount))
+		thread.time(fieldThreadLastReplyAt, m.Thread.LastReplyAt)
+		w.bytes(fieldThread, thread.buf)
+	}
+	for emoji, userIDs := range m.Reactions {
+		reaction := &binaryWriter{}
+		reaction.string(fieldReactionEmoji, emoji)
+		for _, userID := range userIDs {
+			reaction.string(fieldReactionUserID, userID)
+		}
+		w.bytes(fieldReaction, reaction.buf)
+	}
+	w.string(fieldAction, m.Action)
+	if m.EditedAt != nil {
+		w.time(fieldEditedAt, *m.EditedAt)
+	}
+	for _, r := range m.Revisions {
+		revision := &binaryWriter{}
+		revision.string(fieldRevisionText, r.Text)
+		revision.time(fieldRevisionReplacedAt, r.ReplacedAt)
+		w.bytes(fieldRevision, revision.buf)
+	}
+	if m.DeletedAt != nil {
+		w.time(fieldDeletedAt, *m.DeletedAt)
+	}
+	if m.Mentions != nil {
+		mentions := &binaryWriter{}
+		for _, userID := range m.Mentions.UserIDs {
+			mentions.string(fieldMentionsUserID, userID)
+		}
+		mentions.bool(fieldMentionsHere, m.Mentions.Here)
+		mentions.bool(fieldMentionsRoom, m.Mentions.Room)
+		w.bytes(fieldMentions, mentions.buf)
+	}
+	w.varint(fieldTTL, uint64(m.TTL/time.Second))
+	if m.ExpiresAt != nil {
+		w.time(fieldExpiresAt, *m.ExpiresAt)
+	}
+	w.string(fieldClientID, m.ClientID)
+	return w.buf, nil
+}
+
+func (m *Message) UnmarshalBinary(data []byte) error {
+	if len(data) == 0 || data[0] == 0 {
+		return fmt.Errorf("%w: unknown format version", ErrInvalidBinary)
+	}
+
+	var (
+		msg         Message
+		kind        string
+		version     int
+		body        []byte
+		bodyPresent bool
+	)
+	err := readFields(data[1:], func(field int, value uint64, data []byte) error {
+		var err error
+		switch field {
+		case fieldID:
+			msg.ID = string(data)
+		case fieldSeq:
+			msg.Seq = value
+		case fieldType:
+			kind = string(data)
+		case fieldVersion:
+			version = int(value)
+		case fieldBody:
+			body, bodyPresent = data, true
+		case fieldUserID:
+			msg.UserID = string(data)
+		case fieldUser:
+			msg.User = string(data)
+		case fieldRoomID:
+			msg.RoomID = string(data)
+		case fieldRoom:
+			msg.Room = string(data)
+		case fieldServerTime:
+			err = msg.ServerTime.UnmarshalBinary(data)
+		case fieldParentID:
+			msg.ParentID = string(data)
+		case fieldThread:
+			msg.Thread = &Thread{}
+			err = readFields(data, func(field int, value uint64, data []byte) error {
+				switch field {
+				case fieldThreadReplyCount:
+					msg.Thread.ReplyCount = int(value)
+				case fieldThreadLastReplyAt:
+					return msg.Thread.LastReplyAt.UnmarshalBinary(data)
+				}
+				return nil
+			})
+		case fieldReaction:
+			var emoji string
+			var userIDs []string
+			err = readFields(data, func(field int, value uint64, data []byte) error {
+				switch field {
+				case fieldReactionEmoji:
+					emoji = string(data)
+				case fieldReactionUserID:
+					userIDs = append(userIDs, string(data))
+				}
+				return nil
+			})
+			if msg.Reactions == nil {
+				msg.Reactions = make(map[string][]string)
+			}
+			msg.Reactions[emoji] = userIDs
+		case fieldAction:
+			msg.Action = string(data)
+		case fieldEditedAt:
+			msg.EditedAt = &time.Time{}
+			err = msg.EditedAt.UnmarshalBinary(data)
+		case fieldRevision:
+			var revision Revision
+			err = readFields(data, func(field int, value uint64, data []byte) error {
+				switch field {
+				case fieldRevisionText:
+					revision.Text = string(data)
+				case fieldRevisionReplacedAt:
+					return revision.ReplacedAt.UnmarshalBinary(data)
+				}
+				return nil
+			})
+			msg.Revisions = append(msg.Revisions, revision)
+		case fieldDeletedAt:
+			msg.DeletedAt = &time.Time{}
+			err = msg.DeletedAt.UnmarshalBinary(data)
+		case fieldMentions:
+			msg.Mentions = &Mentions{}
+			err = readFields(data, func(field int, value uint64, data []byte) error {
+				switch field {
+				case fieldMentionsUserID:
+					msg.Mentions.UserIDs = append(msg.Mentions.UserIDs, string(data))
+				case fieldMentionsHere:
+					msg.Mentions.Here = value != 0
+				case fieldMentionsRoom:
+					msg.Mentions.Room = value != 0
+				}
+				return nil
+			})
+		case fieldTTL:
+			if value > uint64(maxTTLSeconds) {
+				return fmt.Errorf("%w: %w", ErrInvalidBinary, ErrInvalidTTL)
+			}
+			msg.TTL = time.Duration(value) * time.Second
+		case fieldExpiresAt:
+			msg.ExpiresAt = &time.Time{}
+			err = msg.ExpiresAt.UnmarshalBinary(data)
+		case fieldClientID:
+			msg.ClientID = string(data)
+		}
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	if kind != "" || bodyPresent {
+		if msg.Body, err = decodeBody(kind, version, body); err != nil {
+			return err
+		}
+	}
+	*m = msg
+	return nil
+}
+
+type binaryWriter struct {
+	buf []byte
+}
+
+func (w *binaryWriter) varint(field int, value uint64) {
+	if value == 0 {
+		return
+	}
+	w.buf = binary.AppendUvarint(w.buf, uint64(field)<<3|wireVarint)
+	w.buf = binary.AppendUvarint(w.buf, value)
+}
+
+func (w *binaryWriter) bytes(field int, data []byte) {
+	w.buf = binary.AppendUvarint(w.buf, uint64(field)<<3|wireBytes)
+	w.buf = binary.AppendUvarint(w.buf, uint64(len(data)))
+	w.buf = append(w.buf, data...)
+}
+
+func (w *binaryWriter) string(field int, value string) {
+	if value == "" {
+		return
+	}
+	w.bytes(field, []byte(value))
+}
+
+func (w *binaryWriter) bool(field int, value bool) {
+	if value {
+		w.varint(field, 1)
+	}
+}
+
+func (w *binaryWriter) time(field int, t time.Time) {
+	// Time is always encoded, zero one as well, since its presence can be meaningful.
+	data, _ := t.MarshalBinary()
+	w.bytes(field, data)
+}
+
+// readFields calls fn for every field of the record with either varint value or bytes.
+func readFields(buf []byte, fn func(field int, value uint64, data []byte) error) error {
+	for len(buf) > 0 {
+		tag, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return fmt.Errorf("%w: malformed tag", ErrInvalidBinary)
+		}
+		buf = buf[n:]
+
+		value, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return fmt.Errorf("%w: malformed value", ErrInvalidBinary)
+		}
+		buf = buf[n:]
+
+		var data []byte
+		switch tag & 7 {
+		case wireVarint:
+		case wireBytes:
+			if value > uint64(len(buf)) {
+				return fmt.Errorf("%w: truncated field", ErrInvalidBinary)
+			}
+			data, buf, value = buf[:value], buf[value:], 0
+		default:
+			return fmt.Errorf("%w: unknown wire type %d", ErrInvalidBinary, tag&7)
+		}
+		if err := fn(int(tag>>3), value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package message
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessage_MarshalBinary(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	root := *testMessage1
+	root.ServerTime = at
+	root.Thread = &Thread{ReplyCount: 2, LastReplyAt: at}
+
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{
+			name: "Text message should survive encoding",
+			msg:  testMessage1,
+		},
+		{
+			name: "Edited message with thread and reactions should survive encoding",
+			msg:  root.Edit("Bow!", at.Add(time.Minute)).React("👍", testMessage1.UserID).React("👍", testMessage2.UserID),
+		},
+		{
+			name: "Message with mentions should survive encoding",
+			msg: &Message{
+				ID:       testMessage1.ID,
+				Body:     &TextBody{Text: "@here @tom"},
+				Mentions: &Mentions{UserIDs: []string{testMessage2.UserID}, Here: true},
+			},
+		},
+		{
+			name: "Deleted message should survive encoding",
+			msg:  root.Delete(at),
+		},
+		{
+			name: "Ephemeral message should survive encoding",
+			msg: &Message{
+				ID:        testMessage1.ID,
+				Body:      &TextBody{Text: "Burn after reading"},
+				TTL:       time.Minute,
+				ExpiresAt: &at,
+			},
+		},
+		{
+			name: "Notification should survive encoding",
+			msg:  NewNotification(testMessage1.UserID, testMessage1.User, testRoomID, testRoomName, CreateRoomEvent),
+		},
+		{
+			name: "Ack of client message should survive encoding",
+			msg: &Message{
+				RoomID:   testRoomID,
+				Body:     &AckBody{Seq: 42, MessageID: testMessage1.ID},
+				ClientID: "c1",
+			},
+		},
+		{
+			name: "Message of unknown kind should survive encoding",
+			msg:  &Message{Body: &RawBody{Type: "poll", Version: 2, Data: json.RawMessage(`{"question":"Why?"}`)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := tt.msg.MarshalBinary()
+			if err != nil {
+				t.Fatalf("Message.MarshalBinary() error = %v", err)
+			}
+			got := &Message{}
+			if err := got.UnmarshalBinary(data); err != nil {
+				t.Fatalf("Message.UnmarshalBinary() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("Message.UnmarshalBinary() = %#v, want %#v", got, tt.msg)
+			}
+			if text, _ := json.Marshal(tt.msg); len(data) >= len(text) {
+				t.Errorf("Message.MarshalBinary() = %d bytes, want less than %d bytes of JSON", len(data), len(text))
+			}
+		})
+	}
+}
+
+func TestMessage_UnmarshalBinary(t *testing.T) {
+	valid, _ := testMessage1.MarshalBinary()
+	// Fields which could be added by newer version of the format.
+	extended := binary.AppendUvarint(append([]byte{}, valid...), 99<<3|wireVarint)
+	extended = binary.AppendUvarint(extended, 42)
+	extended = binary.AppendUvarint(extended, 100<<3|wireBytes)
+	extended = append(binary.AppendUvarint(extended, 3), "new"...)
+	// TTL in seconds which does not fit in time.Duration.
+	overflowed := binary.AppendUvarint(append([]byte{}, valid...), fieldTTL<<3|wireVarint)
+	overflowed = binary.AppendUvarint(overflowed, 1<<62)
+
+	tests := []struct {
+		name    string
+		data    []byte
+		want    *Message
+		wantErr error
+	}{
+		{
+			name: "Unknown fields should be skipped",
+			data: extended,
+			want: testMessage1,
+		},
+		{
+			name:    "Empty data should not be decoded",
+			data:    []byte{},
+			wantErr: ErrInvalidBinary,
+		},
+		{
+			name:    "Truncated data should not be decoded",
+			data:    valid[:len(valid)-3],
+			wantErr: ErrInvalidBinary,
+		},
+		{
+			name:    "TTL out of range should not be decoded",
+			data:    overflowed,
+			wantErr: ErrInvalidBinary,
+		},
+		{
+			name:    "Malformed body should not be decoded",
+			data:    []byte{binaryFormatVersion, byte(fieldType<<3 | wireBytes), 4, 't', 'e', 'x', 't', byte(fieldBody<<3 | wireBytes), 1, '{'},
+			wantErr: ErrInvalidBody,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := &Message{}
+			err := got.UnmarshalBinary(tt.data)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Message.UnmarshalBinary() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Message.UnmarshalBinary() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,86 @@
+package message
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// Actions of messages broadcasted as new versions of changed messages.
+const (
+	EditAction   = "edit"
+	DeleteAction = "delete"
+)
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrMessageDeleted  = errors.New("message is deleted")
+	ErrNotAuthor       = errors.New("only author can change message")
+	ErrNotEditable     = errors.New("only text message can be edited")
+	ErrMessageArchived = errors.New("message is archived and cannot be changed")
+)
+
+// Revision is a previous text of the edited message.
+type Revision struct {
+	Text       string    `json:"text"`
+	ReplacedAt time.Time `json:"replacedAt"`
+}
+
+// IsDeleted tells if message is a tombstone of the deleted one.
+func (m *Message) IsDeleted() bool {
+	return m.DeletedAt != nil
+}
+
+// CanChange checks if the user is allowed to edit or delete the message.
+func (m *Message) CanChange(userID string) error {
+	if m.IsDeleted() {
+		return ErrMessageDeleted
+	}
+	if m.UserID != userID {
+		return ErrNotAuthor
+	}
+	return nil
+}
+
+// CanEdit checks if the user is allowed to replace text of the message.
+func (m *Message) CanEdit(userID string) error {
+	if err := m.CanChange(userID); err != nil {
+		return err
+	}
+	if m.Type() != TextKind {
+		return ErrNotEditable
+	}
+	return nil
+}
+
+// ResetChanges drops edits and deletion claimed for the new message, e.g. by the client sending it;
+// message is only changed by Edit and Delete once it is accepted.
+func (m *Message) ResetChanges() {
+	m.EditedAt = nil
+	m.Revisions = nil
+	m.DeletedAt = nil
+	m.removed = false
+}
+
+// Edit returns new version of the message with replaced text, keeping the previous one in revisions.
+func (m *Message) Edit(text string, at time.Time) *Message {
+	edited := *m
+	edited.Action = ""
+	edited.Body = &TextBody{Text: text}
+	edited.EditedAt = &at
+	edited.Revisions = append(slices.Clone(m.Revisions), Revision{Text: m.Text(), ReplacedAt: at})
+	return &edited
+}
+
+// Delete returns tombstone of the message: it keeps identity and position in history,
+// but neither content of its body nor previous texts, nor reactions and mentions.
+func (m *Message) Delete(at time.Time) *Message {
+	tombstone := *m
+	tombstone.Action = ""
+	tombstone.Body = emptyBody(m.Type())
+	tombstone.Revisions = nil
+	tombstone.Reactions = nil
+	tombstone.Mentions = nil
+	tombstone.DeletedAt = &at
+	return &tombstone
+}
@@ -0,0 +1,163 @@
+package message
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessage_CanChange(t *testing.T) {
+	tests := []struct {
+		name    string
+		msg     *Message
+		userID  string
+		wantErr error
+	}{
+		{
+			name:    "Author should be able to change message",
+			msg:     testMessage1,
+			userID:  testMessage1.UserID,
+			wantErr: nil,
+		},
+		{
+			name:    "Other user should not be able to change message",
+			msg:     testMessage1,
+			userID:  testMessage2.UserID,
+			wantErr: ErrNotAuthor,
+		},
+		{
+			name:    "Deleted message should not be changed",
+			msg:     testMessage1.Delete(time.Time{}),
+			userID:  testMessage1.UserID,
+			wantErr: ErrMessageDeleted,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.msg.CanChange(tt.userID); !errors.Is(err, tt.wantErr) {
+				t.Errorf("Message.CanChange() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMessage_Edit(t *testing.T) {
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Minute)
+
+	edited := testMessage1.Edit("Bow to me!", first).Edit("Bow!", second)
+	if edited.Text() != "Bow!" || edited.EditedAt == nil || !edited.EditedAt.Equal(second) {
+		t.Errorf("Message.Edit() = %v, want value %q edited at %v", edited, "Bow!", second)
+	}
+	wantRevisions := []Revision{
+		{Text: testMessage1.Text(), ReplacedAt: first},
+		{Text: "Bow to me!", ReplacedAt: second},
+	}
+	if !reflect.DeepEqual(edited.Revisions, wantRevisions) {
+		t.Errorf("Message.Edit() revisions = %v, want %v", edited.Revisions, wantRevisions)
+	}
+	if testMessage1.Text() != "Bow to me, minion!" || testMessage1.Revisions != nil {
+		t.Errorf("Message.Edit() modified original message %v", testMessage1)
+	}
+
+	deleted := edited.Delete(second)
+	if !deleted.IsDeleted() || deleted.Text() != "" || deleted.Revisions != nil || deleted.ID != testMessage1.ID {
+		t.Errorf("Message.Delete() = %v, want tombstone of %s", deleted, testMessage1.ID)
+	}
+}
+
+func TestMessage_ResetChanges(t *testing.T) {
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	msg := &Message{}
+	if err := json.Unmarshal([]byte(`{"type":"text","version":1,"body":{"text":"Bow!"},"editedAt":"2024-01-01T12:00:00Z",`+
+		`"revisions":[{"text":"Forged","replacedAt":"2024-01-01T12:00:00Z"}],"deletedAt":"2024-01-01T12:00:00Z","removed":true}`), msg); err != nil {
+		t.Fatalf("Message.UnmarshalJSON() error = %v", err)
+	}
+	msg.ResetChanges()
+	want := &Message{Body: &TextBody{Text: "Bow!"}}
+	if !reflect.DeepEqual(msg, want) {
+		t.Errorf("Message.ResetChanges() = %#v, want %#v", msg, want)
+	}
+	if edited := msg.Edit("Bow to me!", at); edited.EditedAt == nil || len(edited.Revisions) != 1 {
+		t.Errorf("Message.Edit() after reset = %v, want single revision", edited)
+	}
+}
+
+func TestStore_ReplaceMessage(t *testing.T) {
+	editedAt := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		restart  bool
+		newStore func(dir string) (Store, func() error, error)
+	}{
+		{
+			name: "Changed message should replace original one in memory",
+			newStore: func(string) (Store, func() error, error) {
+				s := NewInMemoryStore(RetentionPolicy{}, nil, testLogger)
+				return s, s.Close, nil
+			},
+		},
+		{
+			name:    "Changed message should replace original one in file store after restart",
+			restart: true,
+			newStore: func(dir string) (Store, func() error, error) {
+				s, err := NewFileStore(dir, 0, testLogger)
+				if err != nil {
+					return nil, nil, err
+				}
+				return s, s.Close, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			s, closeStore, err := tt.newStore(dir)
+			if err != nil {
+				t.Fatalf("newStore() error = %v", err)
+			}
+			for _, msg := range []*Message{testMessage1, testMessage2} {
+				if err := s.SaveMessage(ctx, testRoomID, msg); err != nil {
+					t.Fatalf("Store.SaveMessage() error = %v", err)
+				}
+			}
+
+			edited := testMessage1.Edit("Bow to me!", editedAt)
+			deleted := testMessage2.Delete(editedAt)
+			for _, msg := range []*Message{edited, deleted} {
+				if err := s.ReplaceMessage(ctx, testRoomID, msg); err != nil {
+					t.Fatalf("Store.ReplaceMessage() error = %v", err)
+				}
+			}
+			unknown := *testMessage1
+			unknown.ID = "00000000-0000-4000-8000-000000000000"
+			if err := s.ReplaceMessage(ctx, testRoomID, &unknown); !errors.Is(err, ErrMessageNotFound) {
+				t.Errorf("Store.ReplaceMessage() of unknown message error = %v, want %v", err, ErrMessageNotFound)
+			}
+
+			if tt.restart {
+				closeStore()
+				if s, closeStore, err = tt.newStore(dir); err != nil {
+					t.Fatalf("newStore() on restart error = %v", err)
+				}
+			}
+			defer closeStore()
+
+			got, err := s.GetMessage(ctx, testRoomID, testMessage1.ID)
+			if err != nil || !reflect.DeepEqual(got, edited) {
+				t.Errorf("Store.GetMessage() = %v, %v, want %v", got, err, edited)
+			}
+			page, err := s.GetMessages(ctx, testRoomID, PageRequest{})
+			if err != nil {
+				t.Fatalf("Store.GetMessages() error = %v", err)
+			}
+			if want := []*Message{edited, deleted}; !reflect.DeepEqual(page.Messages, want) {
+				t.Errorf("Store.GetMessages() = %v, want %v", page.Messages, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package message
+
+import (
+	"context"
+	"sync"
+)
+
+// Clock is a logical clock issuing strictly increasing sequence numbers per room.
+// Numbering continues from the last sequence persisted in the store,
+// so it never goes back after restart, unlike wall-clock time.
+type Clock struct {
+	store Store
+	last  map[string]uint64
+	mu    sync.Mutex
+}
+
+func NewClock(store Store) *Clock {
+	return &Clock{
+		store: store,
+		last:  make(map[string]uint64),
+	}
+}
+
+// Next returns next sequence number for the room.
+func (c *Clock) Next(ctx context.Context, roomID string) (uint64, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	last, ok := c.last[roomID]
+	if !ok {
+		var err error
+		if last, err = c.store.LastSeq(ctx, roomID); err != nil {
+			return 0, err
+		}
+	}
+	last++
+	c.last[roomID] = last
+	return last, nil
+}
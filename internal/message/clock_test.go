@@ -0,0 +1,67 @@
+package message
+
+import (
+	"context"
+	"testing"
+)
+
+func TestClock_Next(t *testing.T) {
+	type fields struct {
+		history map[string][]*Message
+	}
+	type args struct {
+		roomID string
+		calls  int
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    []uint64
+		wantErr bool
+	}{
+		{
+			name: "Sequence of the room without history should start from one",
+			fields: fields{
+				history: map[string][]*Message{},
+			},
+			args: args{
+				roomID: testRoomID,
+				calls:  3,
+			},
+			want:    []uint64{1, 2, 3},
+			wantErr: false,
+		},
+		{
+			name: "Sequence should continue after the latest stored message",
+			fields: fields{
+				history: map[string][]*Message{
+					testRoomID: {testMessage1, testMessage2},
+				},
+			},
+			args: args{
+				roomID: testRoomID,
+				calls:  2,
+			},
+			want:    []uint64{testMessage2.Seq + 1, testMessage2.Seq + 2},
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewClock(&InMemoryStore{
+				history: tt.fields.history,
+			})
+			for i := 0; i < tt.args.calls; i++ {
+				got, err := c.Next(context.Background(), tt.args.roomID)
+				if (err != nil) != tt.wantErr {
+					t.Errorf("Clock.Next() error = %v, wantErr %v", err, tt.wantErr)
+					return
+				}
+				if got != tt.want[i] {
+					t.Errorf("Clock.Next() call #%d = %v, want %v", i+1, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package message
+
+import "time"
+
+// ExpireAction marks message broadcasted when ephemeral message is removed from history.
+const ExpireAction = "expire"
+
+// IsEphemeral tells if message is removed from history once it expires.
+func (m *Message) IsEphemeral() bool {
+	return m.ExpiresAt != nil
+}
+
+// Expired tells if ephemeral message is due to be removed.
+func (m *Message) Expired(now time.Time) bool {
+	return m.ExpiresAt != nil && !m.ExpiresAt.After(now)
+}
+
+// Expiration returns event telling room members that the message is gone.
+func (m *Message) Expiration() *Message {
+	return &Message{
+		ID:       m.ID,
+		Seq:      m.Seq,
+		RoomID:   m.RoomID,
+		Room:     m.Room,
+		ParentID: m.ParentID,
+		Action:   ExpireAction,
+	}
+}
+
+// placeholder takes position of the removed message in history,
+// so that positions and sequence numbers of other messages stay the same.
+func (m *Message) placeholder() *Message {
+	return &Message{
+		Seq:        m.Seq,
+		ServerTime: m.ServerTime,
+		removed:    true,
+	}
+}
+
+// archived returns messages to archive, where ephemeral ones are replaced with placeholders,
+// since archived messages are not removed on expiry.
+func archived(messages []*Message) []*Message {
+	archived := make([]*Message, len(messages))
+	for i, msg := range messages {
+		if msg.IsEphemeral() {
+			msg = msg.placeholder()
+		}
+		archived[i] = msg
+	}
+	return archived
+}
+
+// visible skips placeholders of removed messages.
+func visible(messages []*Message) []*Message {
+	kept := make([]*Message, 0, len(messages))
+	for _, msg := range messages {
+		if !msg.removed {
+			kept = append(kept, msg)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,126 @@
+package message
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestStore_RemoveMessage(t *testing.T) {
+	tests := []struct {
+		name     string
+		restart  bool
+		newStore func(dir string) (Store, func() error, error)
+	}{
+		{
+			name: "Removed message should be gone from memory",
+			newStore: func(string) (Store, func() error, error) {
+				s := NewInMemoryStore(RetentionPolicy{}, nil, testLogger)
+				return s, s.Close, nil
+			},
+		},
+		{
+			name:    "Removed message should be gone from file store after restart",
+			restart: true,
+			newStore: func(dir string) (Store, func() error, error) {
+				s, err := NewFileStore(dir, 0, testLogger)
+				if err != nil {
+					return nil, nil, err
+				}
+				return s, s.Close, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			s, closeStore, err := tt.newStore(dir)
+			if err != nil {
+				t.Fatalf("newStore() error = %v", err)
+			}
+			reply := *testMessage2
+			reply.ParentID = testMessage1.ID
+			for _, msg := range []*Message{testMessage1, &reply} {
+				if err := s.SaveMessage(ctx, testRoomID, msg); err != nil {
+					t.Fatalf("Store.SaveMessage() error = %v", err)
+				}
+			}
+			// Earlier version in the changes log has to be removed as well.
+			if err := s.ReplaceMessage(ctx, testRoomID, reply.Edit("Never ever!!!", time.Time{})); err != nil {
+				t.Fatalf("Store.ReplaceMessage() error = %v", err)
+			}
+			if err := s.RemoveMessage(ctx, testRoomID, reply.ID); err != nil {
+				t.Fatalf("Store.RemoveMessage() error = %v", err)
+			}
+			if err := s.RemoveMessage(ctx, testRoomID, reply.ID); !errors.Is(err, ErrMessageNotFound) {
+				t.Errorf("Store.RemoveMessage() of removed message error = %v, want %v", err, ErrMessageNotFound)
+			}
+
+			if tt.restart {
+				closeStore()
+				if s, closeStore, err = tt.newStore(dir); err != nil {
+					t.Fatalf("newStore() on restart error = %v", err)
+				}
+			}
+			defer closeStore()
+
+			if _, err := s.GetMessage(ctx, testRoomID, reply.ID); !errors.Is(err, ErrMessageNotFound) {
+				t.Errorf("Store.GetMessage() of removed message error = %v, want %v", err, ErrMessageNotFound)
+			}
+			page, err := s.GetMessages(ctx, testRoomID, PageRequest{})
+			if err != nil {
+				t.Fatalf("Store.GetMessages() error = %v", err)
+			}
+			if want := []*Message{testMessage1}; !reflect.DeepEqual(page.Messages, want) {
+				t.Errorf("Store.GetMessages() = %v, want %v", page.Messages, want)
+			}
+			if page, err := s.GetThread(ctx, testRoomID, testMessage1.ID, PageRequest{}); err != nil || len(page.Messages) != 0 {
+				t.Errorf("Store.GetThread() = %v, %v, want no replies", page, err)
+			}
+			// Sequence goes on after removed message.
+			if seq, err := s.LastSeq(ctx, testRoomID); err != nil || seq != reply.Seq {
+				t.Errorf("Store.LastSeq() = %v, %v, want %v", seq, err, reply.Seq)
+			}
+
+			files, _ := filepath.Glob(filepath.Join(dir, testRoomID, "*"))
+			for _, file := range files {
+				if data, _ := os.ReadFile(file); bytes.Contains(data, []byte("Never")) {
+					t.Errorf("File %s still holds text of removed message", file)
+				}
+			}
+		})
+	}
+}
+
+func TestInMemoryStore_ArchiveEphemeral(t *testing.T) {
+	ctx := context.Background()
+	archive, err := NewArchive(t.TempDir(), 4)
+	if err != nil {
+		t.Fatalf("NewArchive() error = %v", err)
+	}
+	s := NewInMemoryStore(RetentionPolicy{MaxCount: 1}, archive, testLogger)
+	defer s.Close()
+
+	expiresAt := time.Now().Add(time.Hour)
+	ephemeral := *testMessage1
+	ephemeral.ExpiresAt = &expiresAt
+	for _, msg := range []*Message{&ephemeral, testMessage2} {
+		if err := s.SaveMessage(ctx, testRoomID, msg); err != nil {
+			t.Fatalf("InMemoryStore.SaveMessage() error = %v", err)
+		}
+	}
+
+	page, err := s.GetMessages(ctx, testRoomID, PageRequest{})
+	if err != nil {
+		t.Fatalf("InMemoryStore.GetMessages() error = %v", err)
+	}
+	if want := []*Message{testMessage2}; !reflect.DeepEqual(page.Messages, want) {
+		t.Errorf("InMemoryStore.GetMessages() = %v, want evicted ephemeral message skipped", page.Messages)
+	}
+}
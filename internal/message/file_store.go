@@ -0,0 +1,718 @@
+package message
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const (
+	segmentExt = ".seg"
+	// Changes log holds new versions of edited and deleted messages.
+	changesFile = "changes.log"
+
+	// Record header holds payload length and its checksum.
+	recordHeaderSize = 8
+
+	defaultMaxSegmentSize = 4 << 20
+)
+
+// FileStore is a persistent Store keeping history of each room in its own directory
+// as a sequence of append-only segment files.
+// Segment file is named after the position of its first message in the room history.
+// Every record is length-prefixed and checksummed; offsets of all records are kept
+// in memory, so that any range of messages is read directly without scanning.
+// On open, segments are scanned to rebuild the index and torn record at the end of
+// the last segment (e.g. after a crash during write) is cut off.
+// Segments are never rewritten: new versions of changed messages are appended to the changes log
+// of the room, and the latest version overrides the original one on reads.
+// The only exception is removal of the message, which overwrites all its records in place
+// with a placeholder of the same size.
+type FileStore struct {
+	dir            string
+	maxSegmentSize int64
+
+	rooms map[string]*roomLog
+	mu    sync.Mutex
+
+	logger *log.Logger
+}
+
+// roomLog is a history of one room.
+type roomLog struct {
+	dir      string
+	segments []*segment
+	active   *os.File
+	// Positions of messages by their IDs.
+	ids map[string]int
+	// Positions of replies by IDs of their root messages.
+	threads map[string][]int
+	// Positions of messages by IDs of users mentioned in them.
+	mentions map[string][]int
+	// Offsets in changes log of the latest versions of changed messages by their positions.
+	changes     map[int]int64
+	changesLog  *os.File
+	changesSize int64
+	mu          sync.RWMutex
+}
+
+type segment struct {
+	path string
+	// Position of the first message of the segment in room history.
+	base    int
+	offsets []int64
+	size    int64
+}
+
+// NewFileStore opens (or initializes) message store in the directory.
+// New segment is started when current one exceeds maxSegmentSize bytes; zero means default.
+func NewFileStore(dir string, maxSegmentSize int64, logger *log.Logger) (*FileStore, error) {
+	if maxSegmentSize <= 0 {
+		maxSegmentSize = defaultMaxSegmentSize
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create message store directory: %w", err)
+	}
+
+	s := &FileStore{
+		dir:            dir,
+		maxSegmentSize: maxSegmentSize,
+		rooms:          make(map[string]*roomLog),
+		logger:         logger,
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read message store directory: %w", err)
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		room, err := s.openRoomLog(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+		s.rooms[entry.Name()] = room
+	}
+
+	return s, nil
+}
+
+// Close releases all open segment files.
+func (s *FileStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	for _, room := range s.rooms {
+		room.mu.Lock()
+		if room.active != nil {
+			if closeErr := room.active.Close(); err == nil {
+				err = closeErr
+			}
+			room.active = nil
+		}
+		if room.changesLog != nil {
+			if closeErr := room.changesLog.Close(); err == nil {
+				err = closeErr
+			}
+			room.changesLog = nil
+		}
+		room.mu.Unlock()
+	}
+	return err
+}
+
+func (s *FileStore) GetMessages(_ context.Context, roomID string, req PageRequest) (*Page, error) {
+	room, err := s.room(roomID, false)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return newPage([]*Message{}, 0, 0, 0, 0), nil
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	from, to, err := pageBounds(req, 0, room.count())
+	if err != nil {
+		return nil, err
+	}
+	messages, err := room.read(from, to)
+	if err != nil {
+		return nil, err
+	}
+	return newPage(visible(messages), from, to, 0, room.count()), nil
+}
+
+func (s *FileStore) SaveMessage(_ context.Context, roomID string, msg *Message) error {
+	room, err := s.room(roomID, true)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	if err := room.append(data, s.maxSegmentSize); err != nil {
+		return err
+	}
+	if msg.ID != "" {
+		room.ids[msg.ID] = room.count() - 1
+	}
+	if msg.IsReply() {
+		room.threads[msg.ParentID] = append(room.threads[msg.ParentID], room.count()-1)
+	}
+	if msg.Mentions != nil {
+		for _, userID := range msg.Mentions.UserIDs {
+			room.mentions[userID] = append(room.mentions[userID], room.count()-1)
+		}
+	}
+	return nil
+}
+
+func (s *FileStore) GetMessage(_ context.Context, roomID string, msgID string) (*Message, error) {
+	room, err := s.room(roomID, false)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	position, ok := room.ids[msgID]
+	if !ok {
+		return nil, ErrMessageNotFound
+	}
+	messages, err := room.read(position, position+1)
+	if err != nil {
+		return nil, err
+	}
+	return messages[0], nil
+}
+
+func (s *FileStore) GetThread(_ context.Context, roomID string, rootID string, req PageRequest) (*Page, error) {
+	room, err := s.room(roomID, false)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return nil, ErrMessageNotFound
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	if _, ok := room.ids[rootID]; !ok {
+		return nil, ErrMessageNotFound
+	}
+	replies := room.threads[rootID]
+	from, to, err := pageBounds(req, 0, len(replies))
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*Message, 0, to-from)
+	for _, position := range replies[from:to] {
+		reply, err := room.read(position, position+1)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, reply...)
+	}
+	return newPage(visible(messages), from, to, 0, len(replies)), nil
+}
+
+func (s *FileStore) GetMentions(_ context.Context, roomID string, userID string, req PageRequest) (*Page, error) {
+	room, err := s.room(roomID, false)
+	if err != nil {
+		return nil, err
+	}
+	if room == nil {
+		return newPage([]*Message{}, 0, 0, 0, 0), nil
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	mentions := room.mentions[userID]
+	from, to, err := pageBounds(req, 0, len(mentions))
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*Message, 0, to-from)
+	for _, position := range mentions[from:to] {
+		mention, err := room.read(position, position+1)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, mention...)
+	}
+	return newPage(visible(messages), from, to, 0, len(mentions)), nil
+}
+
+func (s *FileStore) ReplaceMessage(_ context.Context, roomID string, msg *Message) error {
+	room, err := s.room(roomID, false)
+	if err != nil {
+		return err
+	}
+	if room == nil {
+		return ErrMessageNotFound
+	}
+
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	position, ok := room.ids[msg.ID]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	if room.changesLog == nil {
+		f, err := os.OpenFile(filepath.Join(room.dir, changesFile), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open changes log: %w", err)
+		}
+		room.changesLog = f
+	}
+	if err := writeRecord(room.changesLog, room.changesSize, data); err != nil {
+		return err
+	}
+	room.changes[position] = room.changesSize
+	room.changesSize += recordHeaderSize + int64(len(data))
+	return nil
+}
+
+// RemoveMessage overwrites the original record of the message and all its versions in the changes log,
+// so that its content does not stay on disk.
+func (s *FileStore) RemoveMessage(_ context.Context, roomID string, msgID string) error {
+	room, err := s.room(roomID, false)
+	if err != nil {
+		return err
+	}
+	if room == nil {
+		return ErrMessageNotFound
+	}
+
+	room.mu.Lock()
+	defer room.mu.Unlock()
+
+	position, ok := room.ids[msgID]
+	if !ok {
+		return ErrMessageNotFound
+	}
+	messages, err := room.read(position, position+1)
+	if err != nil {
+		return err
+	}
+	placeholder, err := json.Marshal(messages[0].placeholder())
+	if err != nil {
+		return fmt.Errorf("encode message: %w", err)
+	}
+
+	if room.changesLog != nil {
+		var offsets []int64
+		for offset := int64(0); offset < room.changesSize; {
+			data, n, err := readRecord(room.changesLog, offset, room.changesSize)
+			if err != nil {
+				return fmt.Errorf("read changes log: %w", err)
+			}
+			var key messageKey
+			if err := json.Unmarshal(data, &key); err != nil {
+				return fmt.Errorf("decode changed message: %w", err)
+			}
+			if key.ID == msgID {
+				offsets = append(offsets, offset)
+			}
+			offset += n
+		}
+		if err := overwriteRecords(room.changesLog.Name(), offsets, placeholder); err != nil {
+			return err
+		}
+	}
+	i := sort.Search(len(room.segments), func(i int) bool {
+		return room.segments[i].base > position
+	}) - 1
+	seg := room.segments[i]
+	if err := overwriteRecords(seg.path, []int64{seg.offsets[position-seg.base]}, placeholder); err != nil {
+		return err
+	}
+
+	delete(room.ids, msgID)
+	delete(room.threads, msgID)
+	delete(room.changes, position)
+	return nil
+}
+
+func (s *FileStore) LastSeq(_ context.Context, roomID string) (uint64, error) {
+	room, err := s.room(roomID, false)
+	if err != nil || room == nil {
+		return 0, err
+	}
+
+	room.mu.RLock()
+	defer room.mu.RUnlock()
+
+	count := room.count()
+	messages, err := room.read(count-1, count)
+	if err != nil || len(messages) == 0 {
+		return 0, err
+	}
+	return messages[0].Seq, nil
+}
+
+// room returns history of the room, creating it if asked.
+func (s *FileStore) room(roomID string, create bool) (*roomLog, error) {
+	// Room ID becomes directory name, so it must not escape the store directory.
+	if roomID == "" || roomID != filepath.Base(roomID) || strings.HasPrefix(roomID, ".") {
+		return nil, fmt.Errorf("invalid room ID %q", roomID)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if room, ok := s.rooms[roomID]; ok || !create {
+		return room, nil
+	}
+
+	dir := filepath.Join(s.dir, roomID)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create room directory: %w", err)
+	}
+	room := newRoomLog(dir)
+	s.rooms[roomID] = room
+	return room, nil
+}
+
+// openRoomLog loads segments of the room and rebuilds their indexes.
+func (s *FileStore) openRoomLog(dir string) (*roomLog, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("read room directory: %w", err)
+	}
+
+	room := newRoomLog(dir)
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || filepath.Ext(name) != segmentExt {
+			continue
+		}
+		base, err := strconv.Atoi(strings.TrimSuffix(name, segmentExt))
+		if err != nil {
+			continue
+		}
+		room.segments = append(room.segments, &segment{path: filepath.Join(dir, name), base: base})
+	}
+	sort.Slice(room.segments, func(i, j int) bool {
+		return room.segments[i].base < room.segments[j].base
+	})
+
+	for i, seg := range room.segments {
+		last := i == len(room.segments)-1
+		size, err := s.scanLog(seg.path, last, func(offset int64, data []byte) error {
+			var key messageKey
+			if err := json.Unmarshal(data, &key); err != nil {
+				return fmt.Errorf("decode message: %w", err)
+			}
+			position := seg.base + len(seg.offsets)
+			if key.ID != "" {
+				room.ids[key.ID] = position
+			}
+			if key.ParentID != "" {
+				room.threads[key.ParentID] = append(room.threads[key.ParentID], position)
+			}
+			if key.Mentions != nil {
+				for _, userID := range key.Mentions.UserIDs {
+					room.mentions[userID] = append(room.mentions[userID], position)
+				}
+			}
+			seg.offsets = append(seg.offsets, offset)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		seg.size = size
+	}
+
+	changesPath := filepath.Join(dir, changesFile)
+	if _, err := os.Stat(changesPath); err == nil {
+		size, err := s.scanLog(changesPath, true, func(offset int64, data []byte) error {
+			var key messageKey
+			if err := json.Unmarshal(data, &key); err != nil {
+				return fmt.Errorf("decode changed message: %w", err)
+			}
+			if position, ok := room.ids[key.ID]; ok {
+				room.changes[position] = offset
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		room.changesSize = size
+		if room.changesLog, err = os.OpenFile(changesPath, os.O_RDWR|os.O_APPEND, 0o644); err != nil {
+			return nil, fmt.Errorf("open changes log: %w", err)
+		}
+	}
+	return room, nil
+}
+
+// messageKey is the part of stored message needed to index it.
+type messageKey struct {
+	ID       string    `json:"id"`
+	ParentID string    `json:"parentId"`
+	Mentions *Mentions `json:"mentions"`
+}
+
+// scanLog calls fn for every record of the file and returns size of its valid part.
+// Invalid record is only tolerated at the end of the last file of the log, where it is truncated.
+func (s *FileStore) scanLog(path string, last bool, fn func(offset int64, data []byte) error) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("open log: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("stat log: %w", err)
+	}
+
+	var offset int64
+	for offset < info.Size() {
+		data, n, err := readRecord(f, offset, info.Size())
+		if err != nil {
+			break
+		}
+		if err := fn(offset, data); err != nil {
+			return 0, err
+		}
+		offset += n
+	}
+
+	if offset < info.Size() {
+		if !last {
+			return 0, fmt.Errorf("log %s is corrupted at offset %d", path, offset)
+		}
+		s.logger.Printf("Discarding %d bytes of torn log %s tail.\n", info.Size()-offset, path)
+		if err := os.Truncate(path, offset); err != nil {
+			return 0, fmt.Errorf("truncate log: %w", err)
+		}
+	}
+	return offset, nil
+}
+
+func newRoomLog(dir string) *roomLog {
+	return &roomLog{
+		dir:      dir,
+		ids:      make(map[string]int),
+		threads:  make(map[string][]int),
+		mentions: make(map[string][]int),
+		changes:  make(map[int]int64),
+	}
+}
+
+// count returns number of messages in the room history.
+func (r *roomLog) count() int {
+	if len(r.segments) == 0 {
+		return 0
+	}
+	last := r.segments[len(r.segments)-1]
+	return last.base + len(last.offsets)
+}
+
+// read returns messages in [from, to) range of room history.
+func (r *roomLog) read(from, to int) ([]*Message, error) {
+	if from < 0 {
+		from = 0
+	}
+	if total := r.count(); to > total {
+		to = total
+	}
+	if from >= to {
+		return []*Message{}, nil
+	}
+
+	messages := make([]*Message, 0, to-from)
+	// Find segment holding the first requested message.
+	i := sort.Search(len(r.segments), func(i int) bool {
+		return r.segments[i].base > from
+	}) - 1
+	for ; i < len(r.segments) && from < to; i++ {
+		seg := r.segments[i]
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return nil, fmt.Errorf("open segment: %w", err)
+		}
+		for ; from < to && from-seg.base < len(seg.offsets); from++ {
+			var data []byte
+			if offset, ok := r.changes[from]; ok {
+				// Message was changed, read its latest version instead.
+				data, _, err = readRecord(r.changesLog, offset, r.changesSize)
+			} else {
+				data, _, err = readRecord(f, seg.offsets[from-seg.base], seg.size)
+			}
+			if err != nil {
+				f.Close()
+				return nil, fmt.Errorf("read segment: %w", err)
+			}
+			msg := &Message{}
+			if err := json.Unmarshal(data, msg); err != nil {
+				f.Close()
+				return nil, fmt.Errorf("decode message: %w", err)
+			}
+			messages = append(messages, msg)
+		}
+		f.Close()
+	}
+	return messages, nil
+}
+
+// append writes record to the active segment, starting a new one when it is full.
+func (r *roomLog) append(data []byte, maxSegmentSize int64) error {
+	if len(r.segments) == 0 || r.segments[len(r.segments)-1].size >= maxSegmentSize {
+		if err := r.rollSegment(); err != nil {
+			return err
+		}
+	}
+	if r.active == nil {
+		seg := r.segments[len(r.segments)-1]
+		f, err := os.OpenFile(seg.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return fmt.Errorf("open segment: %w", err)
+		}
+		r.active = f
+	}
+
+	seg := r.segments[len(r.segments)-1]
+	if err := writeRecord(r.active, seg.size, data); err != nil {
+		return err
+	}
+	seg.offsets = append(seg.offsets, seg.size)
+	seg.size += recordHeaderSize + int64(len(data))
+	return nil
+}
+
+func (r *roomLog) rollSegment() error {
+	if r.active != nil {
+		if err := r.active.Close(); err != nil {
+			return fmt.Errorf("close segment: %w", err)
+		}
+		r.active = nil
+	}
+	base := r.count()
+	r.segments = append(r.segments, &segment{
+		path: filepath.Join(r.dir, fmt.Sprintf("%020d%s", base, segmentExt)),
+		base: base,
+	})
+	return nil
+}
+
+// writeRecord appends record to the file of the given size and syncs it.
+func writeRecord(f *os.File, size int64, data []byte) error {
+	record := make([]byte, recordHeaderSize+len(data))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(data))
+	copy(record[recordHeaderSize:], data)
+
+	if _, err := f.Write(record); err != nil {
+		// Do not leave partial record in the middle of the file.
+		f.Truncate(size)
+		return fmt.Errorf("write record: %w", err)
+	}
+	if err := f.Sync(); err != nil {
+		f.Truncate(size)
+		return fmt.Errorf("sync record: %w", err)
+	}
+	return nil
+}
+
+// overwriteRecords replaces payloads of the records at offsets with data padded to their size, and syncs the file.
+// JSON ignores trailing spaces, so padded record is decoded as usual.
+func overwriteRecords(path string, offsets []int64, data []byte) error {
+	if len(offsets) == 0 {
+		return nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer f.Close()
+
+	r, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("open log: %w", err)
+	}
+	defer r.Close()
+
+	header := make([]byte, recordHeaderSize)
+	for _, offset := range offsets {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return fmt.Errorf("read record: %w", err)
+		}
+		size := int(binary.BigEndian.Uint32(header[0:4]))
+		if len(data) > size {
+			return fmt.Errorf("record at offset %d is too small to overwrite", offset)
+		}
+		payload := make([]byte, size)
+		copy(payload, data)
+		for i := len(data); i < size; i++ {
+			payload[i] = ' '
+		}
+		binary.BigEndian.PutUint32(header[4:8], crc32.ChecksumIEEE(payload))
+		if _, err := f.WriteAt(append(header, payload...), offset); err != nil {
+			return fmt.Errorf("overwrite record: %w", err)
+		}
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("sync log: %w", err)
+	}
+	return nil
+}
+
+// readRecord reads record at offset, returning its payload and full size.
+// Record must fit into limit, otherwise it is considered torn.
+func readRecord(f io.ReaderAt, offset, limit int64) ([]byte, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		return nil, 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	if offset+recordHeaderSize+int64(size) > limit {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	data := make([]byte, size)
+	if _, err := f.ReadAt(data, offset+recordHeaderSize); err != nil {
+		return nil, 0, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, errors.New("record checksum mismatch")
+	}
+	return data, recordHeaderSize + int64(size), nil
+}
@@ -0,0 +1,135 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+func TestFileStore_Recovery(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxSegmentSize int64
+		tornTail       []byte
+		wantSegments   int
+	}{
+		{
+			name:           "History should survive restart",
+			maxSegmentSize: 1 << 20,
+			wantSegments:   1,
+		},
+		{
+			name:           "History split into several segments should survive restart",
+			maxSegmentSize: 700,
+			wantSegments:   4,
+		},
+		{
+			name:           "Torn record at the end of the last segment should be discarded",
+			maxSegmentSize: 1 << 20,
+			tornTail:       []byte{0, 0, 0, 100, 1, 2, 3, 4, '{', '"'},
+			wantSegments:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+
+			s, err := NewFileStore(dir, tt.maxSegmentSize, testLogger)
+			if err != nil {
+				t.Fatalf("NewFileStore() error = %v", err)
+			}
+			want := []*Message{}
+			for i := 0; i < 10; i++ {
+				msg := *testMessage1
+				msg.Seq = uint64(i + 1)
+				msg.Body = &TextBody{Text: fmt.Sprintf("%s #%d", testMessage1.Text(), i)}
+				if err := s.SaveMessage(ctx, testRoomID, &msg); err != nil {
+					t.Fatalf("FileStore.SaveMessage() error = %v", err)
+				}
+				want = append(want, &msg)
+			}
+			s.Close()
+
+			segments, _ := filepath.Glob(filepath.Join(dir, testRoomID, "*"+segmentExt))
+			if len(segments) != tt.wantSegments {
+				t.Errorf("segments = %d, want %d", len(segments), tt.wantSegments)
+			}
+			if tt.tornTail != nil {
+				f, err := os.OpenFile(segments[len(segments)-1], os.O_WRONLY|os.O_APPEND, 0o644)
+				if err != nil {
+					t.Fatal(err)
+				}
+				f.Write(tt.tornTail)
+				f.Close()
+			}
+
+			s, err = NewFileStore(dir, tt.maxSegmentSize, testLogger)
+			if err != nil {
+				t.Fatalf("NewFileStore() on restart error = %v", err)
+			}
+			defer s.Close()
+
+			page, err := s.GetMessages(ctx, testRoomID, PageRequest{})
+			if err != nil {
+				t.Fatalf("FileStore.GetMessages() error = %v", err)
+			}
+			got := page.Messages
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("FileStore.GetMessages() = %v, want %v", got, want)
+			}
+
+			if seq, err := s.LastSeq(ctx, testRoomID); err != nil || seq != uint64(len(want)) {
+				t.Errorf("FileStore.LastSeq() = %v, %v, want %v", seq, err, len(want))
+			}
+
+			// Store should accept new messages after recovery.
+			if err := s.SaveMessage(ctx, testRoomID, testMessage2); err != nil {
+				t.Fatalf("FileStore.SaveMessage() after recovery error = %v", err)
+			}
+			page, _ = s.GetMessages(ctx, testRoomID, PageRequest{})
+			got = page.Messages
+			if len(got) != len(want)+1 || !reflect.DeepEqual(got[len(want)], testMessage2) {
+				t.Errorf("FileStore.GetMessages() after recovery = %v, want %v appended", got, testMessage2)
+			}
+		})
+	}
+}
+
+func TestFileStore_SaveMessage(t *testing.T) {
+	tests := []struct {
+		name    string
+		roomID  string
+		wantErr bool
+	}{
+		{
+			name:    "Saving message to the room should succeed",
+			roomID:  testRoomID,
+			wantErr: false,
+		},
+		{
+			name:    "Saving message with room ID escaping store directory should fail",
+			roomID:  "../" + testRoomID,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewFileStore(t.TempDir(), 0, testLogger)
+			if err != nil {
+				t.Fatalf("NewFileStore() error = %v", err)
+			}
+			defer s.Close()
+			if err := s.SaveMessage(context.Background(), tt.roomID, testMessage1); (err != nil) != tt.wantErr {
+				t.Errorf("FileStore.SaveMessage() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Kinds of message bodies.
+const (
+	TextKind         = "text"
+	NotificationKind = "notification"
+	MembershipKind   = "membership"
+	AttachmentKind   = "attachment"
+	EditKind         = "edit"
+	ReactionKind     = "reaction"
+	ReadKind         = "read"
+	AckKind          = "ack"
+	PinKind          = "pin"
+	ModerationKind   = "moderation"
+	ReviewKind       = "review"
+	ErrorKind        = "error"
+)
+
+// Membership events.
+const (
+	JoinRoomEvent  = "join-room"
+	LeaveRoomEvent = "leave-room"
+)
+
+// Error codes.
+const (
+	// MalformedError is sent when message cannot be decoded.
+	MalformedError = "malformed"
+	// InvalidError is sent when message is incomplete or addressed to a room which does not exist.
+	InvalidError = "invalid"
+	// RateLimitedError is sent when the sender, the connection or the room exceed their message rate.
+	RateLimitedError = "rate-limited"
+	// SlowModeError is sent when participant posts to the room in slow mode before the interval passed.
+	SlowModeError = "slow-mode"
+	// NotAcceptedError is sent when message cannot be applied, e.g. edit of someone else's message.
+	NotAcceptedError = "not-accepted"
+	// NotDeliveredError is sent when message is accepted, but it could not be delivered to the room.
+	NotDeliveredError = "not-delivered"
+)
+
+var ErrInvalidBody = errors.New("invalid message body")
+
+// Body is a content of the message of particular kind.
+type Body interface {
+	Kind() string
+}
+
+// TextBody is a chat line.
+type TextBody struct {
+	Text string `json:"text"`
+}
+
+// NotificationBody lets users know about an event in the system, e.g. room creation.
+type NotificationBody struct {
+	Event string `json:"event"`
+}
+
+// MembershipBody lets room participants know that user joined or left the room.
+type MembershipBody struct {
+	Event string `json:"event"`
+}
+
+// AttachmentBody references a file shared in the room.
+// Type, size and thumbnail availability are taken from the stored file, not from the client.
+type AttachmentBody struct {
+	BlobID    string `json:"blobId"`
+	Name      string `json:"name"`
+	MIMEType  string `json:"mimeType"`
+	Size      int64  `json:"size"`
+	Thumbnail bool   `json:"thumbnail,omitempty"`
+}
+
+// EditBody requests to replace text of the message, or to delete the message.
+type EditBody struct {
+	MessageID string `json:"messageId"`
+	Text      string `json:"text,omitempty"`
+	Delete    bool   `json:"delete,omitempty"`
+}
+
+// ReactionBody requests to add, or to remove, reaction to the message.
+type ReactionBody struct {
+	MessageID string `json:"messageId"`
+	Emoji     string `json:"emoji"`
+	Remove    bool   `json:"remove,omitempty"`
+}
+
+// ReadBody reports that user has read messages of the room up to the sequence number.
+// Reports are not kept in history; room participants get them as "seen by" updates.
+type ReadBody struct {
+	Seq uint64 `json:"seq"`
+}
+
+// AckBody acknowledges that client has received messages of the room up to the sequence number.
+// Acks are tracked by the socket they came from and never reach broadcaster.
+// Server acknowledges the message client sent with client ID the same way, once it is accepted,
+// with ID and sequence number the message got.
+type AckBody struct {
+	Seq       uint64 `json:"seq"`
+	MessageID string `json:"messageId,omitempty"`
+}
+
+// PinBody requests to pin the message above the room log, or to unpin it.
+// Pins are kept by the repository rather than in history; room participants get pin requests
+// as notifications once they are applied.
+type PinBody struct {
+	MessageID string `json:"messageId"`
+	Unpin     bool   `json:"unpin,omitempty"`
+}
+
+// ModerationBody tells the sender what moderation has done with their message and why,
+// e.g. that it was rejected or held for review. Notices are addressed to one user and are not kept in history.
+type ModerationBody struct {
+	Outcome string `json:"outcome"`
+	Reason  string `json:"reason,omitempty"`
+	// ID of the message held for review; other messages do not have IDs yet when they are moderated.
+	MessageID string `json:"messageId,omitempty"`
+}
+
+// ReviewBody requests to release message held for review by moderation into the room, or to discard it.
+type ReviewBody struct {
+	MessageID string `json:"messageId"`
+	Discard   bool   `json:"discard,omitempty"`
+}
+
+// ErrorBody tells the sender that their message was not accepted and why.
+// Errors are answered to the connection message came from, and are not kept in history.
+type ErrorBody struct {
+	Code   string `json:"code"`
+	Reason string `json:"reason,omitempty"`
+	// Milliseconds to wait before sending again, if it is known.
+	RetryAfter int64 `json:"retryAfterMs,omitempty"`
+	// ID of the message accepted, but not delivered; such message is kept and should not be sent again.
+	MessageID string `json:"messageId,omitempty"`
+}
+
+// RawBody keeps body of unknown kind as is, e.g. written by a newer version of the service,
+// so that it is neither lost nor misinterpreted.
+type RawBody struct {
+	Type    string
+	Version int
+	Data    json.RawMessage
+}
+
+func (*TextBody) Kind() string         { return TextKind }
+func (*NotificationBody) Kind() string { return NotificationKind }
+func (*MembershipBody) Kind() string   { return MembershipKind }
+func (*AttachmentBody) Kind() string   { return AttachmentKind }
+func (*EditBody) Kind() string         { return EditKind }
+func (*ReactionBody) Kind() string     { return ReactionKind }
+func (*ReadBody) Kind() string         { return ReadKind }
+func (*AckBody) Kind() string          { return AckKind }
+func (*PinBody) Kind() string          { return PinKind }
+func (*ModerationBody) Kind() string   { return ModerationKind }
+func (*ReviewBody) Kind() string       { return ReviewKind }
+func (*ErrorBody) Kind() string        { return ErrorKind }
+func (b *RawBody) Kind() string        { return b.Type }
+
+// Codec decodes bodies of one kind.
+// Body is always written with the latest schema Version of its kind;
+// Decode gets version body was written with and has to upgrade bodies of older versions.
+// Bodies of newer versions are decoded as well, ignoring fields which are not known yet.
+type Codec struct {
+	Version int
+	Decode  func(version int, data json.RawMessage) (Body, error)
+}
+
+var (
+	codecs   = make(map[string]Codec)
+	codecsMu sync.RWMutex
+)
+
+func init() {
+	RegisterKind(TextKind, JSONCodec[TextBody](1))
+	RegisterKind(NotificationKind, JSONCodec[NotificationBody](1))
+	RegisterKind(MembershipKind, JSONCodec[MembershipBody](1))
+	RegisterKind(AttachmentKind, JSONCodec[AttachmentBody](1))
+	RegisterKind(EditKind, JSONCodec[EditBody](1))
+	RegisterKind(ReactionKind, JSONCodec[ReactionBody](1))
+	RegisterKind(ReadKind, JSONCodec[ReadBody](1))
+	RegisterKind(AckKind, JSONCodec[AckBody](1))
+	RegisterKind(PinKind, JSONCodec[PinBody](1))
+	RegisterKind(ModerationKind, JSONCodec[ModerationBody](1))
+	RegisterKind(ReviewKind, JSONCodec[ReviewBody](1))
+	RegisterKind(ErrorKind, JSONCodec[ErrorBody](1))
+}
+
+// RegisterKind makes kind known to message decoding.
+// It panics if the kind is registered twice.
+func RegisterKind(kind string, codec Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+
+	if _, ok := codecs[kind]; ok {
+		panic(fmt.Sprintf("message kind %s is already registered", kind))
+	}
+	codecs[kind] = codec
+}
+
+// JSONCodec decodes body which has not changed since the first version, or changed compatibly.
+func JSONCodec[T any, PT interface {
+	*T
+	Body
+}](version int) Codec {
+	return Codec{
+		Version: version,
+		Decode: func(_ int, data json.RawMessage) (Body, error) {
+			body := PT(new(T))
+			if len(data) == 0 {
+				return body, nil
+			}
+			if err := json.Unmarshal(data, body); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidBody, err)
+			}
+			return body, nil
+		},
+	}
+}
+
+func encodeBody(body Body) (string, int, json.RawMessage, error) {
+	if raw, ok := body.(*RawBody); ok {
+		return raw.Type, raw.Version, raw.Data, nil
+	}
+
+	codecsMu.RLock()
+	codec, ok := codecs[body.Kind()]
+	codecsMu.RUnlock()
+	if !ok {
+		return "", 0, nil, fmt.Errorf("message kind %s is not registered", body.Kind())
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return body.Kind(), codec.Version, data, nil
+}
+
+func decodeBody(kind string, version int, data json.RawMessage) (Body, error) {
+	codecsMu.RLock()
+	codec, ok := codecs[kind]
+	codecsMu.RUnlock()
+	if !ok {
+		return &RawBody{Type: kind, Version: version, Data: data}, nil
+	}
+	return codec.Decode(version, data)
+}
+
+// emptyBody returns body of the kind without any content.
+func emptyBody(kind string) Body {
+	codecsMu.RLock()
+	codec, ok := codecs[kind]
+	codecsMu.RUnlock()
+	if !ok {
+		return &RawBody{Type: kind}
+	}
+	body, _ := codec.Decode(codec.Version, nil)
+	return body
+}
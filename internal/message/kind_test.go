@@ -0,0 +1,128 @@
+package message
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestMessage_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    Body
+		wantErr error
+	}{
+		{
+			name: "Text message should be decoded",
+			data: `{"id":"m1","type":"text","version":1,"body":{"text":"Bow to me, minion!"}}`,
+			want: &TextBody{Text: "Bow to me, minion!"},
+		},
+		{
+			name: "Reaction should be decoded",
+			data: `{"type":"reaction","version":1,"body":{"messageId":"m1","emoji":"👍","remove":true}}`,
+			want: &ReactionBody{MessageID: "m1", Emoji: "👍", Remove: true},
+		},
+		{
+			name: "Message without kind should be decoded as text",
+			data: `{"id":"m1","value":"Bow to me, minion!"}`,
+			want: &TextBody{Text: "Bow to me, minion!"},
+		},
+		{
+			name: "Notification without kind should be decoded by its flag",
+			data: `{"isNotification":true,"value":"create-room"}`,
+			want: &NotificationBody{Event: CreateRoomEvent},
+		},
+		{
+			name: "Body of newer version should be decoded ignoring unknown fields",
+			data: `{"type":"text","version":7,"body":{"text":"Never!!!","format":"markdown"}}`,
+			want: &TextBody{Text: "Never!!!"},
+		},
+		{
+			name: "Body of unknown kind should be kept as is",
+			data: `{"type":"poll","version":2,"body":{"question":"Why?"}}`,
+			want: &RawBody{Type: "poll", Version: 2, Data: json.RawMessage(`{"question":"Why?"}`)},
+		},
+		{
+			name:    "Malformed body should not be decoded",
+			data:    `{"type":"text","version":1,"body":{"text":42}}`,
+			wantErr: ErrInvalidBody,
+		},
+		{
+			name:    "TTL out of range should not be decoded",
+			data:    `{"type":"text","version":1,"body":{"text":"Bow!"},"ttl":9223372036854775807}`,
+			wantErr: ErrInvalidTTL,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg := &Message{}
+			err := json.Unmarshal([]byte(tt.data), msg)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Message.UnmarshalJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && !reflect.DeepEqual(msg.Body, tt.want) {
+				t.Errorf("Message.UnmarshalJSON() body = %#v, want %#v", msg.Body, tt.want)
+			}
+		})
+	}
+}
+
+func TestMessage_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  *Message
+	}{
+		{
+			name: "Text message should survive encoding",
+			msg:  testMessage1,
+		},
+		{
+			name: "Notification should survive encoding",
+			msg:  NewNotification(testMessage1.UserID, testMessage1.User, testRoomID, testRoomName, CreateRoomEvent),
+		},
+		{
+			name: "Membership event should survive encoding",
+			msg:  NewMembership(testMessage1.UserID, testMessage1.User, testRoomID, testRoomName, JoinRoomEvent),
+		},
+		{
+			name: "Attachment should survive encoding",
+			msg: &Message{
+				ID:   "m1",
+				Body: &AttachmentBody{BlobID: "b1", Name: "shield.png", MIMEType: "image/png", Size: 1024},
+			},
+		},
+		{
+			name: "Edit should survive encoding",
+			msg:  &Message{Body: &EditBody{MessageID: "m1", Text: "Bow!"}},
+		},
+		{
+			name: "Error answering client message should survive encoding",
+			msg: &Message{
+				RoomID:   testRoomID,
+				Body:     &ErrorBody{Code: RateLimitedError, Reason: "too fast", RetryAfter: 1500},
+				ClientID: "c1",
+			},
+		},
+		{
+			name: "Message of unknown kind should survive encoding",
+			msg:  &Message{Body: &RawBody{Type: "poll", Version: 2, Data: json.RawMessage(`{"question":"Why?"}`)}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := json.Marshal(tt.msg)
+			if err != nil {
+				t.Fatalf("Message.MarshalJSON() error = %v", err)
+			}
+			got := &Message{}
+			if err := json.Unmarshal(data, got); err != nil {
+				t.Fatalf("Message.UnmarshalJSON() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.msg) {
+				t.Errorf("Message.UnmarshalJSON() = %#v, want %#v", got, tt.msg)
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package message
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MentionAction marks message delivered to mentioned users, wherever they are looking at.
+const MentionAction = "mention"
+
+// Special mentions addressing users currently online in the room, or all participants of the room.
+const (
+	HereMention = "here"
+	RoomMention = "room"
+)
+
+// Mentions lists users mentioned in the message as they were resolved when message was accepted:
+// explicitly mentioned participants of the room along with those addressed by special mentions.
+type Mentions struct {
+	UserIDs []string `json:"userIds,omitempty"`
+	Here    bool     `json:"here,omitempty"`
+	Room    bool     `json:"room,omitempty"`
+}
+
+// Mentioned tells if the user was mentioned in the message.
+func (m *Message) Mentioned(userID string) bool {
+	return m.Mentions != nil && slices.Contains(m.Mentions.UserIDs, userID)
+}
+
+// ParseMentions returns lowercased names mentioned in the text as @name, without repetitions.
+// Mention should not immediately follow a word, so that e-mail addresses are not taken for mentions.
+// Special mentions are returned as names HereMention and RoomMention.
+func ParseMentions(text string) []string {
+	var names []string
+	var prev rune
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		i += size
+		if r != '@' || isNameRune(prev) || prev == '.' {
+			prev = r
+			continue
+		}
+		prev = r
+
+		end := i
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			if !isNameRune(r) {
+				break
+			}
+			end += size
+		}
+		if end == i {
+			continue
+		}
+		name := strings.ToLower(text[i:end])
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+		i = end
+		prev, _ = utf8.DecodeLastRuneInString(name)
+	}
+	return names
+}
+
+func isNameRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-'
+}
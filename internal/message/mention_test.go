@@ -0,0 +1,142 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func TestParseMentions(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []string
+	}{
+		{
+			name: "Mentions should be found anywhere in the text",
+			text: "@Tom, ask @jerry and @spike_2.",
+			want: []string{"tom", "jerry", "spike_2"},
+		},
+		{
+			name: "Special mentions should be returned as names",
+			text: "@here @room",
+			want: []string{HereMention, RoomMention},
+		},
+		{
+			name: "Repeated mentions should be returned once",
+			text: "@tom @TOM @tom",
+			want: []string{"tom"},
+		},
+		{
+			name: "E-mail addresses should not be taken for mentions",
+			text: "tom@example.com, tom.cat@example.com",
+			want: nil,
+		},
+		{
+			name: "Lone @ should not be taken for mention",
+			text: "meet @ 5, @@",
+			want: nil,
+		},
+		{
+			name: "Mentions of non-latin names should be found",
+			text: "(@котик)",
+			want: []string{"котик"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseMentions(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseMentions() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_GetMentions(t *testing.T) {
+	const mentionedUserID = "33333333-3333-4333-8333-333333333333"
+
+	tests := []struct {
+		name     string
+		restart  bool
+		newStore func(dir string) (Store, func() error, error)
+	}{
+		{
+			name: "Mentions should be paged in memory",
+			newStore: func(string) (Store, func() error, error) {
+				s := NewInMemoryStore(RetentionPolicy{}, nil, testLogger)
+				return s, s.Close, nil
+			},
+		},
+		{
+			name:    "Mentions should be paged in file store after restart",
+			restart: true,
+			newStore: func(dir string) (Store, func() error, error) {
+				s, err := NewFileStore(dir, 0, testLogger)
+				if err != nil {
+					return nil, nil, err
+				}
+				return s, s.Close, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			s, closeStore, err := tt.newStore(dir)
+			if err != nil {
+				t.Fatalf("newStore() error = %v", err)
+			}
+
+			// Mentions of the user are interleaved with other messages of the room.
+			mentions := []*Message{}
+			for i := 0; i < 5; i++ {
+				mention := *testMessage1
+				mention.ID = fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+				mention.Seq = uint64(2*i + 1)
+				mention.Mentions = &Mentions{UserIDs: []string{mentionedUserID}}
+				other := *testMessage2
+				other.ID = fmt.Sprintf("00000000-0000-4000-9000-%012d", i)
+				other.Seq = uint64(2*i + 2)
+				for _, msg := range []*Message{&mention, &other} {
+					if err := s.SaveMessage(ctx, testRoomID, msg); err != nil {
+						t.Fatalf("Store.SaveMessage() error = %v", err)
+					}
+				}
+				mentions = append(mentions, &mention)
+			}
+
+			if tt.restart {
+				closeStore()
+				if s, closeStore, err = tt.newStore(dir); err != nil {
+					t.Fatalf("newStore() on restart error = %v", err)
+				}
+			}
+			defer closeStore()
+
+			latest, err := s.GetMentions(ctx, testRoomID, mentionedUserID, PageRequest{Limit: 3})
+			if err != nil {
+				t.Fatalf("Store.GetMentions() error = %v", err)
+			}
+			if !reflect.DeepEqual(latest.Messages, mentions[2:]) || latest.Prev == "" || latest.Next != "" {
+				t.Errorf("Store.GetMentions() = %v, want latest mentions %v", latest, mentions[2:])
+			}
+			older, err := s.GetMentions(ctx, testRoomID, mentionedUserID, PageRequest{Limit: 3, Before: latest.Prev})
+			if err != nil {
+				t.Fatalf("Store.GetMentions() error = %v", err)
+			}
+			if !reflect.DeepEqual(older.Messages, mentions[:2]) || older.Prev != "" || older.Next == "" {
+				t.Errorf("Store.GetMentions() = %v, want older mentions %v", older, mentions[:2])
+			}
+
+			none, err := s.GetMentions(ctx, testRoomID, testMessage1.UserID, PageRequest{})
+			if err != nil {
+				t.Fatalf("Store.GetMentions() error = %v", err)
+			}
+			if len(none.Messages) != 0 {
+				t.Errorf("Store.GetMentions() of user not mentioned = %v, want none", none)
+			}
+		})
+	}
+}
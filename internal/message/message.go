@@ -1,15 +1,210 @@
 package message
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// maxTTLSeconds is the longest TTL in seconds, as TTL is sent in whole seconds and kept as time.Duration.
+const maxTTLSeconds = math.MaxInt64 / int64(time.Second)
+
+var ErrInvalidTTL = errors.New("message TTL is out of range")
 
 // Message represents main object of exchange between users which is published in the rooms.
-// Some of messages can be marked as notifications for housekeeping and letting users know
-// on what's going on with other users or the system.
+// It is an envelope common for all kinds of messages: what is actually sent is the Body.
 // Notifications can be treated separately from messages, e.g. not published in the rooms.
+// Action marks message broadcasted as a new version of the message with the same ID.
 type Message struct {
-	User           string    `json:"user"`
-	Room           string    `json:"room"`
-	IsNotification bool      `json:"isNotification"`
-	Value          string    `json:"value"`
-	ServerTime     time.Time `json:"serverTime"`
+	// Accepted message gets unique ID and sequence number, which strictly increases within the room,
+	// so clients can rely on it for ordering, deduplication and resuming.
+	ID  string
+	Seq uint64
+	// User and room are referenced by their stable IDs; names are kept for display only
+	// and reflect the state at the moment message was sent.
+	UserID     string
+	User       string
+	RoomID     string
+	Room       string
+	ServerTime time.Time
+	// A text, a notification for housekeeping and letting users know on what's going on
+	// with other users or the system, or a request to edit or react to another message.
+	Body Body
+
+	// Reply references root message of its thread; root keeps summary of its replies.
+	ParentID string
+	Thread   *Thread
+
+	// Emoji mapped to IDs of users who reacted with it, in order of reacting.
+	Reactions map[string][]string
+
+	// Users mentioned in the text, resolved when message is accepted.
+	Mentions *Mentions
+
+	// Client asks for ephemeral message by its TTL, which is sent in whole seconds;
+	// accepted message is removed from history at ExpiresAt.
+	TTL       time.Duration
+	ExpiresAt *time.Time
+
+	Action string
+	// Author can edit or delete message later; stored message keeps its previous texts,
+	// deleted one remains in history as a tombstone without any content.
+	EditedAt  *time.Time
+	Revisions []Revision
+	DeletedAt *time.Time
+
+	// Assigned by the client to the message it sends, and only returned to that client
+	// in the ack or error answering the message; it is neither stored nor broadcasted.
+	ClientID string
+
+	// Set for placeholder which keeps position of the removed message in history.
+	removed bool
+}
+
+// envelope is a wire format of the message.
+// Body is encoded along with its kind and schema version, so that it is decoded with the right codec.
+// Value and IsNotification are kept for clients not aware of kinds;
+// message without kind is decoded from them as well, e.g. when stored before kinds were introduced.
+type envelope struct {
+	ID         string          `json:"id"`
+	Seq        uint64          `json:"seq"`
+	Type       string          `json:"type"`
+	Version    int             `json:"version"`
+	UserID     string          `json:"userId"`
+	User       string          `json:"user"`
+	RoomID     string          `json:"roomId"`
+	Room       string          `json:"room"`
+	ServerTime time.Time       `json:"serverTime"`
+	Body       json.RawMessage `json:"body,omitempty"`
+
+	IsNotification bool   `json:"isNotification"`
+	Value          string `json:"value"`
+
+	ParentID string  `json:"parentId,omitempty"`
+	Thread   *Thread `json:"thread,omitempty"`
+
+	Reactions map[string][]string `json:"reactions,omitempty"`
+
+	Mentions *Mentions `json:"mentions,omitempty"`
+
+	// Lifetime of the message in seconds.
+	TTL       int64      `json:"ttl,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+
+	Action    string     `json:"action,omitempty"`
+	EditedAt  *time.Time `json:"editedAt,omitempty"`
+	Revisions []Revision `json:"revisions,omitempty"`
+	DeletedAt *time.Time `json:"deletedAt,omitempty"`
+
+	Removed bool `json:"removed,omitempty"`
+
+	ClientID string `json:"clientId,omitempty"`
+}
+
+// Type returns kind of the message body.
+func (m *Message) Type() string {
+	if m.Body == nil {
+		return ""
+	}
+	return m.Body.Kind()
+}
+
+// IsNotification tells if message is a system notification.
+func (m *Message) IsNotification() bool {
+	return m.Type() == NotificationKind
+}
+
+// Text returns text of the text message, empty for other kinds.
+func (m *Message) Text() string {
+	if body, ok := m.Body.(*TextBody); ok {
+		return body.Text
+	}
+	return ""
+}
+
+func (m *Message) MarshalJSON() ([]byte, error) {
+	e := &envelope{
+		ID:         m.ID,
+		Seq:        m.Seq,
+		UserID:     m.UserID,
+		User:       m.User,
+		RoomID:     m.RoomID,
+		Room:       m.Room,
+		ServerTime: m.ServerTime,
+		ParentID:   m.ParentID,
+		Thread:     m.Thread,
+		Reactions:  m.Reactions,
+		Mentions:   m.Mentions,
+		TTL:        int64(m.TTL / time.Second),
+		ExpiresAt:  m.ExpiresAt,
+		Action:     m.Action,
+		EditedAt:   m.EditedAt,
+		Revisions:  m.Revisions,
+		DeletedAt:  m.DeletedAt,
+		Removed:    m.removed,
+		ClientID:   m.ClientID,
+	}
+	if m.Body != nil {
+		var err error
+		if e.Type, e.Version, e.Body, err = encodeBody(m.Body); err != nil {
+			return nil, err
+		}
+	}
+	switch body := m.Body.(type) {
+	case *TextBody:
+		e.Value = body.Text
+	case *NotificationBody:
+		e.Value = body.Event
+		e.IsNotification = true
+	}
+	return json.Marshal(e)
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	e := &envelope{}
+	if err := json.Unmarshal(data, e); err != nil {
+		return err
+	}
+
+	var body Body
+	switch {
+	case e.Type != "":
+		var err error
+		if body, err = decodeBody(e.Type, e.Version, e.Body); err != nil {
+			return err
+		}
+	case e.IsNotification:
+		body = &NotificationBody{Event: e.Value}
+	default:
+		body = &TextBody{Text: e.Value}
+	}
+
+	if e.TTL > maxTTLSeconds || e.TTL < -maxTTLSeconds {
+		return ErrInvalidTTL
+	}
+
+	*m = Message{
+		ID:         e.ID,
+		Seq:        e.Seq,
+		UserID:     e.UserID,
+		User:       e.User,
+		RoomID:     e.RoomID,
+		Room:       e.Room,
+		ServerTime: e.ServerTime,
+		Body:       body,
+		ParentID:   e.ParentID,
+		Thread:     e.Thread,
+		Reactions:  e.Reactions,
+		Mentions:   e.Mentions,
+		TTL:        time.Duration(e.TTL) * time.Second,
+		ExpiresAt:  e.ExpiresAt,
+		Action:     e.Action,
+		EditedAt:   e.EditedAt,
+		Revisions:  e.Revisions,
+		DeletedAt:  e.DeletedAt,
+		ClientID:   e.ClientID,
+		removed:    e.Removed,
+	}
+	return nil
 }
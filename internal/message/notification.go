@@ -1,12 +1,28 @@
 package message
 
-const CreateRoomEvent = "create-room"
+const (
+	CreateRoomEvent = "create-room"
+	RenameRoomEvent = "rename-room"
+	RenameUserEvent = "rename-user"
+)
 
-func NewNotification(user, room, event string) *Message {
+func NewNotification(userID, user, roomID, room, event string) *Message {
 	return &Message{
-		User:           user,
-		Room:           room,
-		Value:          event,
-		IsNotification: true,
+		UserID: userID,
+		User:   user,
+		RoomID: roomID,
+		Room:   room,
+		Body:   &NotificationBody{Event: event},
+	}
+}
+
+// NewMembership creates event of user joining or leaving the room.
+func NewMembership(userID, user, roomID, room, event string) *Message {
+	return &Message{
+		UserID: userID,
+		User:   user,
+		RoomID: roomID,
+		Room:   room,
+		Body:   &MembershipBody{Event: event},
 	}
 }
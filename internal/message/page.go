@@ -0,0 +1,88 @@
+package message
+
+import (
+	"errors"
+	"strconv"
+)
+
+var ErrInvalidCursor = errors.New("invalid history cursor")
+
+// PageRequest selects a window of room history.
+// Cursors are opaque strings taken from previously returned pages.
+// If After is set, page starts right after that message and goes forward;
+// otherwise page ends right before Before message (or at the latest message) and goes back.
+// Limit caps number of messages in the page, zero means no limit.
+type PageRequest struct {
+	Limit  int
+	Before string
+	After  string
+}
+
+// Page is a window of room history in chronological order.
+// Prev is set if there are older messages and should be passed as Before to get them;
+// Next is set if there are newer messages and should be passed as After.
+type Page struct {
+	Messages []*Message `json:"messages"`
+	Prev     string     `json:"prev,omitempty"`
+	Next     string     `json:"next,omitempty"`
+}
+
+// Cursor of the message is its position in the room history.
+func encodeCursor(pos int) string {
+	return strconv.Itoa(pos)
+}
+
+func decodeCursor(cursor string) (int, error) {
+	pos, err := strconv.Atoi(cursor)
+	if err != nil || pos < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return pos, nil
+}
+
+// pageBounds resolves request into [from, to) range of positions
+// for the history holding messages in [first, end) positions.
+func pageBounds(req PageRequest, first, end int) (int, int, error) {
+	from, to := first, end
+	if req.Before != "" {
+		before, err := decodeCursor(req.Before)
+		if err != nil {
+			return 0, 0, err
+		}
+		// Cursor could point before the oldest message, e.g. after eviction.
+		to = max(min(to, before), first)
+	}
+	if req.After != "" {
+		after, err := decodeCursor(req.After)
+		if err != nil {
+			return 0, 0, err
+		}
+		from = min(max(from, after+1), end)
+	}
+	if from > to {
+		from = to
+	}
+
+	if req.Limit > 0 && to-from > req.Limit {
+		if req.After != "" {
+			to = from + req.Limit
+		} else {
+			from = to - req.Limit
+		}
+	}
+	return from, to, nil
+}
+
+// newPage wraps messages at [from, to) positions of the history holding [first, end) positions.
+func newPage(messages []*Message, from, to, first, end int) *Page {
+	page := &Page{
+		Messages: messages,
+	}
+	if from > first {
+		page.Prev = encodeCursor(from)
+	}
+	if to < end && to > 0 {
+		page.Next = encodeCursor(to - 1)
+	}
+	return page
+}
@@ -0,0 +1,65 @@
+package message
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Actions of messages broadcasted with updated reactions.
+const (
+	ReactAction   = "react"
+	UnreactAction = "unreact"
+)
+
+// Emoji can be composed of several code points, e.g. with skin tone modifiers or joiners.
+const maxReactionLength = 32
+
+var ErrInvalidReaction = errors.New("reaction should be a single emoji")
+
+// ValidateReaction checks that reaction looks like an emoji rather than arbitrary text.
+func ValidateReaction(emoji string) error {
+	if emoji == "" || len(emoji) > maxReactionLength || !utf8.ValidString(emoji) ||
+		strings.ContainsFunc(emoji, func(r rune) bool { return r < utf8.RuneSelf }) {
+		return ErrInvalidReaction
+	}
+	return nil
+}
+
+// React returns new version of the message with the user's reaction added.
+// Message is returned as is if the user has already reacted with the same emoji.
+func (m *Message) React(emoji string, userID string) *Message {
+	if slices.Contains(m.Reactions[emoji], userID) {
+		return m
+	}
+	reacted := *m
+	reacted.Action = ""
+	reacted.Reactions = maps.Clone(m.Reactions)
+	if reacted.Reactions == nil {
+		reacted.Reactions = make(map[string][]string)
+	}
+	reacted.Reactions[emoji] = append(slices.Clone(m.Reactions[emoji]), userID)
+	return &reacted
+}
+
+// Unreact returns new version of the message without the user's reaction.
+// Message is returned as is if there is no such reaction.
+func (m *Message) Unreact(emoji string, userID string) *Message {
+	i := slices.Index(m.Reactions[emoji], userID)
+	if i < 0 {
+		return m
+	}
+	unreacted := *m
+	unreacted.Action = ""
+	unreacted.Reactions = maps.Clone(m.Reactions)
+	unreacted.Reactions[emoji] = slices.Delete(slices.Clone(m.Reactions[emoji]), i, i+1)
+	if len(unreacted.Reactions[emoji]) == 0 {
+		delete(unreacted.Reactions, emoji)
+	}
+	if len(unreacted.Reactions) == 0 {
+		unreacted.Reactions = nil
+	}
+	return &unreacted
+}
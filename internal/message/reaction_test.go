@@ -0,0 +1,95 @@
+package message
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateReaction(t *testing.T) {
+	tests := []struct {
+		name    string
+		emoji   string
+		wantErr bool
+	}{
+		{
+			name:    "Single emoji should be valid",
+			emoji:   "👍",
+			wantErr: false,
+		},
+		{
+			name:    "Emoji with skin tone modifier should be valid",
+			emoji:   "👍🏽",
+			wantErr: false,
+		},
+		{
+			name:    "Empty reaction should be invalid",
+			emoji:   "",
+			wantErr: true,
+		},
+		{
+			name:    "Text should be invalid",
+			emoji:   "like",
+			wantErr: true,
+		},
+		{
+			name:    "Too long sequence should be invalid",
+			emoji:   "👍👍👍👍👍👍👍👍👍",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateReaction(tt.emoji); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateReaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMessage_React(t *testing.T) {
+	ultron, jarvis := testMessage1.UserID, testMessage2.UserID
+
+	tests := []struct {
+		name string
+		msg  *Message
+		want map[string][]string
+	}{
+		{
+			name: "Reactions should be grouped by emoji in order of reacting",
+			msg:  testMessage1.React("👍", ultron).React("🔥", jarvis).React("👍", jarvis),
+			want: map[string][]string{
+				"👍": {ultron, jarvis},
+				"🔥": {jarvis},
+			},
+		},
+		{
+			name: "Repeated reaction of the same user should be counted once",
+			msg:  testMessage1.React("👍", ultron).React("👍", ultron),
+			want: map[string][]string{
+				"👍": {ultron},
+			},
+		},
+		{
+			name: "Removed reaction should not be listed",
+			msg:  testMessage1.React("👍", ultron).React("👍", jarvis).Unreact("👍", ultron),
+			want: map[string][]string{
+				"👍": {jarvis},
+			},
+		},
+		{
+			name: "Emoji without reactions left should not be listed",
+			msg:  testMessage1.React("👍", ultron).Unreact("👍", ultron).Unreact("🔥", jarvis),
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.msg.Reactions, tt.want) {
+				t.Errorf("Message.Reactions = %v, want %v", tt.msg.Reactions, tt.want)
+			}
+			if testMessage1.Reactions != nil {
+				t.Errorf("Message.React() modified original message %v", testMessage1)
+			}
+		})
+	}
+}
@@ -0,0 +1,43 @@
+package message
+
+import (
+	"context"
+	"slices"
+)
+
+// replayPageLimit is a number of messages fetched from the store at once while looking for missed ones.
+const replayPageLimit = 100
+
+// Missed returns messages of the room with sequence numbers in (after, until] range, oldest first.
+// History is walked back from the latest page, so the cost depends on the number of missed messages
+// rather than on the size of the history.
+func Missed(ctx context.Context, s Store, roomID string, after, until uint64) ([]*Message, error) {
+	missed := []*Message{}
+	if after >= until {
+		return missed, nil
+	}
+	req := PageRequest{Limit: replayPageLimit}
+	for {
+		page, err := s.GetMessages(ctx, roomID, req)
+		if err != nil {
+			return nil, err
+		}
+		done := page.Prev == ""
+		for i := len(page.Messages) - 1; i >= 0; i-- {
+			msg := page.Messages[i]
+			if msg.Seq <= after {
+				done = true
+				break
+			}
+			if msg.Seq <= until {
+				missed = append(missed, msg)
+			}
+		}
+		if done {
+			break
+		}
+		req.Before = page.Prev
+	}
+	slices.Reverse(missed)
+	return missed, nil
+}
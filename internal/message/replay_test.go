@@ -0,0 +1,84 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestMissed(t *testing.T) {
+	ctx := context.Background()
+	s := NewInMemoryStore(RetentionPolicy{}, nil, testLogger)
+	defer s.Close()
+	// History spans several pages, so that missed messages are collected across them.
+	for i := 1; i <= 2*replayPageLimit+50; i++ {
+		msg := *testMessage1
+		msg.ID = fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+		msg.Seq = uint64(i)
+		if err := s.SaveMessage(ctx, testRoomID, &msg); err != nil {
+			t.Fatalf("Store.SaveMessage() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		after    uint64
+		until    uint64
+		wantFrom uint64
+		wantTo   uint64
+	}{
+		{
+			name:     "Messages after the acknowledged one should be returned oldest first",
+			after:    240,
+			until:    250,
+			wantFrom: 241,
+			wantTo:   250,
+		},
+		{
+			name:     "Messages across several pages should be returned",
+			after:    10,
+			until:    250,
+			wantFrom: 11,
+			wantTo:   250,
+		},
+		{
+			name:     "Messages newer than the upper bound should not be returned",
+			after:    100,
+			until:    120,
+			wantFrom: 101,
+			wantTo:   120,
+		},
+		{
+			name:     "Whole history should be returned if nothing was acknowledged",
+			after:    0,
+			until:    250,
+			wantFrom: 1,
+			wantTo:   250,
+		},
+		{
+			name:  "Nothing should be returned if everything was acknowledged",
+			after: 250,
+			until: 250,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Missed(ctx, s, testRoomID, tt.after, tt.until)
+			if err != nil {
+				t.Fatalf("Missed() error = %v", err)
+			}
+			want := 0
+			if tt.wantTo > 0 {
+				want = int(tt.wantTo - tt.wantFrom + 1)
+			}
+			if len(got) != want {
+				t.Fatalf("Missed() returned %d messages, want %d", len(got), want)
+			}
+			for i, msg := range got {
+				if msg.Seq != tt.wantFrom+uint64(i) {
+					t.Fatalf("Missed()[%d].Seq = %d, want %d", i, msg.Seq, tt.wantFrom+uint64(i))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package message
+
+import (
+	"context"
+	"time"
+)
+
+// RetentionPolicy limits history kept for a room.
+// Oldest messages are evicted as soon as any limit is exceeded; zero limit means no limit.
+type RetentionPolicy struct {
+	MaxCount int
+	MaxAge   time.Duration
+	MaxBytes int
+}
+
+// EvictionStats counts messages evicted from history and their approximate size.
+type EvictionStats struct {
+	Messages int64
+	Bytes    int64
+}
+
+// RetentionStore is a Store which bounds history of the rooms.
+type RetentionStore interface {
+	Store
+	SetRetention(roomID string, policy RetentionPolicy)
+	Retention(roomID string) (RetentionPolicy, EvictionStats)
+	OnEvict(hook EvictionHook)
+}
+
+// EvictionHook is told about messages evicted from history of the room, e.g. to stop indexing them.
+// It is called with the store locked, so it must not call the store.
+type EvictionHook func(roomID string, evicted []*Message)
+
+// exceeded tells if the oldest message of the history has to be evicted.
+func (p RetentionPolicy) exceeded(count, bytes int, oldest *Message, now time.Time) bool {
+	return p.MaxCount > 0 && count > p.MaxCount ||
+		p.MaxBytes > 0 && bytes > p.MaxBytes ||
+		p.MaxAge > 0 && now.Sub(oldest.ServerTime) > p.MaxAge
+}
+
+// messageSize approximates memory taken by the message.
+func messageSize(msg *Message) int {
+	const overhead = 64 // Fixed size fields and pointers.
+	size := overhead + len(msg.ID) + len(msg.UserID) + len(msg.User) +
+		len(msg.RoomID) + len(msg.Room) + len(msg.Text())
+	for _, revision := range msg.Revisions {
+		size += overhead + len(revision.Text)
+	}
+	for emoji, userIDs := range msg.Reactions {
+		size += overhead + len(emoji)
+		for _, userID := range userIDs {
+			size += len(userID)
+		}
+	}
+	return size
+}
+
+// RunSweeper periodically evicts messages which are out of retention limits,
+// mostly by age, since count and size limits are also enforced on saves.
+// It is supposed to run as goroutine.
+func (s *InMemoryStore) RunSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case now := <-ticker.C:
+			evicted := s.sweep(now)
+			if evicted.Messages > 0 {
+				total := s.Evicted()
+				s.logger.Printf("Retention sweep evicted %d messages (%d bytes), %d messages (%d bytes) in total.\n",
+					evicted.Messages, evicted.Bytes, total.Messages, total.Bytes)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+func (s *InMemoryStore) sweep(now time.Time) EvictionStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var evicted EvictionStats
+	for roomID := range s.history {
+		stats := s.evict(roomID, now)
+		evicted.Messages += stats.Messages
+		evicted.Bytes += stats.Bytes
+	}
+	return evicted
+}
+
+// evict drops oldest messages of the room while they are out of retention limits,
+// moving them to archive if there is one. Messages which archive fails to take are not dropped.
+// Each eviction takes constant time. Caller must hold the lock.
+func (s *InMemoryStore) evict(roomID string, now time.Time) EvictionStats {
+	policy := s.defaultRetention
+	state := s.roomState(roomID)
+	if state.policy != nil {
+		policy = *state.policy
+	}
+
+	var evicted EvictionStats
+	history := s.history[roomID]
+	for int(evicted.Messages) < len(history) &&
+		policy.exceeded(len(history)-int(evicted.Messages), state.bytes-int(evicted.Bytes), history[evicted.Messages], now) {
+		evicted.Messages++
+		evicted.Bytes += int64(messageSize(history[evicted.Messages-1]))
+	}
+	if evicted.Messages == 0 {
+		return evicted
+	}
+
+	if s.archive != nil {
+		if err := s.archive.Append(roomID, state.first, archived(history[:evicted.Messages])); err != nil {
+			// Messages are kept in memory until archive takes them, on the next save or sweep.
+			s.logger.Printf("Evicted messages of room %s could not be archived, keeping them: %v\n", roomID, err)
+			return EvictionStats{}
+		}
+	}
+	state.bytes -= int(evicted.Bytes)
+	if s.onEvict != nil {
+		s.onEvict(roomID, history[:evicted.Messages])
+	}
+	for _, msg := range history[:evicted.Messages] {
+		delete(state.positions, msg.ID)
+		delete(state.threads, msg.ID)
+	}
+	// Release references, so that messages are collected even before slice is reallocated.
+	clear(history[:evicted.Messages])
+	s.history[roomID] = history[evicted.Messages:]
+	state.first += int(evicted.Messages)
+	state.evicted.Messages += evicted.Messages
+	state.evicted.Bytes += evicted.Bytes
+	s.evicted.Messages += evicted.Messages
+	s.evicted.Bytes += evicted.Bytes
+	return evicted
+}
+
+// SetRetention overrides default retention policy for the room.
+// New limits are enforced on the next save or sweep.
+func (s *InMemoryStore) SetRetention(roomID string, policy RetentionPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.roomState(roomID).policy = &policy
+}
+
+// OnEvict sets hook told about messages evicted from history.
+// It is supposed to be called before messages are saved.
+func (s *InMemoryStore) OnEvict(hook EvictionHook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onEvict = hook
+}
+
+// Retention returns policy in effect for the room and number of messages evicted from it.
+func (s *InMemoryStore) Retention(roomID string) (RetentionPolicy, EvictionStats) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state := s.roomState(roomID)
+	if state.policy != nil {
+		return *state.policy, state.evicted
+	}
+	return s.defaultRetention, state.evicted
+}
+
+// Evicted returns number of messages evicted from all rooms.
+func (s *InMemoryStore) Evicted() EvictionStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.evicted
+}
@@ -0,0 +1,95 @@
+package message
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInMemoryStore_Eviction(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name        string
+		policy      RetentionPolicy
+		sweepAt     time.Time
+		wantSeqs    []uint64
+		wantEvicted int64
+	}{
+		{
+			name:        "History without limits should be kept whole",
+			policy:      RetentionPolicy{},
+			wantSeqs:    []uint64{1, 2, 3, 4, 5},
+			wantEvicted: 0,
+		},
+		{
+			name:        "Oldest messages over count limit should be evicted on save",
+			policy:      RetentionPolicy{MaxCount: 2},
+			wantSeqs:    []uint64{4, 5},
+			wantEvicted: 3,
+		},
+		{
+			name:        "Oldest messages over size limit should be evicted on save",
+			policy:      RetentionPolicy{MaxBytes: 3 * messageSize(testMessage1)},
+			wantSeqs:    []uint64{3, 4, 5},
+			wantEvicted: 2,
+		},
+		{
+			name:        "Messages over age limit should be evicted by sweep",
+			policy:      RetentionPolicy{MaxAge: time.Hour},
+			sweepAt:     now.Add(time.Hour + time.Minute + 30*time.Second),
+			wantSeqs:    []uint64{3, 4, 5},
+			wantEvicted: 2,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewInMemoryStore(RetentionPolicy{}, nil, testLogger)
+			s.SetRetention(testRoomID, tt.policy)
+			var hooked int64
+			s.OnEvict(func(roomID string, evicted []*Message) {
+				hooked += int64(len(evicted))
+			})
+			for i := 0; i < 5; i++ {
+				msg := *testMessage1
+				msg.Seq = uint64(i + 1)
+				msg.ServerTime = now.Add(time.Duration(i) * time.Minute)
+				s.SaveMessage(ctx, testRoomID, &msg)
+			}
+			if !tt.sweepAt.IsZero() {
+				s.sweep(tt.sweepAt)
+			}
+
+			page, err := s.GetMessages(ctx, testRoomID, PageRequest{})
+			if err != nil {
+				t.Fatalf("InMemoryStore.GetMessages() error = %v", err)
+			}
+			gotSeqs := []uint64{}
+			for _, msg := range page.Messages {
+				gotSeqs = append(gotSeqs, msg.Seq)
+			}
+			if !reflect.DeepEqual(gotSeqs, tt.wantSeqs) {
+				t.Errorf("retained messages = %v, want %v", gotSeqs, tt.wantSeqs)
+			}
+			// Evicted messages should not be offered for scrolling back.
+			if page.Prev != "" {
+				t.Errorf("whole history page prev cursor = %q, want none", page.Prev)
+			}
+			// Cursors of retained messages should not shift with eviction.
+			older, err := s.GetMessages(ctx, testRoomID, PageRequest{Limit: 1, Before: encodeCursor(4)})
+			if err != nil || len(older.Messages) != 1 || older.Messages[0].Seq != 4 {
+				t.Errorf("page before cursor 4 = %v, %v, want message #4", older, err)
+			}
+			if _, evicted := s.Retention(testRoomID); evicted.Messages != tt.wantEvicted {
+				t.Errorf("evicted messages = %d, want %d", evicted.Messages, tt.wantEvicted)
+			}
+			if hooked != tt.wantEvicted {
+				t.Errorf("messages passed to eviction hook = %d, want %d", hooked, tt.wantEvicted)
+			}
+			if seq, _ := s.LastSeq(ctx, testRoomID); seq != 5 {
+				t.Errorf("InMemoryStore.LastSeq() = %d, want 5", seq)
+			}
+		})
+	}
+}
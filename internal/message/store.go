@@ -2,45 +2,345 @@ package message
 
 import (
 	"context"
+	"log"
+	"sort"
 	"sync"
+	"time"
 )
 
 // Store is intended to provide message retention.
-// Message history is stored in chronological order by rooms.
+// Message history is stored in chronological order by room IDs
+// and is retrieved by pages.
 type Store interface {
-	GetMessages(ctx context.Context, roomName string) ([]*Message, error)
-	SaveMessage(ctx context.Context, roomName string, msg *Message) error
+	GetMessages(ctx context.Context, roomID string, req PageRequest) (*Page, error)
+	SaveMessage(ctx context.Context, roomID string, msg *Message) error
+	// LastSeq returns sequence number of the latest message in the room, zero if there are none.
+	LastSeq(ctx context.Context, roomID string) (uint64, error)
+	// GetMessage returns stored message by its ID or ErrMessageNotFound.
+	GetMessage(ctx context.Context, roomID string, msgID string) (*Message, error)
+	// ReplaceMessage stores new version of the message with the same ID, keeping its position in history.
+	ReplaceMessage(ctx context.Context, roomID string, msg *Message) error
+	// GetThread returns page of replies to the root message in chronological order.
+	// Cursors of thread pages are only valid for the same thread.
+	GetThread(ctx context.Context, roomID string, rootID string, req PageRequest) (*Page, error)
+	// GetMentions returns page of messages mentioning the user in chronological order.
+	// Cursors of mention pages are only valid for the same user.
+	GetMentions(ctx context.Context, roomID string, userID string, req PageRequest) (*Page, error)
+	// RemoveMessage erases the message from history for good, e.g. when it expires.
+	// Positions of other messages stay the same, and removed message is skipped on reads.
+	RemoveMessage(ctx context.Context, roomID string, msgID string) error
 }
 
+// InMemoryStore keeps history of the rooms in memory within limits of retention policies.
+// If archive is set, evicted messages are moved there and history reads go through to it.
+// Ephemeral messages are archived as placeholders, so they never outlive retained history.
 type InMemoryStore struct {
 	history map[string][]*Message
-	mu      sync.RWMutex
+	rooms   map[string]*roomState
+	archive *Archive
+
+	defaultRetention RetentionPolicy
+	evicted          EvictionStats
+	onEvict          EvictionHook
+
+	mu sync.RWMutex
+
+	logger *log.Logger
+}
+
+// roomState tracks retention of the room history.
+type roomState struct {
+	// Position of the oldest retained message, that is number of evicted messages.
+	// Positions of messages do not change with eviction, so cursors stay valid.
+	first   int
+	bytes   int
+	lastSeq uint64
+	evicted EvictionStats
+	// Positions of retained messages by their IDs.
+	positions map[string]int
+	// Positions of replies by IDs of their retained root messages.
+	threads map[string][]int
+	// Positions of messages by IDs of users mentioned in them.
+	mentions map[string][]int
+	// Policy overriding the default one, if any.
+	policy *RetentionPolicy
 }
 
-func NewInMemoryStore() Store {
+// NewInMemoryStore creates store with default retention policy for the rooms.
+// Archive is optional, without it evicted messages are dropped.
+func NewInMemoryStore(defaultRetention RetentionPolicy, archive *Archive, logger *log.Logger) *InMemoryStore {
 	return &InMemoryStore{
-		history: make(map[string][]*Message),
+		history:          make(map[string][]*Message),
+		rooms:            make(map[string]*roomState),
+		archive:          archive,
+		defaultRetention: defaultRetention,
+		logger:           logger,
 	}
 }
 
-func (s *InMemoryStore) GetMessages(ctx context.Context, roomName string) ([]*Message, error) {
+func (s *InMemoryStore) GetMessages(ctx context.Context, roomID string, req PageRequest) (*Page, error) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return s.history[roomName], nil
+	history := s.history[roomID]
+	first := 0
+	if state, ok := s.rooms[roomID]; ok {
+		first = state.first
+	} else if s.archive != nil {
+		_, first = s.archive.Bounds(roomID)
+	}
+	end := first + len(history)
+
+	// Archived messages precede those in memory.
+	oldest := first
+	if s.archive != nil {
+		if archivedFirst, archivedEnd := s.archive.Bounds(roomID); archivedEnd > archivedFirst {
+			oldest = archivedFirst
+		}
+	}
+
+	from, to, err := pageBounds(req, oldest, end)
+	if err != nil {
+		return nil, err
+	}
+	messages := make([]*Message, 0, to-from)
+	if from < first {
+		archived, err := s.archive.Read(roomID, from, min(to, first))
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, archived...)
+	}
+	if to > first {
+		messages = append(messages, history[max(from, first)-first:to-first]...)
+	}
+	return newPage(visible(messages), from, to, oldest, end), nil
 }
 
-func (s *InMemoryStore) SaveMessage(ctx context.Context, roomName string, msg *Message) error {
+func (s *InMemoryStore) SaveMessage(ctx context.Context, roomID string, msg *Message) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	// TODO: implement eviction to control memory usage.
-	// Simplest implementation would require using linked list of messages instead of array
-	// to maintain constant maximum size of history for each room and control it in O(1).
-	if _, ok := s.history[roomName]; !ok {
-		s.history[roomName] = []*Message{msg}
+	if _, ok := s.history[roomID]; !ok {
+		s.history[roomID] = []*Message{msg}
 	} else {
-		s.history[roomName] = append(s.history[roomName], msg)
+		s.history[roomID] = append(s.history[roomID], msg)
+	}
+	state := s.roomState(roomID)
+	state.bytes += messageSize(msg)
+	state.lastSeq = msg.Seq
+	if msg.ID != "" {
+		if state.positions == nil {
+			state.positions = make(map[string]int)
+		}
+		state.positions[msg.ID] = state.first + len(s.history[roomID]) - 1
+	}
+	if msg.IsReply() {
+		if state.threads == nil {
+			state.threads = make(map[string][]int)
+		}
+		state.threads[msg.ParentID] = append(state.threads[msg.ParentID], state.first+len(s.history[roomID])-1)
+	}
+	if msg.Mentions != nil {
+		if state.mentions == nil {
+			state.mentions = make(map[string][]int)
+		}
+		for _, userID := range msg.Mentions.UserIDs {
+			state.mentions[userID] = append(state.mentions[userID], state.first+len(s.history[roomID])-1)
+		}
+	}
+
+	s.evict(roomID, time.Now())
+	return nil
+}
+
+func (s *InMemoryStore) LastSeq(ctx context.Context, roomID string) (uint64, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	history := s.history[roomID]
+	if len(history) == 0 {
+		// All messages could be evicted, but sequence should not start over.
+		if state, ok := s.rooms[roomID]; ok {
+			return state.lastSeq, nil
+		}
+		if s.archive != nil {
+			return s.archive.LastSeq(roomID)
+		}
+		return 0, nil
+	}
+	return history[len(history)-1].Seq, nil
+}
+
+// GetMessage looks for the message among retained ones, then in archive if there is one.
+func (s *InMemoryStore) GetMessage(ctx context.Context, roomID string, msgID string) (*Message, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	i, ok := s.index(roomID, msgID)
+	if !ok {
+		if s.archive != nil {
+			return s.archive.Find(roomID, msgID)
+		}
+		return nil, ErrMessageNotFound
+	}
+	return s.history[roomID][i], nil
+}
+
+// notRetained tells why the message is not among retained ones: it is either archived or not found at all.
+// Caller must hold the lock.
+func (s *InMemoryStore) notRetained(roomID string, msgID string) error {
+	if s.archive != nil {
+		if _, err := s.archive.Find(roomID, msgID); err == nil {
+			return ErrMessageArchived
+		}
+	}
+	return ErrMessageNotFound
+}
+
+// ReplaceMessage swaps retained message for its new version; archived messages are not changed.
+// Stored messages are shared with readers, so they are never modified in place.
+func (s *InMemoryStore) ReplaceMessage(ctx context.Context, roomID string, msg *Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.index(roomID, msg.ID)
+	if !ok {
+		return s.notRetained(roomID, msg.ID)
+	}
+	history := s.history[roomID]
+	state := s.rooms[roomID]
+	state.bytes += messageSize(msg) - messageSize(history[i])
+	history[i] = msg
+	return nil
+}
+
+// RemoveMessage swaps retained message for its placeholder; archived messages are not removed.
+// Replies and mentions keep their positions, so that cursors stay valid, but placeholder is skipped.
+func (s *InMemoryStore) RemoveMessage(ctx context.Context, roomID string, msgID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i, ok := s.index(roomID, msgID)
+	if !ok {
+		return s.notRetained(roomID, msgID)
 	}
+	history := s.history[roomID]
+	state := s.rooms[roomID]
+	placeholder := history[i].placeholder()
+	state.bytes += messageSize(placeholder) - messageSize(history[i])
+	history[i] = placeholder
+	delete(state.positions, msgID)
+	delete(state.threads, msgID)
 	return nil
 }
+
+// GetThread pages through retained replies; root message has to be retained as well.
+func (s *InMemoryStore) GetThread(ctx context.Context, roomID string, rootID string, req PageRequest) (*Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.index(roomID, rootID); !ok {
+		return nil, ErrMessageNotFound
+	}
+	state := s.rooms[roomID]
+	replies := state.threads[rootID]
+	// Replies are never removed from the thread, so that cursors stay valid,
+	// but evicted ones are skipped.
+	first := sort.SearchInts(replies, state.first)
+
+	from, to, err := pageBounds(req, first, len(replies))
+	if err != nil {
+		return nil, err
+	}
+	history := s.history[roomID]
+	messages := make([]*Message, 0, to-from)
+	for _, position := range replies[from:to] {
+		messages = append(messages, history[position-state.first])
+	}
+	return newPage(visible(messages), from, to, first, len(replies)), nil
+}
+
+// GetMentions pages through retained messages mentioning the user.
+func (s *InMemoryStore) GetMentions(ctx context.Context, roomID string, userID string, req PageRequest) (*Page, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state, ok := s.rooms[roomID]
+	if !ok {
+		return newPage([]*Message{}, 0, 0, 0, 0), nil
+	}
+	mentions := state.mentions[userID]
+	// Same as replies, evicted mentions are skipped rather than removed.
+	first := sort.SearchInts(mentions, state.first)
+
+	from, to, err := pageBounds(req, first, len(mentions))
+	if err != nil {
+		return nil, err
+	}
+	history := s.history[roomID]
+	messages := make([]*Message, 0, to-from)
+	for _, position := range mentions[from:to] {
+		messages = append(messages, history[position-state.first])
+	}
+	return newPage(visible(messages), from, to, first, len(mentions)), nil
+}
+
+// index returns index of the message in retained history of the room.
+// Caller must hold the lock.
+func (s *InMemoryStore) index(roomID string, msgID string) (int, bool) {
+	state, ok := s.rooms[roomID]
+	if !ok {
+		return 0, false
+	}
+	position, ok := state.positions[msgID]
+	if !ok {
+		return 0, false
+	}
+	return position - state.first, true
+}
+
+// roomState returns retention state of the room, creating it if needed.
+// Caller must hold the lock.
+func (s *InMemoryStore) roomState(roomID string) *roomState {
+	if s.rooms == nil {
+		s.rooms = make(map[string]*roomState)
+	}
+	state, ok := s.rooms[roomID]
+	if !ok {
+		state = &roomState{}
+		// History continues after archived messages, e.g. those archived before restart.
+		if s.archive != nil {
+			_, state.first = s.archive.Bounds(roomID)
+			lastSeq, err := s.archive.LastSeq(roomID)
+			if err != nil {
+				s.logger.Printf("Last archived message of room %s could not be read: %v\n", roomID, err)
+			}
+			state.lastSeq = lastSeq
+		}
+		s.rooms[roomID] = state
+	}
+	return state
+}
+
+// Close moves whole history into archive, if there is one, so it is not lost on shutdown.
+func (s *InMemoryStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.archive == nil {
+		return nil
+	}
+	for roomID, history := range s.history {
+		state := s.roomState(roomID)
+		if err := s.archive.Append(roomID, state.first, archived(history)); err != nil {
+			return err
+		}
+		state.first += len(history)
+		state.positions = nil
+		state.threads = nil
+		state.mentions = nil
+		s.history[roomID] = nil
+	}
+	return s.archive.Close()
+}
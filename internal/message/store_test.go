@@ -7,22 +7,31 @@ import (
 	"time"
 )
 
-const testRoomName = "tower"
+const (
+	testRoomID   = "5e4d3c2b-1a09-4f8e-a7d6-c5b4a3928170"
+	testRoomName = "tower"
+)
 
 var testMessage1 = &Message{
-	User:           "ultron",
-	Room:           testRoomName,
-	IsNotification: false,
-	Value:          "Bow to me, minion!",
-	ServerTime:     time.Time{},
+	ID:         "9b2f6c1e-4d3a-4e8b-a1c0-7f5e3d2b1a09",
+	Seq:        1,
+	UserID:     "0f3d2c1b-a987-4e65-b432-10fedcba9876",
+	User:       "ultron",
+	RoomID:     testRoomID,
+	Room:       testRoomName,
+	Body:       &TextBody{Text: "Bow to me, minion!"},
+	ServerTime: time.Time{},
 }
 
 var testMessage2 = &Message{
-	User:           "jarvis",
-	Room:           testRoomName,
-	IsNotification: false,
-	Value:          "Never!!!",
-	ServerTime:     time.Time{},
+	ID:         "3c4d5e6f-7a8b-4c9d-8e0f-1a2b3c4d5e6f",
+	Seq:        2,
+	UserID:     "8a0cbbd1-5c4b-4f5e-9d1a-3f2e6b7c8d90",
+	User:       "jarvis",
+	RoomID:     testRoomID,
+	Room:       testRoomName,
+	Body:       &TextBody{Text: "Never!!!"},
+	ServerTime: time.Time{},
 }
 
 func TestInMemoryStore_GetMessages(t *testing.T) {
@@ -30,38 +39,114 @@ func TestInMemoryStore_GetMessages(t *testing.T) {
 		history map[string][]*Message
 	}
 	type args struct {
-		roomName string
+		roomID string
+		req    PageRequest
 	}
 	tests := []struct {
 		name    string
 		fields  fields
 		args    args
-		want    []*Message
+		want    *Page
 		wantErr bool
 	}{
 		{
-			name: "Getting all messages by the room name should succeed",
+			name: "Getting all messages by the room ID should succeed",
+			fields: fields{
+				history: map[string][]*Message{
+					testRoomID: {testMessage1, testMessage2},
+				},
+			},
+			args: args{
+				roomID: testRoomID,
+			},
+			want: &Page{
+				Messages: []*Message{
+					testMessage1,
+					testMessage2,
+				},
+			},
+			wantErr: false,
+		},
+		{
+			name: "Getting limited page without cursors should return the latest messages",
 			fields: fields{
 				history: map[string][]*Message{
-					testRoomName: {testMessage1, testMessage2},
+					testRoomID: {testMessage1, testMessage2, testMessage1},
 				},
 			},
 			args: args{
-				roomName: testRoomName,
+				roomID: testRoomID,
+				req:    PageRequest{Limit: 2},
 			},
-			want: []*Message{
-				testMessage1,
-				testMessage2,
+			want: &Page{
+				Messages: []*Message{
+					testMessage2,
+					testMessage1,
+				},
+				Prev: "1",
 			},
 			wantErr: false,
 		},
+		{
+			name: "Getting page before cursor should return older messages",
+			fields: fields{
+				history: map[string][]*Message{
+					testRoomID: {testMessage1, testMessage2, testMessage1},
+				},
+			},
+			args: args{
+				roomID: testRoomID,
+				req:    PageRequest{Limit: 2, Before: "1"},
+			},
+			want: &Page{
+				Messages: []*Message{
+					testMessage1,
+				},
+				Next: "0",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Getting page after cursor should return newer messages",
+			fields: fields{
+				history: map[string][]*Message{
+					testRoomID: {testMessage1, testMessage2, testMessage1},
+				},
+			},
+			args: args{
+				roomID: testRoomID,
+				req:    PageRequest{Limit: 1, After: "0"},
+			},
+			want: &Page{
+				Messages: []*Message{
+					testMessage2,
+				},
+				Prev: "1",
+				Next: "1",
+			},
+			wantErr: false,
+		},
+		{
+			name: "Getting page with malformed cursor should fail",
+			fields: fields{
+				history: map[string][]*Message{
+					testRoomID: {testMessage1},
+				},
+			},
+			args: args{
+				roomID: testRoomID,
+				req:    PageRequest{Before: "tomorrow"},
+			},
+			want:    nil,
+			wantErr: true,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s := &InMemoryStore{
 				history: tt.fields.history,
 			}
-			got, err := s.GetMessages(context.Background(), tt.args.roomName)
+			got, err := s.GetMessages(context.Background(), tt.args.roomID, tt.args.req)
 			if (err != nil) != tt.wantErr {
 				t.Errorf("InMemoryStore.GetMessages() error = %v, wantErr %v", err, tt.wantErr)
 				return
@@ -78,8 +163,8 @@ func TestInMemoryStore_SaveMessage(t *testing.T) {
 		history map[string][]*Message
 	}
 	type args struct {
-		roomName string
-		msg      *Message
+		roomID string
+		msg    *Message
 	}
 	tests := []struct {
 		name    string
@@ -93,8 +178,8 @@ func TestInMemoryStore_SaveMessage(t *testing.T) {
 				history: map[string][]*Message{},
 			},
 			args: args{
-				roomName: testRoomName,
-				msg:      testMessage1,
+				roomID: testRoomID,
+				msg:    testMessage1,
 			},
 			wantErr: false,
 		},
@@ -104,9 +189,65 @@ func TestInMemoryStore_SaveMessage(t *testing.T) {
 			s := &InMemoryStore{
 				history: tt.fields.history,
 			}
-			if err := s.SaveMessage(context.Background(), tt.args.roomName, tt.args.msg); (err != nil) != tt.wantErr {
+			if err := s.SaveMessage(context.Background(), tt.args.roomID, tt.args.msg); (err != nil) != tt.wantErr {
 				t.Errorf("InMemoryStore.SaveMessage() error = %v, wantErr %v", err, tt.wantErr)
 			}
 		})
 	}
 }
+
+func TestInMemoryStore_LastSeq(t *testing.T) {
+	type fields struct {
+		history map[string][]*Message
+	}
+	type args struct {
+		roomID string
+	}
+	tests := []struct {
+		name    string
+		fields  fields
+		args    args
+		want    uint64
+		wantErr bool
+	}{
+		{
+			name: "Last sequence should be taken from the latest message of the room",
+			fields: fields{
+				history: map[string][]*Message{
+					testRoomID: {testMessage1, testMessage2},
+				},
+			},
+			args: args{
+				roomID: testRoomID,
+			},
+			want:    testMessage2.Seq,
+			wantErr: false,
+		},
+		{
+			name: "Last sequence of the room without messages should be zero",
+			fields: fields{
+				history: map[string][]*Message{},
+			},
+			args: args{
+				roomID: testRoomID,
+			},
+			want:    0,
+			wantErr: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &InMemoryStore{
+				history: tt.fields.history,
+			}
+			got, err := s.LastSeq(context.Background(), tt.args.roomID)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("InMemoryStore.LastSeq() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("InMemoryStore.LastSeq() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
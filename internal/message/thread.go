@@ -0,0 +1,29 @@
+package message
+
+import "time"
+
+// ThreadAction marks root message broadcasted with updated summary of its thread.
+const ThreadAction = "thread"
+
+// Thread summarizes replies to the root message.
+type Thread struct {
+	ReplyCount  int       `json:"replyCount"`
+	LastReplyAt time.Time `json:"lastReplyAt"`
+}
+
+// IsReply tells if message belongs to a thread.
+func (m *Message) IsReply() bool {
+	return m.ParentID != ""
+}
+
+// WithReply returns new version of the root message counting one more reply.
+func (m *Message) WithReply(reply *Message) *Message {
+	root := *m
+	root.Action = ""
+	root.Thread = &Thread{LastReplyAt: reply.ServerTime}
+	if m.Thread != nil {
+		root.Thread.ReplyCount = m.Thread.ReplyCount
+	}
+	root.Thread.ReplyCount++
+	return &root
+}
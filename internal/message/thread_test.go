@@ -0,0 +1,111 @@
+package message
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestMessage_WithReply(t *testing.T) {
+	first := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	second := first.Add(time.Minute)
+
+	root := testMessage1.
+		WithReply(&Message{ServerTime: first}).
+		WithReply(&Message{ServerTime: second})
+	want := &Thread{ReplyCount: 2, LastReplyAt: second}
+	if !reflect.DeepEqual(root.Thread, want) {
+		t.Errorf("Message.WithReply() thread = %v, want %v", root.Thread, want)
+	}
+	if testMessage1.Thread != nil {
+		t.Errorf("Message.WithReply() modified original message %v", testMessage1)
+	}
+}
+
+func TestStore_GetThread(t *testing.T) {
+	tests := []struct {
+		name     string
+		restart  bool
+		newStore func(dir string) (Store, func() error, error)
+	}{
+		{
+			name: "Thread replies should be paged in memory",
+			newStore: func(string) (Store, func() error, error) {
+				s := NewInMemoryStore(RetentionPolicy{}, nil, testLogger)
+				return s, s.Close, nil
+			},
+		},
+		{
+			name:    "Thread replies should be paged in file store after restart",
+			restart: true,
+			newStore: func(dir string) (Store, func() error, error) {
+				s, err := NewFileStore(dir, 0, testLogger)
+				if err != nil {
+					return nil, nil, err
+				}
+				return s, s.Close, nil
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			dir := t.TempDir()
+			s, closeStore, err := tt.newStore(dir)
+			if err != nil {
+				t.Fatalf("newStore() error = %v", err)
+			}
+
+			// Replies are interleaved with other messages of the room.
+			if err := s.SaveMessage(ctx, testRoomID, testMessage1); err != nil {
+				t.Fatalf("Store.SaveMessage() error = %v", err)
+			}
+			replies := []*Message{}
+			for i := 0; i < 5; i++ {
+				reply := *testMessage2
+				reply.ID = fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+				reply.Seq = uint64(2*i + 2)
+				reply.ParentID = testMessage1.ID
+				other := *testMessage2
+				other.ID = fmt.Sprintf("00000000-0000-4000-9000-%012d", i)
+				other.Seq = uint64(2*i + 3)
+				for _, msg := range []*Message{&reply, &other} {
+					if err := s.SaveMessage(ctx, testRoomID, msg); err != nil {
+						t.Fatalf("Store.SaveMessage() error = %v", err)
+					}
+				}
+				replies = append(replies, &reply)
+			}
+
+			if tt.restart {
+				closeStore()
+				if s, closeStore, err = tt.newStore(dir); err != nil {
+					t.Fatalf("newStore() on restart error = %v", err)
+				}
+			}
+			defer closeStore()
+
+			latest, err := s.GetThread(ctx, testRoomID, testMessage1.ID, PageRequest{Limit: 3})
+			if err != nil {
+				t.Fatalf("Store.GetThread() error = %v", err)
+			}
+			if !reflect.DeepEqual(latest.Messages, replies[2:]) || latest.Prev == "" || latest.Next != "" {
+				t.Errorf("Store.GetThread() = %v, want latest replies %v", latest, replies[2:])
+			}
+			older, err := s.GetThread(ctx, testRoomID, testMessage1.ID, PageRequest{Limit: 3, Before: latest.Prev})
+			if err != nil {
+				t.Fatalf("Store.GetThread() error = %v", err)
+			}
+			if !reflect.DeepEqual(older.Messages, replies[:2]) || older.Prev != "" || older.Next == "" {
+				t.Errorf("Store.GetThread() = %v, want older replies %v", older, replies[:2])
+			}
+
+			if _, err := s.GetThread(ctx, testRoomID, testMessage2.ID, PageRequest{}); !errors.Is(err, ErrMessageNotFound) {
+				t.Errorf("Store.GetThread() of unknown root error = %v, want %v", err, ErrMessageNotFound)
+			}
+		})
+	}
+}
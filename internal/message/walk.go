@@ -0,0 +1,41 @@
+package message
+
+import (
+	"context"
+	"errors"
+)
+
+// walkPageLimit is a number of messages fetched from the store at once while walking history.
+const walkPageLimit = 500
+
+// StopWalk is returned by the function passed to Walk to stop walking without an error.
+var StopWalk = errors.New("stop walking history")
+
+// Walk calls fn for every message of the room history, oldest first.
+// History is read page by page, so memory use does not depend on the size of the history.
+// Walk stops at the first error returned by fn and returns it, unless it is StopWalk.
+func Walk(ctx context.Context, s Store, roomID string, fn func(*Message) error) error {
+	// Page ending before the limit position is either the oldest page of history
+	// or an empty one pointing to it, if older messages were evicted.
+	req := PageRequest{Limit: walkPageLimit, Before: encodeCursor(walkPageLimit)}
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		page, err := s.GetMessages(ctx, roomID, req)
+		if err != nil {
+			return err
+		}
+		for _, msg := range page.Messages {
+			if err := fn(msg); errors.Is(err, StopWalk) {
+				return nil
+			} else if err != nil {
+				return err
+			}
+		}
+		if page.Next == "" {
+			return nil
+		}
+		req = PageRequest{Limit: walkPageLimit, After: page.Next}
+	}
+}
@@ -0,0 +1,78 @@
+package message
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func TestWalk(t *testing.T) {
+	tests := []struct {
+		name     string
+		count    int
+		policy   RetentionPolicy
+		stopAt   uint64
+		wantFrom uint64
+		wantTo   uint64
+	}{
+		{
+			name:     "History across several pages should be walked oldest first",
+			count:    2*walkPageLimit + 50,
+			wantFrom: 1,
+			wantTo:   2*walkPageLimit + 50,
+		},
+		{
+			name:     "Walk should start from the oldest retained message",
+			count:    2*walkPageLimit + 50,
+			policy:   RetentionPolicy{MaxCount: walkPageLimit + 10},
+			wantFrom: walkPageLimit + 41,
+			wantTo:   2*walkPageLimit + 50,
+		},
+		{
+			name:     "Walk should stop when asked",
+			count:    2 * walkPageLimit,
+			stopAt:   walkPageLimit + 1,
+			wantFrom: 1,
+			wantTo:   walkPageLimit,
+		},
+		{
+			name:  "Walk of empty history should not call back",
+			count: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			s := NewInMemoryStore(tt.policy, nil, testLogger)
+			defer s.Close()
+			for i := 1; i <= tt.count; i++ {
+				msg := *testMessage1
+				msg.ID = fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+				msg.Seq = uint64(i)
+				if err := s.SaveMessage(ctx, testRoomID, &msg); err != nil {
+					t.Fatalf("Store.SaveMessage() error = %v", err)
+				}
+			}
+
+			var from, to uint64
+			err := Walk(ctx, s, testRoomID, func(msg *Message) error {
+				if msg.Seq == tt.stopAt {
+					return StopWalk
+				}
+				if from == 0 {
+					from = msg.Seq
+				} else if msg.Seq != to+1 {
+					t.Fatalf("Walk() passed message %d after %d", msg.Seq, to)
+				}
+				to = msg.Seq
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Walk() error = %v", err)
+			}
+			if from != tt.wantFrom || to != tt.wantTo {
+				t.Errorf("Walk() passed messages %d-%d, want %d-%d", from, to, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package moderation
+
+import (
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+// WordFilter looks for words and phrases not allowed in the room;
+// depending on the rules, they are masked with asterisks, or the whole message is rejected or held.
+type WordFilter struct{}
+
+func (f *WordFilter) Check(_ *message.Message, text string, rules *Rules) Verdict {
+	if rules.words == nil || !rules.words.MatchString(text) {
+		return Verdict{Outcome: Allow, Text: text}
+	}
+	if rules.WordsOutcome != Redact {
+		return Verdict{Outcome: rules.WordsOutcome, Reason: "message contains words not allowed in this room"}
+	}
+	redacted := rules.words.ReplaceAllStringFunc(text, func(word string) string {
+		return strings.Repeat("*", utf8.RuneCountInString(word))
+	})
+	return Verdict{Outcome: Redact, Reason: "words not allowed in this room were masked", Text: redacted}
+}
+
+// Links are recognized by scheme or by the common "www." prefix.
+var linkPattern = regexp.MustCompile(`(?i)\b(?:[a-z][a-z0-9+.-]*://|www\.)\S`)
+
+// LinkFilter rejects messages with links in rooms blocking them.
+type LinkFilter struct{}
+
+func (f *LinkFilter) Check(_ *message.Message, text string, rules *Rules) Verdict {
+	if rules.BlockLinks && linkPattern.MatchString(text) {
+		return Verdict{Outcome: Reject, Reason: "links are not allowed in this room"}
+	}
+	return Verdict{Outcome: Allow, Text: text}
+}
+
+// SpamFilter rejects the same text sent by the same user too many times in a row,
+// within the window set by the rules of the room. Only new messages count, not edits.
+type SpamFilter struct {
+	// Recently sent texts by user and room.
+	recent map[spamKey][]sent
+	// Longest window of the rules checked so far, and when idle users were forgotten last time.
+	longestWindow time.Duration
+	swept         time.Time
+
+	mu sync.Mutex
+}
+
+// spamSweepInterval is how often spam filter forgets users who did not send anything within the window,
+// to keep memory bounded.
+const spamSweepInterval = time.Minute
+
+type spamKey struct {
+	userID string
+	roomID string
+}
+
+type sent struct {
+	text string
+	at   time.Time
+}
+
+func NewSpamFilter() *SpamFilter {
+	return &SpamFilter{recent: make(map[spamKey][]sent)}
+}
+
+func (f *SpamFilter) Check(msg *message.Message, text string, rules *Rules) Verdict {
+	allow := Verdict{Outcome: Allow, Text: text}
+	if _, ok := msg.Body.(*message.TextBody); !ok || rules.SpamRepeats == 0 {
+		return allow
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	now := time.Now()
+	f.longestWindow = max(f.longestWindow, rules.SpamWindow)
+	if now.Sub(f.swept) >= spamSweepInterval {
+		f.sweep(now)
+	}
+	key := spamKey{userID: msg.UserID, roomID: msg.RoomID}
+	normalized := strings.ToLower(strings.Join(strings.Fields(text), " "))
+
+	// Forget texts sent before the window.
+	recent := f.recent[key]
+	for len(recent) > 0 && now.Sub(recent[0].at) > rules.SpamWindow {
+		recent = recent[1:]
+	}
+	repeats := 0
+	for _, s := range recent {
+		if s.text == normalized {
+			repeats++
+		}
+	}
+	if repeats >= rules.SpamRepeats {
+		f.recent[key] = recent
+		return Verdict{Outcome: Reject, Reason: "same message was sent too many times, wait a bit"}
+	}
+	f.recent[key] = append(recent, sent{text: normalized, at: now})
+	return allow
+}
+
+// sweep forgets users whose latest text was sent before the longest window, so it cannot be repeated within any.
+// Caller must hold the lock.
+func (f *SpamFilter) sweep(now time.Time) {
+	for key, recent := range f.recent {
+		if len(recent) == 0 || now.Sub(recent[len(recent)-1].at) > f.longestWindow {
+			delete(f.recent, key)
+		}
+	}
+	f.swept = now
+}
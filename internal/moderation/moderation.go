@@ -0,0 +1,219 @@
+// Package moderation judges messages before they are accepted into rooms
+// by an ordered chain of filters configured per room.
+package moderation
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+// Outcome is what moderation does with the message.
+type Outcome string
+
+const (
+	// Allow lets message into the room as is.
+	Allow Outcome = "allow"
+	// Reject drops message.
+	Reject Outcome = "reject"
+	// Redact masks offending parts of the text and lets message in.
+	Redact Outcome = "redact"
+	// Quarantine holds message back until a moderator releases or discards it.
+	Quarantine Outcome = "quarantine"
+	// Discard is the outcome of held message discarded by a moderator.
+	Discard Outcome = "discard"
+)
+
+var (
+	ErrInvalidRules = errors.New("invalid moderation rules")
+	ErrNotHeld      = errors.New("message is not held for review")
+)
+
+// Verdict of moderation on the message.
+type Verdict struct {
+	Outcome Outcome
+	// Why message was not allowed as is, to let the sender know.
+	Reason string
+	// Text of the message after redaction.
+	Text string
+}
+
+// Rules configure filters for the room. Zero rules let every message in.
+type Rules struct {
+	// Regular expressions of words or phrases not allowed in the room, matched case-insensitively.
+	Words []string
+	// What is done with messages containing such words: Redact (default), Reject or Quarantine.
+	WordsOutcome Outcome
+	// Whether messages with links are rejected.
+	BlockLinks bool
+	// Message with the same text sent by the same user more than SpamRepeats times
+	// within SpamWindow is rejected; zero SpamRepeats turns spam detection off.
+	SpamRepeats int
+	SpamWindow  time.Duration
+
+	// Words compiled into a single expression.
+	words *regexp.Regexp
+}
+
+// compile validates rules and prepares them for filters.
+func (r *Rules) compile() error {
+	switch r.WordsOutcome {
+	case "":
+		r.WordsOutcome = Redact
+	case Redact, Reject, Quarantine:
+	default:
+		return fmt.Errorf("%w: unknown outcome %q", ErrInvalidRules, r.WordsOutcome)
+	}
+	if r.SpamRepeats < 0 || r.SpamWindow < 0 {
+		return fmt.Errorf("%w: spam limits cannot be negative", ErrInvalidRules)
+	}
+	if r.SpamRepeats > 0 && r.SpamWindow == 0 {
+		return fmt.Errorf("%w: spam window is not set", ErrInvalidRules)
+	}
+
+	r.words = nil
+	if len(r.Words) == 0 {
+		return nil
+	}
+	alternatives := make([]string, len(r.Words))
+	for i, word := range r.Words {
+		if _, err := regexp.Compile(word); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalidRules, err)
+		}
+		alternatives[i] = "(?:" + word + ")"
+	}
+	var err error
+	r.words, err = regexp.Compile("(?i)" + strings.Join(alternatives, "|"))
+	return err
+}
+
+// Filter judges text of the message by the rules of its room.
+// Filters run in order, and text redacted by one filter is passed to the next one.
+type Filter interface {
+	Check(msg *message.Message, text string, rules *Rules) Verdict
+}
+
+// Held is a message held back by moderation until it is reviewed.
+type Held struct {
+	ID      string
+	Message *message.Message
+	Reason  string
+	HeldAt  time.Time
+}
+
+// Pipeline runs messages through the chain of filters with the rules of their rooms.
+// Rules can be changed at runtime; they and held messages are kept in memory only.
+type Pipeline struct {
+	filters []Filter
+	rules   map[string]*Rules
+	held    map[string][]*Held
+
+	mu sync.RWMutex
+}
+
+// DefaultFilters returns the built-in chain: word lists, link blocking and spam detection.
+func DefaultFilters() []Filter {
+	return []Filter{&WordFilter{}, &LinkFilter{}, NewSpamFilter()}
+}
+
+func NewPipeline(filters ...Filter) *Pipeline {
+	return &Pipeline{
+		filters: filters,
+		rules:   make(map[string]*Rules),
+		held:    make(map[string][]*Held),
+	}
+}
+
+// Rules returns moderation rules of the room.
+func (p *Pipeline) Rules(roomID string) Rules {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	if rules, ok := p.rules[roomID]; ok {
+		return *rules
+	}
+	return Rules{WordsOutcome: Redact}
+}
+
+// SetRules replaces moderation rules of the room; messages moderated from now on are judged by them.
+func (p *Pipeline) SetRules(roomID string, rules Rules) error {
+	rules.Words = slices.Clone(rules.Words)
+	if err := rules.compile(); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.rules[roomID] = &rules
+	return nil
+}
+
+// Check runs text of the message through the filters. The first filter rejecting
+// or quarantining the message stops the chain; redactions are accumulated.
+func (p *Pipeline) Check(msg *message.Message, text string) Verdict {
+	rules := p.Rules(msg.RoomID)
+
+	verdict := Verdict{Outcome: Allow, Text: text}
+	var reasons []string
+	for _, filter := range p.filters {
+		v := filter.Check(msg, verdict.Text, &rules)
+		switch v.Outcome {
+		case Reject, Quarantine:
+			return v
+		case Redact:
+			verdict.Outcome, verdict.Text = Redact, v.Text
+			reasons = append(reasons, v.Reason)
+		}
+	}
+	verdict.Reason = strings.Join(reasons, "; ")
+	return verdict
+}
+
+// Hold keeps message back for review and returns it identified for moderators.
+func (p *Pipeline) Hold(msg *message.Message, reason string) *Held {
+	held := &Held{
+		ID:      domain.NewID(),
+		Message: msg,
+		Reason:  reason,
+		HeldAt:  time.Now(),
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.held[msg.RoomID] = append(p.held[msg.RoomID], held)
+	return held
+}
+
+// Held returns messages of the room waiting for review, the earliest first.
+func (p *Pipeline) Held(roomID string) []*Held {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	return slices.Clone(p.held[roomID])
+}
+
+// Release takes message out of review, either to let it into the room or to discard it.
+func (p *Pipeline) Release(roomID, heldID string) (*Held, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	i := slices.IndexFunc(p.held[roomID], func(h *Held) bool { return h.ID == heldID })
+	if i < 0 {
+		return nil, ErrNotHeld
+	}
+	held := p.held[roomID][i]
+	p.held[roomID] = slices.Delete(p.held[roomID], i, i+1)
+	if len(p.held[roomID]) == 0 {
+		delete(p.held, roomID)
+	}
+	return held, nil
+}
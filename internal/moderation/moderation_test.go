@@ -0,0 +1,170 @@
+package moderation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+const testRoomID = "6f1c2a9e-3d4b-4c5a-8e7f-9a0b1c2d3e4f"
+
+func testMessage(text string) *message.Message {
+	return &message.Message{
+		UserID: "u1",
+		RoomID: testRoomID,
+		Body:   &message.TextBody{Text: text},
+	}
+}
+
+func TestPipeline_Check(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules Rules
+		text  string
+		want  Verdict
+	}{
+		{
+			name: "Message should be allowed by zero rules",
+			text: "darn, see https://example.com",
+			want: Verdict{Outcome: Allow, Text: "darn, see https://example.com"},
+		},
+		{
+			name:  "Words should be masked by default",
+			rules: Rules{Words: []string{`darn\w*`, "heck"}},
+			text:  "Darned thing, what the heck",
+			want:  Verdict{Outcome: Redact, Reason: "words not allowed in this room were masked", Text: "****** thing, what the ****"},
+		},
+		{
+			name:  "Words should reject message if rules say so",
+			rules: Rules{Words: []string{"darn"}, WordsOutcome: Reject},
+			text:  "darn",
+			want:  Verdict{Outcome: Reject, Reason: "message contains words not allowed in this room"},
+		},
+		{
+			name:  "Words should quarantine message if rules say so",
+			rules: Rules{Words: []string{"darn"}, WordsOutcome: Quarantine},
+			text:  "darn",
+			want:  Verdict{Outcome: Quarantine, Reason: "message contains words not allowed in this room"},
+		},
+		{
+			name:  "Links should be rejected if blocked",
+			rules: Rules{BlockLinks: true},
+			text:  "see www.example.com",
+			want:  Verdict{Outcome: Reject, Reason: "links are not allowed in this room"},
+		},
+		{
+			name:  "Text without links should pass link blocking",
+			rules: Rules{BlockLinks: true},
+			text:  "see the docs at example",
+			want:  Verdict{Outcome: Allow, Text: "see the docs at example"},
+		},
+		{
+			name:  "Rejection should stop the chain after redaction",
+			rules: Rules{Words: []string{"darn"}, BlockLinks: true},
+			text:  "darn http://example.com",
+			want:  Verdict{Outcome: Reject, Reason: "links are not allowed in this room"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline(DefaultFilters()...)
+			if err := p.SetRules(testRoomID, tt.rules); err != nil {
+				t.Fatalf("Pipeline.SetRules() error = %v", err)
+			}
+			if got := p.Check(testMessage(tt.text), tt.text); got != tt.want {
+				t.Errorf("Pipeline.Check() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipeline_SetRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   Rules
+		wantErr bool
+	}{
+		{name: "Valid rules should be set", rules: Rules{Words: []string{`\bfoo\b`}, WordsOutcome: Quarantine, SpamRepeats: 2, SpamWindow: time.Minute}},
+		{name: "Invalid expression should be rejected", rules: Rules{Words: []string{"("}}, wantErr: true},
+		{name: "Unknown outcome should be rejected", rules: Rules{Words: []string{"foo"}, WordsOutcome: Allow}, wantErr: true},
+		{name: "Spam detection without window should be rejected", rules: Rules{SpamRepeats: 2}, wantErr: true},
+		{name: "Negative spam limits should be rejected", rules: Rules{SpamRepeats: -1}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPipeline()
+			err := p.SetRules(testRoomID, tt.rules)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pipeline.SetRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidRules) {
+				t.Errorf("Pipeline.SetRules() error = %v, want ErrInvalidRules", err)
+			}
+		})
+	}
+}
+
+func TestSpamFilter_Check(t *testing.T) {
+	p := NewPipeline(NewSpamFilter())
+	if err := p.SetRules(testRoomID, Rules{SpamRepeats: 2, SpamWindow: time.Minute}); err != nil {
+		t.Fatalf("Pipeline.SetRules() error = %v", err)
+	}
+	steps := []struct {
+		userID string
+		text   string
+		want   Outcome
+	}{
+		{userID: "u1", text: "buy now", want: Allow},
+		{userID: "u1", text: "something else", want: Allow},
+		{userID: "u1", text: "Buy   NOW", want: Allow},
+		{userID: "u2", text: "buy now", want: Allow},
+		{userID: "u1", text: "buy now", want: Reject},
+	}
+	for i, step := range steps {
+		msg := testMessage(step.text)
+		msg.UserID = step.userID
+		if got := p.Check(msg, step.text); got.Outcome != step.want {
+			t.Errorf("step %d: Pipeline.Check() = %+v, want %v", i+1, got, step.want)
+		}
+	}
+}
+
+func TestSpamFilter_Sweep(t *testing.T) {
+	f := NewSpamFilter()
+	rules := &Rules{SpamRepeats: 2, SpamWindow: time.Minute}
+	idle, active := testMessage("buy now"), testMessage("buy now")
+	idle.UserID, active.UserID = "u1", "u2"
+	f.Check(idle, idle.Text(), rules)
+	f.Check(active, active.Text(), rules)
+	f.recent[spamKey{userID: "u1", roomID: testRoomID}][0].at = time.Now().Add(-2 * time.Minute)
+
+	f.sweep(time.Now())
+	if _, ok := f.recent[spamKey{userID: "u1", roomID: testRoomID}]; ok {
+		t.Errorf("SpamFilter.sweep() kept user idle for longer than the window")
+	}
+	if _, ok := f.recent[spamKey{userID: "u2", roomID: testRoomID}]; !ok {
+		t.Errorf("SpamFilter.sweep() forgot user who sent text within the window")
+	}
+}
+
+func TestPipeline_Release(t *testing.T) {
+	p := NewPipeline()
+	first := p.Hold(testMessage("first"), "reason")
+	second := p.Hold(testMessage("second"), "reason")
+	if held := p.Held(testRoomID); len(held) != 2 || held[0] != first || held[1] != second {
+		t.Fatalf("Pipeline.Held() = %v, want both messages in order", held)
+	}
+
+	released, err := p.Release(testRoomID, first.ID)
+	if err != nil || released != first {
+		t.Fatalf("Pipeline.Release() = %v, %v, want the first message", released, err)
+	}
+	if _, err := p.Release(testRoomID, first.ID); !errors.Is(err, ErrNotHeld) {
+		t.Errorf("Pipeline.Release() of released message error = %v, want ErrNotHeld", err)
+	}
+	if held := p.Held(testRoomID); len(held) != 1 || held[0] != second {
+		t.Errorf("Pipeline.Held() after release = %v, want the second message", held)
+	}
+}
@@ -0,0 +1,146 @@
+// Package ratelimit limits how often events happen, with token buckets
+// refilled at a steady rate and allowing short bursts.
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit allows Rate events per second in the long run, and up to Burst events at once.
+// Zero rate means no limit.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Unlimited tells if the limit lets every event through.
+func (l Limit) Unlimited() bool {
+	return l.Rate <= 0
+}
+
+// Bucket holds tokens for events limited together, e.g. those coming from a single connection.
+// It is not safe for concurrent use.
+type Bucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewBucket returns bucket full of tokens.
+func NewBucket(limit Limit) *Bucket {
+	return &Bucket{limit: limit, tokens: float64(max(limit.Burst, 1))}
+}
+
+// Take takes a token for the event happening now, if there is one.
+// Otherwise it tells how long to wait until a token is available.
+func (b *Bucket) Take(now time.Time) (bool, time.Duration) {
+	if wait := b.Wait(now); wait > 0 {
+		return false, wait
+	}
+	b.take()
+	return true, 0
+}
+
+// Wait tells how long to wait until a token is available, zero if there is one now.
+// Token is not taken.
+func (b *Bucket) Wait(now time.Time) time.Duration {
+	if b.limit.Unlimited() {
+		return 0
+	}
+	b.refill(now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	wait := (1 - b.tokens) / b.limit.Rate
+	return time.Duration(math.Ceil(wait * float64(time.Second)))
+}
+
+// take spends a token refilled by Wait.
+func (b *Bucket) take() {
+	if !b.limit.Unlimited() {
+		b.tokens--
+	}
+}
+
+func (b *Bucket) refill(now time.Time) {
+	if !b.last.IsZero() && now.After(b.last) {
+		b.tokens = min(b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate, float64(max(b.limit.Burst, 1)))
+	}
+	if now.After(b.last) {
+		b.last = now
+	}
+}
+
+// full tells if bucket got all of its tokens back by now, so that it is no different from a new one.
+func (b *Bucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(max(b.limit.Burst, 1))
+}
+
+// sweepInterval is how often limiter forgets keys with full buckets, to keep memory bounded.
+const sweepInterval = time.Minute
+
+// Limiter limits events by key, e.g. by user, each key having a bucket of its own.
+// It is safe for concurrent use.
+type Limiter struct {
+	limit   Limit
+	buckets map[string]*Bucket
+	swept   time.Time
+
+	mu sync.Mutex
+}
+
+func NewLimiter(limit Limit) *Limiter {
+	return &Limiter{limit: limit, buckets: make(map[string]*Bucket)}
+}
+
+// Wait tells how long the event of the key has to wait until a token is available, zero if there is one now.
+// Token is not taken, so that it is only spent by Take once the event actually happens.
+func (l *Limiter) Wait(key string, now time.Time) time.Duration {
+	if l.limit.Unlimited() {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bucket(key, now).Wait(now)
+}
+
+// Take spends a token for the event of the key which happened now, after Wait let it through.
+func (l *Limiter) Take(key string, now time.Time) {
+	if l.limit.Unlimited() {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	bucket := l.bucket(key, now)
+	bucket.refill(now)
+	bucket.take()
+}
+
+// bucket returns bucket of the key, forgetting idle keys from time to time.
+func (l *Limiter) bucket(key string, now time.Time) *Bucket {
+	if now.Sub(l.swept) >= sweepInterval {
+		l.sweep(now)
+	}
+	bucket, ok := l.buckets[key]
+	if !ok {
+		bucket = NewBucket(l.limit)
+		l.buckets[key] = bucket
+	}
+	return bucket
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for key, bucket := range l.buckets {
+		if bucket.full(now) {
+			delete(l.buckets, key)
+		}
+	}
+	l.swept = now
+}
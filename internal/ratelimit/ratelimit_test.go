@@ -0,0 +1,97 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var testNow = time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+func TestBucket_Take(t *testing.T) {
+	type step struct {
+		after     time.Duration
+		want      bool
+		wantRetry time.Duration
+	}
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{
+			name:  "Zero rate should not limit events",
+			limit: Limit{},
+			steps: []step{{want: true}, {want: true}, {want: true}},
+		},
+		{
+			name:  "Burst should be allowed at once",
+			limit: Limit{Rate: 1, Burst: 2},
+			steps: []step{{want: true}, {want: true}, {want: false, wantRetry: time.Second}},
+		},
+		{
+			name:  "Tokens should be refilled with time",
+			limit: Limit{Rate: 2, Burst: 1},
+			steps: []step{
+				{want: true},
+				{after: 100 * time.Millisecond, want: false, wantRetry: 400 * time.Millisecond},
+				{after: 500 * time.Millisecond, want: true},
+			},
+		},
+		{
+			name:  "Refilled tokens should not exceed burst",
+			limit: Limit{Rate: 10, Burst: 2},
+			steps: []step{
+				{want: true},
+				{after: time.Hour, want: true},
+				{want: true},
+				{want: false, wantRetry: 100 * time.Millisecond},
+			},
+		},
+		{
+			name:  "Zero burst should allow single event",
+			limit: Limit{Rate: 1},
+			steps: []step{{want: true}, {want: false, wantRetry: time.Second}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBucket(tt.limit)
+			now := testNow
+			for i, step := range tt.steps {
+				now = now.Add(step.after)
+				got, gotRetry := b.Take(now)
+				if got != step.want || gotRetry != step.wantRetry {
+					t.Errorf("step %d: Bucket.Take() = %v, %v, want %v, %v", i+1, got, gotRetry, step.want, step.wantRetry)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiter_Take(t *testing.T) {
+	l := NewLimiter(Limit{Rate: 1, Burst: 1})
+	if wait := l.Wait("a", testNow); wait != 0 {
+		t.Fatalf("Limiter.Wait() of the first event = %v, want 0", wait)
+	}
+	// Waiting does not spend tokens, only taking does.
+	if wait := l.Wait("a", testNow); wait != 0 {
+		t.Fatalf("Limiter.Wait() of the event not taken yet = %v, want 0", wait)
+	}
+	l.Take("a", testNow)
+	if wait := l.Wait("a", testNow); wait != time.Second {
+		t.Errorf("Limiter.Wait() of the second event of the same key = %v, want %v", wait, time.Second)
+	}
+	if wait := l.Wait("b", testNow); wait != 0 {
+		t.Errorf("Limiter.Wait() of the first event of another key = %v, want 0", wait)
+	}
+	l.Take("b", testNow)
+
+	// Keys with full buckets are forgotten, others are kept.
+	l.Take("c", testNow.Add(sweepInterval))
+	if _, ok := l.buckets["a"]; ok {
+		t.Errorf("Limiter bucket of idle key should be forgotten")
+	}
+	if _, ok := l.buckets["c"]; !ok {
+		t.Errorf("Limiter bucket of active key should be kept")
+	}
+}
@@ -0,0 +1,346 @@
+package schedule
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+var (
+	ErrNotFound  = errors.New("no scheduled message with this ID")
+	ErrEmptyText = errors.New("scheduled message has no text")
+	ErrPastTime  = errors.New("scheduled time is in the past")
+)
+
+// Reason of failure for messages whose author is not in the room by the scheduled time.
+const authorLeftReason = "author does not participate in the room"
+
+// Message is a text message waiting to be sent to the room on behalf of its author.
+// Message which could not be sent keeps the reason of failure, so that the author learns about it,
+// until it is rescheduled or canceled.
+type Message struct {
+	ID        string    `json:"id"`
+	UserID    string    `json:"userId"`
+	RoomID    string    `json:"roomId"`
+	Text      string    `json:"text"`
+	SendAt    time.Time `json:"sendAt"`
+	CreatedAt time.Time `json:"createdAt"`
+	Failed    string    `json:"failed,omitempty"`
+}
+
+// Scheduler keeps messages scheduled by users and sends them when they are due.
+// With a file, messages are persisted on every change and survive restarts;
+// messages which became due while the service was down are sent right after it starts.
+type Scheduler struct {
+	repo domain.Repository
+	path string
+
+	messages map[string]*Message
+	mu       sync.Mutex
+
+	// Signals run loop that schedule has changed.
+	wake chan struct{}
+
+	logger *log.Logger
+}
+
+// NewScheduler creates scheduler keeping messages in the file, or only in memory if the path is empty.
+func NewScheduler(repo domain.Repository, path string, logger *log.Logger) (*Scheduler, error) {
+	s := &Scheduler{
+		repo:     repo,
+		path:     path,
+		messages: make(map[string]*Message),
+		wake:     make(chan struct{}, 1),
+		logger:   logger,
+	}
+	if path == "" {
+		return s, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("read scheduled messages: %w", err)
+	}
+	messages := []*Message{}
+	if err := json.Unmarshal(data, &messages); err != nil {
+		return nil, fmt.Errorf("decode scheduled messages: %w", err)
+	}
+	for _, msg := range messages {
+		s.messages[msg.ID] = msg
+	}
+	return s, nil
+}
+
+// Schedule adds message of the user to be sent to the room at the given time.
+// Caller is expected to check that the user participates in the room.
+func (s *Scheduler) Schedule(userID, roomID, text string, sendAt time.Time) (*Message, error) {
+	if strings.TrimSpace(text) == "" {
+		return nil, ErrEmptyText
+	}
+	now := time.Now()
+	if !sendAt.After(now) {
+		return nil, ErrPastTime
+	}
+	msg := &Message{
+		ID:        domain.NewID(),
+		UserID:    userID,
+		RoomID:    roomID,
+		Text:      text,
+		SendAt:    sendAt,
+		CreatedAt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages[msg.ID] = msg
+	if err := s.save(); err != nil {
+		delete(s.messages, msg.ID)
+		return nil, err
+	}
+	s.notify()
+	scheduled := *msg
+	return &scheduled, nil
+}
+
+// List returns messages scheduled by the user, the earliest first.
+func (s *Scheduler) List(userID string) []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := []*Message{}
+	for _, msg := range s.messages {
+		if msg.UserID == userID {
+			scheduled := *msg
+			messages = append(messages, &scheduled)
+		}
+	}
+	slices.SortFunc(messages, func(a, b *Message) int {
+		return a.SendAt.Compare(b.SendAt)
+	})
+	return messages
+}
+
+// Edit changes text or time of the message scheduled by the user; empty text and zero time are left as is.
+// Message which could not be sent is scheduled again.
+func (s *Scheduler) Edit(userID, id, text string, sendAt time.Time) (*Message, error) {
+	if !sendAt.IsZero() && !sendAt.After(time.Now()) {
+		return nil, ErrPastTime
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.messages[id]
+	if !ok || msg.UserID != userID {
+		return nil, ErrNotFound
+	}
+	previous := *msg
+	if strings.TrimSpace(text) != "" {
+		msg.Text = text
+	}
+	if !sendAt.IsZero() {
+		msg.SendAt = sendAt
+	}
+	msg.Failed = ""
+	if err := s.save(); err != nil {
+		*msg = previous
+		return nil, err
+	}
+	s.notify()
+	scheduled := *msg
+	return &scheduled, nil
+}
+
+// Cancel removes the message scheduled by the user.
+func (s *Scheduler) Cancel(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.messages[id]
+	if !ok || msg.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.messages, id)
+	if err := s.save(); err != nil {
+		s.messages[id] = msg
+		return err
+	}
+	s.notify()
+	return nil
+}
+
+// SendFunc passes the message on as if its author sent it live, and tells why it is not accepted, if so.
+type SendFunc func(ctx context.Context, msg *message.Message) error
+
+// Run sends due messages until context is canceled. Message which is not accepted is kept
+// with the reason, the same as one which could not be sent, so that its offline author learns about it.
+// It is supposed to run as goroutine.
+func (s *Scheduler) Run(ctx context.Context, send SendFunc) {
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-s.wake:
+		case <-ctx.Done():
+			return
+		}
+		for _, due := range s.due(time.Now()) {
+			msg, err := s.prepare(ctx, due)
+			if err != nil {
+				s.logger.Printf("Scheduled message %s is not sent: %v\n", due.ID, err)
+				s.fail(due.ID, err.Error())
+				continue
+			}
+			if err := send(ctx, msg); err != nil {
+				if ctx.Err() != nil {
+					return
+				}
+				s.logger.Printf("Scheduled message %s is not accepted: %v\n", due.ID, err)
+				s.fail(due.ID, err.Error())
+				continue
+			}
+			s.sent(due)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		if next, ok := s.next(); ok {
+			timer.Reset(time.Until(next))
+		}
+	}
+}
+
+// prepare makes message to send on behalf of the author, who has to participate in the room by then.
+func (s *Scheduler) prepare(ctx context.Context, due *Message) (*message.Message, error) {
+	user := s.repo.GetUser(ctx, due.UserID)
+	if user == nil {
+		return nil, domain.ErrUserNotFound
+	}
+	participants, err := s.repo.ListParticipants(ctx, due.RoomID)
+	if err != nil {
+		return nil, err
+	}
+	if !slices.ContainsFunc(participants, func(u *domain.User) bool { return u.ID == user.ID }) {
+		return nil, errors.New(authorLeftReason)
+	}
+	return &message.Message{
+		UserID: user.ID,
+		User:   user.Name,
+		RoomID: due.RoomID,
+		Body:   &message.TextBody{Text: due.Text},
+	}, nil
+}
+
+// due returns messages which should be sent by now, the earliest first.
+func (s *Scheduler) due(now time.Time) []*Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	due := []*Message{}
+	for _, msg := range s.messages {
+		if msg.Failed == "" && !msg.SendAt.After(now) {
+			scheduled := *msg
+			due = append(due, &scheduled)
+		}
+	}
+	slices.SortFunc(due, func(a, b *Message) int {
+		return a.SendAt.Compare(b.SendAt)
+	})
+	return due
+}
+
+// next returns time of the earliest message to send, if there is any.
+func (s *Scheduler) next() (time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var next time.Time
+	for _, msg := range s.messages {
+		if msg.Failed == "" && (next.IsZero() || msg.SendAt.Before(next)) {
+			next = msg.SendAt
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// sent forgets the message once it is passed on, unless it was changed meanwhile.
+// If the service stops before the change is saved, message is sent once again after restart.
+func (s *Scheduler) sent(due *Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.messages[due.ID]
+	if !ok || msg.Text != due.Text || !msg.SendAt.Equal(due.SendAt) {
+		return
+	}
+	delete(s.messages, due.ID)
+	if err := s.save(); err != nil {
+		s.logger.Printf("Sent scheduled message %s could not be removed: %v\n", due.ID, err)
+	}
+}
+
+// fail keeps the message with the reason it was not sent, so that its author can see it.
+func (s *Scheduler) fail(id, reason string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	msg, ok := s.messages[id]
+	if !ok {
+		return
+	}
+	msg.Failed = reason
+	if err := s.save(); err != nil {
+		s.logger.Printf("Failure of scheduled message %s could not be saved: %v\n", id, err)
+	}
+}
+
+// notify wakes run loop up without blocking, in case the earliest message has changed.
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// save replaces the file with all scheduled messages atomically. Caller must hold the lock.
+func (s *Scheduler) save() error {
+	if s.path == "" {
+		return nil
+	}
+	messages := make([]*Message, 0, len(s.messages))
+	for _, msg := range s.messages {
+		messages = append(messages, msg)
+	}
+	slices.SortFunc(messages, func(a, b *Message) int {
+		return a.SendAt.Compare(b.SendAt)
+	})
+	data, err := json.Marshal(messages)
+	if err != nil {
+		return fmt.Errorf("encode scheduled messages: %w", err)
+	}
+	if err := os.WriteFile(s.path+".tmp", data, 0o644); err != nil {
+		return fmt.Errorf("write scheduled messages: %w", err)
+	}
+	if err := os.Rename(s.path+".tmp", s.path); err != nil {
+		return fmt.Errorf("rename scheduled messages: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+func TestScheduler_Run(t *testing.T) {
+	ctx := context.Background()
+	repo := domain.NewInMemoryRepository()
+	stayed, _ := repo.CreateUser(ctx, "tom")
+	left, _ := repo.CreateUser(ctx, "jerry")
+	room, err := repo.CreateRoom(ctx, "kitchen", stayed.ID)
+	if err != nil {
+		t.Fatalf("CreateRoom() error = %v", err)
+	}
+	if err := repo.JoinRoom(ctx, left.ID, room.ID); err != nil {
+		t.Fatalf("JoinRoom() error = %v", err)
+	}
+
+	s, err := NewScheduler(repo, "", testLogger)
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	sendAt := time.Now().Add(50 * time.Millisecond)
+	if _, err := s.Schedule(stayed.ID, room.ID, "Dinner is served", sendAt); err != nil {
+		t.Fatalf("Scheduler.Schedule() error = %v", err)
+	}
+	abandoned, err := s.Schedule(left.ID, room.ID, "Where is the cheese?", sendAt)
+	if err != nil {
+		t.Fatalf("Scheduler.Schedule() error = %v", err)
+	}
+	if err := repo.LeaveRoom(ctx, left.ID, room.ID); err != nil {
+		t.Fatalf("LeaveRoom() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	out := make(chan *message.Message)
+	go s.Run(ctx, func(ctx context.Context, msg *message.Message) error {
+		select {
+		case out <- msg:
+			return nil
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	})
+
+	select {
+	case msg := <-out:
+		body, ok := msg.Body.(*message.TextBody)
+		if msg.UserID != stayed.ID || msg.User != stayed.Name || msg.RoomID != room.ID || !ok || body.Text != "Dinner is served" {
+			t.Errorf("Scheduler.Run() sent %v, want message of %s", msg, stayed.Name)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Scheduler.Run() did not send due message")
+	}
+	select {
+	case msg := <-out:
+		t.Errorf("Scheduler.Run() sent %v of the author who left the room", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if got := s.List(stayed.ID); len(got) != 0 {
+		t.Errorf("Scheduler.List() = %v, want sent message removed", got)
+	}
+	got := s.List(left.ID)
+	if len(got) != 1 || got[0].ID != abandoned.ID || got[0].Failed != authorLeftReason {
+		t.Errorf("Scheduler.List() = %v, want message failed with %q", got, authorLeftReason)
+	}
+}
+
+func TestScheduler_RunRejected(t *testing.T) {
+	ctx := context.Background()
+	repo := domain.NewInMemoryRepository()
+	user, _ := repo.CreateUser(ctx, "tom")
+	room, err := repo.CreateRoom(ctx, "kitchen", user.ID)
+	if err != nil {
+		t.Fatalf("CreateRoom() error = %v", err)
+	}
+	s, err := NewScheduler(repo, "", testLogger)
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	scheduled, err := s.Schedule(user.ID, room.ID, "Buy cheese now", time.Now().Add(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Scheduler.Schedule() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	const reason = "rejected by moderation: spam"
+	go s.Run(ctx, func(ctx context.Context, msg *message.Message) error {
+		return errors.New(reason)
+	})
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		got := s.List(user.ID)
+		if len(got) == 1 && got[0].ID == scheduled.ID && got[0].Failed == reason {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Scheduler.List() = %v, want message failed with %q", got, reason)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestScheduler_Persistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "scheduled.json")
+	repo := domain.NewInMemoryRepository()
+	s, err := NewScheduler(repo, path, testLogger)
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	sendAt := time.Now().Add(time.Hour).Round(0)
+	kept, err := s.Schedule("user-1", "room-1", "Meow!", sendAt)
+	if err != nil {
+		t.Fatalf("Scheduler.Schedule() error = %v", err)
+	}
+	canceled, err := s.Schedule("user-1", "room-1", "Woof!", sendAt)
+	if err != nil {
+		t.Fatalf("Scheduler.Schedule() error = %v", err)
+	}
+	if _, err := s.Edit("user-1", kept.ID, "Purr!", sendAt.Add(time.Minute)); err != nil {
+		t.Fatalf("Scheduler.Edit() error = %v", err)
+	}
+	if err := s.Cancel("user-1", canceled.ID); err != nil {
+		t.Fatalf("Scheduler.Cancel() error = %v", err)
+	}
+
+	s, err = NewScheduler(repo, path, testLogger)
+	if err != nil {
+		t.Fatalf("NewScheduler() on restart error = %v", err)
+	}
+	got := s.List("user-1")
+	if len(got) != 1 || got[0].ID != kept.ID || got[0].Text != "Purr!" || !got[0].SendAt.Equal(sendAt.Add(time.Minute)) {
+		t.Errorf("Scheduler.List() after restart = %v, want edited message %s", got, kept.ID)
+	}
+}
+
+func TestScheduler_Errors(t *testing.T) {
+	s, err := NewScheduler(domain.NewInMemoryRepository(), "", testLogger)
+	if err != nil {
+		t.Fatalf("NewScheduler() error = %v", err)
+	}
+	scheduled, err := s.Schedule("user-1", "room-1", "Meow!", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatalf("Scheduler.Schedule() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		call    func() error
+		wantErr error
+	}{
+		{
+			name: "Message without text should not be scheduled",
+			call: func() error {
+				_, err := s.Schedule("user-1", "room-1", " ", time.Now().Add(time.Hour))
+				return err
+			},
+			wantErr: ErrEmptyText,
+		},
+		{
+			name: "Message should not be scheduled in the past",
+			call: func() error {
+				_, err := s.Schedule("user-1", "room-1", "Meow!", time.Now().Add(-time.Minute))
+				return err
+			},
+			wantErr: ErrPastTime,
+		},
+		{
+			name: "Message of another user should not be edited",
+			call: func() error {
+				_, err := s.Edit("user-2", scheduled.ID, "Woof!", time.Time{})
+				return err
+			},
+			wantErr: ErrNotFound,
+		},
+		{
+			name: "Message of another user should not be canceled",
+			call: func() error {
+				return s.Cancel("user-2", scheduled.ID)
+			},
+			wantErr: ErrNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.call(); !errors.Is(err, tt.wantErr) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
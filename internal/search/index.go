@@ -0,0 +1,224 @@
+package search
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+// BM25 ranking parameters.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+const (
+	snippetWordsBefore = 5
+	snippetWordsAfter  = 10
+)
+
+// Index is an in-memory inverted index over chat messages.
+// Results are ranked with BM25, so messages containing more of the rarer query terms come first.
+type Index struct {
+	docs map[string]*document
+	// Term to frequency of the term in each message by message ID.
+	postings    map[string]map[string]int
+	totalLength int
+
+	mu sync.RWMutex
+}
+
+type document struct {
+	msg   *message.Message
+	terms []string
+}
+
+// Query describes search request.
+// Only messages posted in AllowedRooms are ever returned, so the caller must restrict them
+// to the rooms requesting user participates in. Other filters are optional.
+type Query struct {
+	Text         string
+	AllowedRooms []string
+	RoomID       string
+	UserID       string
+	From         time.Time
+	To           time.Time
+	Limit        int
+}
+
+// Result is a found message with its relevance score and a piece of text around the match.
+type Result struct {
+	Message *message.Message `json:"message"`
+	Score   float64          `json:"score"`
+	Snippet string           `json:"snippet"`
+}
+
+func NewIndex() *Index {
+	return &Index{
+		docs:     make(map[string]*document),
+		postings: make(map[string]map[string]int),
+	}
+}
+
+// Add indexes text message. Other kinds, deleted messages and messages without ID are not indexed;
+// adding message with the same ID again replaces it.
+func (idx *Index) Add(msg *message.Message) {
+	if msg.Type() != message.TextKind || msg.ID == "" || msg.IsDeleted() {
+		return
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(msg.ID)
+
+	terms := tokenize(msg.Text())
+	idx.docs[msg.ID] = &document{msg: msg, terms: terms}
+	idx.totalLength += len(terms)
+	for _, term := range terms {
+		if _, ok := idx.postings[term]; !ok {
+			idx.postings[term] = make(map[string]int)
+		}
+		idx.postings[term][msg.ID]++
+	}
+}
+
+// Remove drops message from the index.
+func (idx *Index) Remove(msgID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(msgID)
+}
+
+func (idx *Index) remove(msgID string) {
+	doc, ok := idx.docs[msgID]
+	if !ok {
+		return
+	}
+	for _, term := range doc.terms {
+		delete(idx.postings[term], msgID)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLength -= len(doc.terms)
+	delete(idx.docs, msgID)
+}
+
+// Search returns messages matching any of query terms, the most relevant first.
+func (idx *Index) Search(q Query) []*Result {
+	terms := slices.Compact(slices.Sorted(slices.Values(tokenize(q.Text))))
+	if len(terms) == 0 || len(q.AllowedRooms) == 0 {
+		return []*Result{}
+	}
+
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	allowed := make(map[string]bool, len(q.AllowedRooms))
+	for _, roomID := range q.AllowedRooms {
+		allowed[roomID] = true
+	}
+
+	n := float64(len(idx.docs))
+	avgLength := float64(idx.totalLength) / max(n, 1)
+	scores := make(map[string]float64)
+	for _, term := range terms {
+		postings := idx.postings[term]
+		df := float64(len(postings))
+		idf := math.Log(1 + (n-df+0.5)/(df+0.5))
+		for msgID, tf := range postings {
+			doc := idx.docs[msgID]
+			if !q.matches(doc.msg, allowed) {
+				continue
+			}
+			length := float64(len(doc.terms))
+			f := float64(tf)
+			scores[msgID] += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*length/avgLength))
+		}
+	}
+
+	results := make([]*Result, 0, len(scores))
+	for msgID, score := range scores {
+		results = append(results, &Result{
+			Message: idx.docs[msgID].msg,
+			Score:   score,
+		})
+	}
+	slices.SortFunc(results, func(a, b *Result) int {
+		if a.Score != b.Score {
+			if a.Score > b.Score {
+				return -1
+			}
+			return 1
+		}
+		// Newer messages first among equally relevant.
+		return b.Message.ServerTime.Compare(a.Message.ServerTime)
+	})
+	if q.Limit > 0 && len(results) > q.Limit {
+		results = results[:q.Limit]
+	}
+	for _, result := range results {
+		result.Snippet = snippet(result.Message.Text(), terms)
+	}
+	return results
+}
+
+func (q *Query) matches(msg *message.Message, allowed map[string]bool) bool {
+	if !allowed[msg.RoomID] {
+		return false
+	}
+	if q.RoomID != "" && msg.RoomID != q.RoomID {
+		return false
+	}
+	if q.UserID != "" && msg.UserID != q.UserID {
+		return false
+	}
+	if !q.From.IsZero() && msg.ServerTime.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !msg.ServerTime.Before(q.To) {
+		return false
+	}
+	return true
+}
+
+// tokenize splits text into lowercase words.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// snippet cuts a few words around the first word matching any of the sorted terms.
+func snippet(text string, terms []string) string {
+	words := strings.Fields(text)
+	match := slices.IndexFunc(words, func(word string) bool {
+		for _, token := range tokenize(word) {
+			if _, found := slices.BinarySearch(terms, token); found {
+				return true
+			}
+		}
+		return false
+	})
+	if match < 0 {
+		match = 0
+	}
+
+	from := max(0, match-snippetWordsBefore)
+	to := min(len(words), match+snippetWordsAfter+1)
+	result := strings.Join(words[from:to], " ")
+	if from > 0 {
+		result = "…" + result
+	}
+	if to < len(words) {
+		result += "…"
+	}
+	return result
+}
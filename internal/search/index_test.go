@@ -0,0 +1,107 @@
+package search
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+const (
+	testRoomA = "5e4d3c2b-1a09-4f8e-a7d6-c5b4a3928170"
+	testRoomB = "d1c2b3a4-9586-4a7b-8c9d-0e1f2a3b4c5d"
+	testUserA = "8a0cbbd1-5c4b-4f5e-9d1a-3f2e6b7c8d90"
+	testUserB = "0f3d2c1b-a987-4e65-b432-10fedcba9876"
+)
+
+var testTime = time.Date(2015, time.May, 1, 12, 0, 0, 0, time.UTC)
+
+var testMessages = []*message.Message{
+	{ID: "m1", UserID: testUserB, RoomID: testRoomA, Body: &message.TextBody{Text: "Bow to me, minion!"}, ServerTime: testTime},
+	{ID: "m2", UserID: testUserA, RoomID: testRoomA, Body: &message.TextBody{Text: "Never! The shield holds, the shield always holds."}, ServerTime: testTime.Add(time.Minute)},
+	{ID: "m3", UserID: testUserB, RoomID: testRoomB, Body: &message.TextBody{Text: "There are no strings on me"}, ServerTime: testTime.Add(2 * time.Minute)},
+	{ID: "m4", UserID: testUserA, RoomID: testRoomA, Body: &message.TextBody{Text: "Where is the shield?"}, ServerTime: testTime.Add(3 * time.Minute)},
+	{ID: "m5", UserID: testUserA, RoomID: testRoomA, Body: &message.NotificationBody{Event: "create-room"}, ServerTime: testTime},
+}
+
+func TestIndex_Search(t *testing.T) {
+	tests := []struct {
+		name        string
+		query       Query
+		wantIDs     []string
+		wantSnippet string
+	}{
+		{
+			name:    "Messages with more occurrences of the term should rank higher",
+			query:   Query{Text: "Shield", AllowedRooms: []string{testRoomA, testRoomB}},
+			wantIDs: []string{"m2", "m4"},
+		},
+		{
+			name:    "Messages from rooms user does not participate in should not be found",
+			query:   Query{Text: "strings minion", AllowedRooms: []string{testRoomA}},
+			wantIDs: []string{"m1"},
+		},
+		{
+			name:    "Search without allowed rooms should find nothing",
+			query:   Query{Text: "minion"},
+			wantIDs: []string{},
+		},
+		{
+			name:    "Search should be filtered by author",
+			query:   Query{Text: "strings minion", AllowedRooms: []string{testRoomA, testRoomB}, UserID: testUserB, RoomID: testRoomB},
+			wantIDs: []string{"m3"},
+		},
+		{
+			name:    "Search should be filtered by time range",
+			query:   Query{Text: "shield", AllowedRooms: []string{testRoomA}, From: testTime.Add(2 * time.Minute), To: testTime.Add(time.Hour)},
+			wantIDs: []string{"m4"},
+		},
+		{
+			name:    "Notifications should not be indexed",
+			query:   Query{Text: "create room", AllowedRooms: []string{testRoomA}},
+			wantIDs: []string{},
+		},
+		{
+			name:        "Snippet should be cut around the first match",
+			query:       Query{Text: "always", AllowedRooms: []string{testRoomA}},
+			wantIDs:     []string{"m2"},
+			wantSnippet: "…The shield holds, the shield always holds.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			idx := NewIndex()
+			for _, msg := range testMessages {
+				idx.Add(msg)
+			}
+			got := idx.Search(tt.query)
+			gotIDs := []string{}
+			for _, result := range got {
+				gotIDs = append(gotIDs, result.Message.ID)
+			}
+			if len(gotIDs) != len(tt.wantIDs) {
+				t.Fatalf("Index.Search() = %v, want %v", gotIDs, tt.wantIDs)
+			}
+			for i := range gotIDs {
+				if gotIDs[i] != tt.wantIDs[i] {
+					t.Fatalf("Index.Search() = %v, want %v", gotIDs, tt.wantIDs)
+				}
+			}
+			if tt.wantSnippet != "" && got[0].Snippet != tt.wantSnippet {
+				t.Errorf("Index.Search() snippet = %q, want %q", got[0].Snippet, tt.wantSnippet)
+			}
+		})
+	}
+}
+
+func TestIndex_Remove(t *testing.T) {
+	idx := NewIndex()
+	for _, msg := range testMessages {
+		idx.Add(msg)
+	}
+	idx.Remove("m2")
+	got := idx.Search(Query{Text: "shield", AllowedRooms: []string{testRoomA}})
+	if len(got) != 1 || got[0].Message.ID != "m4" {
+		t.Errorf("Index.Search() after removal = %v, want only m4", got)
+	}
+}
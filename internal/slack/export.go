@@ -0,0 +1,105 @@
+package slack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// user is an entry of users.json.
+type user struct {
+	ID      string `json:"id"`
+	Name    string `json:"name"`
+	Deleted bool   `json:"deleted"`
+}
+
+// channel is an entry of channels.json; its messages are in the directory named after it.
+type channel struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Creator string   `json:"creator"`
+	Members []string `json:"members"`
+}
+
+// slackMessage is an entry of per-day message file of the channel.
+type slackMessage struct {
+	Type     string `json:"type"`
+	Subtype  string `json:"subtype"`
+	User     string `json:"user"`
+	Username string `json:"username"`
+	Text     string `json:"text"`
+	TS       string `json:"ts"`
+	ThreadTS string `json:"thread_ts"`
+	Edited   *struct {
+		TS string `json:"ts"`
+	} `json:"edited"`
+	Files []struct {
+		Name string `json:"name"`
+	} `json:"files"`
+	Reactions []struct {
+		Name  string   `json:"name"`
+		Users []string `json:"users"`
+	} `json:"reactions"`
+}
+
+// isReply tells if message is a reply in thread rather than the thread root or a plain message.
+func (m *slackMessage) isReply() bool {
+	return m.ThreadTS != "" && m.ThreadTS != m.TS
+}
+
+func readJSON[T any](fsys fs.FS, name string) (T, error) {
+	var v T
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return v, err
+	}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return v, fmt.Errorf("decode %s: %w", name, err)
+	}
+	return v, nil
+}
+
+// readChannelHistory returns messages of the channel in chronological order.
+// Export keeps a file per day named as 2006-01-02.json, so file names sort chronologically.
+func readChannelHistory(fsys fs.FS, ch *channel) ([]*slackMessage, error) {
+	days, err := fs.Glob(fsys, path.Join(ch.Name, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(days)
+	var history []*slackMessage
+	for _, day := range days {
+		messages, err := readJSON[[]*slackMessage](fsys, day)
+		if err != nil {
+			return nil, err
+		}
+		slices.SortStableFunc(messages, func(a, b *slackMessage) int {
+			return parseTS(a.TS).Compare(parseTS(b.TS))
+		})
+		history = append(history, messages...)
+	}
+	return history, nil
+}
+
+// parseTS converts Slack timestamp, seconds and microseconds since epoch as "1503435956.000247",
+// to time; invalid timestamp is zero time.
+func parseTS(ts string) time.Time {
+	secs, micros, _ := strings.Cut(ts, ".")
+	s, err := strconv.ParseInt(secs, 10, 64)
+	if err != nil {
+		return time.Time{}
+	}
+	var us int64
+	if micros != "" {
+		micros = (micros + "000000")[:6]
+		if us, err = strconv.ParseInt(micros, 10, 64); err != nil {
+			return time.Time{}
+		}
+	}
+	return time.Unix(s, us*int64(time.Microsecond)).UTC()
+}
@@ -0,0 +1,290 @@
+// Package slack imports history of a Slack workspace from its export:
+// users.json, channels.json and a directory per channel with a message file per day.
+package slack
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"slices"
+	"strings"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+// Stats counts what import has done.
+type Stats struct {
+	// Users and rooms created, existing ones with the same names are reused.
+	Users int
+	Rooms int
+	// Messages added to history, and messages left out since they had been imported before.
+	Messages int
+	Existing int
+	// Messages of kinds chatter has no counterpart for, e.g. channel topic changes, or of unknown authors.
+	Skipped int
+}
+
+// Importer creates users and rooms of Slack workspace in the repository,
+// and adds messages of its channels to history with their original timestamps.
+// Import can be run again, e.g. with a newer export: users and rooms are matched by names,
+// and messages imported before are recognized by their IDs, which are derived from Slack ones.
+// Imported messages are appended to room history, so rooms are better imported before chatting starts there.
+type Importer struct {
+	repo         domain.Repository
+	messageStore message.Store
+	clock        *message.Clock
+	logger       *log.Logger
+
+	// Chatter users by Slack user IDs, and by names for authors known by name only, e.g. bots.
+	users     map[string]*domain.User
+	userNames map[string]*domain.User
+
+	stats Stats
+}
+
+func NewImporter(repo domain.Repository, messageStore message.Store, logger *log.Logger) *Importer {
+	return &Importer{
+		repo:         repo,
+		messageStore: messageStore,
+		clock:        message.NewClock(messageStore),
+		logger:       logger,
+		users:        make(map[string]*domain.User),
+		userNames:    make(map[string]*domain.User),
+	}
+}
+
+// Import reads the export, either a directory or an opened zip archive, and imports its users and channels.
+// Messages are saved directly to the store, so the service should not be running meanwhile.
+func (im *Importer) Import(ctx context.Context, fsys fs.FS) (Stats, error) {
+	users, err := readJSON[[]*user](fsys, "users.json")
+	if err != nil {
+		return im.stats, err
+	}
+	for _, u := range users {
+		if err := im.importUser(ctx, u); err != nil {
+			return im.stats, fmt.Errorf("import user %s: %w", u.Name, err)
+		}
+	}
+
+	channels, err := readJSON[[]*channel](fsys, "channels.json")
+	if err != nil {
+		return im.stats, err
+	}
+	for _, ch := range channels {
+		if err := ctx.Err(); err != nil {
+			return im.stats, err
+		}
+		if err := im.importChannel(ctx, fsys, ch); err != nil {
+			return im.stats, fmt.Errorf("import channel %s: %w", ch.Name, err)
+		}
+	}
+	return im.stats, nil
+}
+
+func (im *Importer) importUser(ctx context.Context, u *user) error {
+	chatterUser, err := im.user(ctx, u.Name)
+	if err != nil {
+		return err
+	}
+	im.users[u.ID] = chatterUser
+	return nil
+}
+
+// user returns user with the name, created if there is none.
+func (im *Importer) user(ctx context.Context, name string) (*domain.User, error) {
+	name = strings.ToLower(name)
+	if u, ok := im.userNames[name]; ok {
+		return u, nil
+	}
+	u := im.repo.FindUser(ctx, name)
+	if u == nil {
+		var err error
+		if u, err = im.repo.CreateUser(ctx, name); err != nil {
+			return nil, err
+		}
+		im.stats.Users++
+	}
+	im.userNames[name] = u
+	return u, nil
+}
+
+func (im *Importer) importChannel(ctx context.Context, fsys fs.FS, ch *channel) error {
+	var members []*domain.User
+	for _, id := range ch.Members {
+		if u, ok := im.users[id]; ok {
+			members = append(members, u)
+		}
+	}
+
+	room := im.repo.FindRoom(ctx, strings.ToLower(ch.Name))
+	if room == nil {
+		creator, ok := im.users[ch.Creator]
+		if !ok {
+			if len(members) == 0 {
+				im.logger.Printf("Channel %s has neither creator nor members and is not imported.\n", ch.Name)
+				return nil
+			}
+			creator = members[0]
+		}
+		var err error
+		if room, err = im.repo.CreateRoom(ctx, strings.ToLower(ch.Name), creator.ID); err != nil {
+			return err
+		}
+		im.stats.Rooms++
+	}
+	for _, u := range members {
+		if err := im.repo.JoinRoom(ctx, u.ID, room.ID); err != nil {
+			return err
+		}
+	}
+
+	history, err := readChannelHistory(fsys, ch)
+	if err != nil {
+		return err
+	}
+	for _, m := range history {
+		if err := im.importMessage(ctx, ch, room, members, m); err != nil {
+			return fmt.Errorf("import message %s: %w", m.TS, err)
+		}
+	}
+
+	// Members have seen the history in Slack already, so it is not unread in chatter.
+	lastSeq, err := im.messageStore.LastSeq(ctx, room.ID)
+	if err != nil || lastSeq == 0 {
+		return err
+	}
+	for _, u := range members {
+		if _, err := im.repo.MarkRead(ctx, u.ID, room.ID, lastSeq); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (im *Importer) importMessage(ctx context.Context, ch *channel, room *domain.Room, members []*domain.User, m *slackMessage) error {
+	msg := &message.Message{
+		ID:         messageID(ch.ID, m.TS),
+		RoomID:     room.ID,
+		Room:       room.Name,
+		ServerTime: parseTS(m.TS),
+	}
+	if m.Type != "message" || msg.ServerTime.IsZero() {
+		im.stats.Skipped++
+		return nil
+	}
+	_, err := im.messageStore.GetMessage(ctx, room.ID, msg.ID)
+	if err == nil {
+		im.stats.Existing++
+		return nil
+	}
+	if !errors.Is(err, message.ErrMessageNotFound) {
+		return err
+	}
+
+	author, ok := im.users[m.User]
+	if !ok && m.Username != "" {
+		if author, err = im.user(ctx, m.Username); err != nil {
+			return err
+		}
+	}
+	if author == nil {
+		im.stats.Skipped++
+		return nil
+	}
+	msg.UserID, msg.User = author.ID, author.Name
+
+	switch m.Subtype {
+	case "channel_join":
+		msg.Body = &message.MembershipBody{Event: message.JoinRoomEvent}
+	case "channel_leave":
+		msg.Body = &message.MembershipBody{Event: message.LeaveRoomEvent}
+	case "", "bot_message", "me_message", "file_share", "thread_broadcast":
+		text, mentioned := im.convertText(m.Text)
+		// Files themselves are not part of the export.
+		for _, f := range m.Files {
+			text = strings.TrimSpace(text + "\n[file " + f.Name + "]")
+		}
+		if text == "" {
+			im.stats.Skipped++
+			return nil
+		}
+		msg.Body = &message.TextBody{Text: text}
+		msg.Mentions = mentionsOf(mentioned, members, author.ID)
+	default:
+		im.stats.Skipped++
+		return nil
+	}
+
+	if m.Edited != nil {
+		if editedAt := parseTS(m.Edited.TS); !editedAt.IsZero() {
+			msg.EditedAt = &editedAt
+		}
+	}
+	for _, r := range m.Reactions {
+		e, ok := reactionEmoji(r.Name)
+		if !ok {
+			continue
+		}
+		for _, id := range r.Users {
+			if u, ok := im.users[id]; ok {
+				msg = msg.React(e, u.ID)
+			}
+		}
+	}
+
+	// Replies whose root is not imported, e.g. is of unsupported kind, stay in the room log only.
+	var root *message.Message
+	if m.isReply() {
+		root, err = im.messageStore.GetMessage(ctx, room.ID, messageID(ch.ID, m.ThreadTS))
+		if err != nil && !errors.Is(err, message.ErrMessageNotFound) {
+			return err
+		}
+		if root != nil {
+			msg.ParentID = root.ID
+		}
+	}
+
+	if msg.Seq, err = im.clock.Next(ctx, room.ID); err != nil {
+		return err
+	}
+	if err := im.messageStore.SaveMessage(ctx, room.ID, msg); err != nil {
+		return err
+	}
+	if root != nil {
+		if err := im.messageStore.ReplaceMessage(ctx, room.ID, root.WithReply(msg)); err != nil {
+			return err
+		}
+	}
+	im.stats.Messages++
+	return nil
+}
+
+// mentionsOf resolves mentions the way broadcaster does for live messages: only room members
+// other than the author are mentioned, and @room addresses all of them. Nobody is online
+// during import, so @here is only marked.
+func mentionsOf(mentioned *mentions, members []*domain.User, authorID string) *message.Mentions {
+	result := &message.Mentions{Here: mentioned.here, Room: mentioned.room}
+	for _, u := range members {
+		if u.ID != authorID && (mentioned.room || slices.Contains(mentioned.userIDs, u.ID)) {
+			result.UserIDs = append(result.UserIDs, u.ID)
+		}
+	}
+	if len(result.UserIDs) == 0 && !result.Here && !result.Room {
+		return nil
+	}
+	return result
+}
+
+// messageID derives UUID of the imported message from Slack channel ID and message timestamp,
+// which identify the message in the workspace, so that import recognizes messages imported before.
+func messageID(channelID, ts string) string {
+	b := sha1.Sum([]byte("slack:" + channelID + ":" + ts))
+	// Name-based (version 5) UUID.
+	b[6] = (b[6] & 0x0f) | 0x50
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,185 @@
+package slack
+
+import (
+	"context"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/lennylebedinsky/chatter/internal/domain"
+	"github.com/lennylebedinsky/chatter/internal/message"
+)
+
+var testLogger = log.New(io.Discard, "", 0)
+
+var testExport = fstest.MapFS{
+	"users.json": {Data: []byte(`[
+		{"id": "U01", "name": "Alice"},
+		{"id": "U02", "name": "bob", "deleted": true}
+	]`)},
+	"channels.json": {Data: []byte(`[
+		{"id": "C01", "name": "engineering", "creator": "U01", "members": ["U01", "U02"]}
+	]`)},
+	"engineering/2024-05-02.json": {Data: []byte(`[
+		{"type": "message", "user": "U02", "text": "thanks", "ts": "1714644000.000200", "thread_ts": "1714557600.000100"},
+		{"type": "message", "subtype": "channel_topic", "user": "U01", "text": "set the topic", "ts": "1714644060.000000"}
+	]`)},
+	"engineering/2024-05-01.json": {Data: []byte(`[
+		{"type": "message", "user": "U01", "text": "hi <@U02>, see <https://example.com|docs> &amp; more",
+		 "ts": "1714557600.000100", "thread_ts": "1714557600.000100", "edited": {"user": "U01", "ts": "1714557660.000000"},
+		 "reactions": [{"name": "+1::skin-tone-2", "users": ["U02"]}, {"name": "custom", "users": ["U02"]}]},
+		{"type": "message", "subtype": "channel_join", "user": "U02", "text": "<@U02> has joined the channel", "ts": "1714550000.000000"},
+		{"type": "message", "subtype": "bot_message", "username": "deploybot", "text": "deployed", "ts": "1714560000.000000"}
+	]`)},
+}
+
+func TestImporter_Import(t *testing.T) {
+	ctx := context.Background()
+	repo := domain.NewInMemoryRepository()
+	store := message.NewInMemoryStore(message.RetentionPolicy{}, nil, testLogger)
+	defer store.Close()
+
+	stats, err := NewImporter(repo, store, testLogger).Import(ctx, testExport)
+	if err != nil {
+		t.Fatalf("Importer.Import() error = %v", err)
+	}
+	if want := (Stats{Users: 3, Rooms: 1, Messages: 4, Skipped: 1}); stats != want {
+		t.Errorf("Importer.Import() = %+v, want %+v", stats, want)
+	}
+
+	alice, bob := repo.FindUser(ctx, "alice"), repo.FindUser(ctx, "bob")
+	room := repo.FindRoom(ctx, "engineering")
+	if alice == nil || bob == nil || repo.FindUser(ctx, "deploybot") == nil || room == nil {
+		t.Fatalf("Importer.Import() did not create users and rooms")
+	}
+	participants, _ := repo.ListParticipants(ctx, room.ID)
+	if len(participants) != 2 {
+		t.Errorf("Importer.Import() joined %d participants, want 2", len(participants))
+	}
+
+	page, err := store.GetMessages(ctx, room.ID, message.PageRequest{Limit: 10})
+	if err != nil {
+		t.Fatalf("Store.GetMessages() error = %v", err)
+	}
+	if len(page.Messages) != 4 {
+		t.Fatalf("Store.GetMessages() returned %d messages, want 4", len(page.Messages))
+	}
+	join, root, bot, reply := page.Messages[0], page.Messages[1], page.Messages[2], page.Messages[3]
+	if join.Type() != message.MembershipKind || join.UserID != bob.ID {
+		t.Errorf("first message = %+v, want join of bob", join)
+	}
+	if got, want := root.Text(), "hi @bob, see docs (https://example.com) & more"; got != want {
+		t.Errorf("root text = %q, want %q", got, want)
+	}
+	if want := time.Date(2024, 5, 1, 10, 0, 0, 100000, time.UTC); !root.ServerTime.Equal(want) {
+		t.Errorf("root time = %v, want %v", root.ServerTime, want)
+	}
+	if root.EditedAt == nil || root.Thread == nil || root.Thread.ReplyCount != 1 {
+		t.Errorf("root = %+v, want it edited with a reply", root)
+	}
+	if !root.Mentioned(bob.ID) {
+		t.Errorf("root mentions = %+v, want bob mentioned", root.Mentions)
+	}
+	if want := map[string][]string{"👍": {bob.ID}}; !reflect.DeepEqual(root.Reactions, want) {
+		t.Errorf("root reactions = %v, want %v", root.Reactions, want)
+	}
+	if bot.User != "deploybot" {
+		t.Errorf("bot message author = %q, want deploybot", bot.User)
+	}
+	if reply.ParentID != root.ID || reply.Seq != 4 {
+		t.Errorf("reply = %+v, want reply to %s with seq 4", reply, root.ID)
+	}
+	if cursors, _ := repo.ReadCursors(ctx, alice.ID); cursors[room.ID] != 4 {
+		t.Errorf("read cursor = %d, want 4", cursors[room.ID])
+	}
+
+	// Import of the same export once more changes nothing.
+	stats, err = NewImporter(repo, store, testLogger).Import(ctx, testExport)
+	if err != nil {
+		t.Fatalf("Importer.Import() again error = %v", err)
+	}
+	if want := (Stats{Existing: 4, Skipped: 1}); stats != want {
+		t.Errorf("Importer.Import() again = %+v, want %+v", stats, want)
+	}
+	if lastSeq, _ := store.LastSeq(ctx, room.ID); lastSeq != 4 {
+		t.Errorf("Store.LastSeq() after import again = %d, want 4", lastSeq)
+	}
+	if root, _ := store.GetMessage(ctx, room.ID, root.ID); root.Thread.ReplyCount != 1 {
+		t.Errorf("root reply count after import again = %d, want 1", root.Thread.ReplyCount)
+	}
+}
+
+func TestImporter_convertText(t *testing.T) {
+	im := NewImporter(domain.NewInMemoryRepository(), nil, testLogger)
+	im.users["U01"] = &domain.User{ID: "1", Name: "alice"}
+	tests := []struct {
+		name         string
+		text         string
+		want         string
+		wantMentions *mentions
+	}{
+		{
+			name:         "Known user should be mentioned by name",
+			text:         "<@U01> look",
+			want:         "@alice look",
+			wantMentions: &mentions{userIDs: []string{"1"}},
+		},
+		{
+			name:         "Unknown user should be referenced by label",
+			text:         "<@U09|carol> look",
+			want:         "@carol look",
+			wantMentions: &mentions{},
+		},
+		{
+			name:         "Special mentions should be converted",
+			text:         "<!here> and <!channel>",
+			want:         "@here and @room",
+			wantMentions: &mentions{here: true, room: true},
+		},
+		{
+			name:         "Channels and links should be shown by labels",
+			text:         "in <#C01|general>: <https://example.com> or <https://example.org|site>",
+			want:         "in #general: https://example.com or site (https://example.org)",
+			wantMentions: &mentions{},
+		},
+		{
+			name:         "Escaped characters should be restored",
+			text:         "a &lt;b&gt; &amp;amp;",
+			want:         "a <b> &amp;",
+			wantMentions: &mentions{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotMentions := im.convertText(tt.text)
+			if got != tt.want {
+				t.Errorf("Importer.convertText() = %q, want %q", got, tt.want)
+			}
+			if !reflect.DeepEqual(gotMentions, tt.wantMentions) {
+				t.Errorf("Importer.convertText() mentions = %+v, want %+v", gotMentions, tt.wantMentions)
+			}
+		})
+	}
+}
+
+func TestParseTS(t *testing.T) {
+	tests := []struct {
+		ts   string
+		want time.Time
+	}{
+		{ts: "1503435956.000247", want: time.Date(2017, 8, 22, 21, 5, 56, 247000, time.UTC)},
+		{ts: "1503435956", want: time.Date(2017, 8, 22, 21, 5, 56, 0, time.UTC)},
+		{ts: "1503435956.5", want: time.Date(2017, 8, 22, 21, 5, 56, 500000000, time.UTC)},
+		{ts: "yesterday", want: time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.ts, func(t *testing.T) {
+			if got := parseTS(tt.ts); !got.Equal(tt.want) {
+				t.Errorf("parseTS() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,95 @@
+package slack
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Slack marks up references to users, channels and links as <...>, e.g. <@U024BE7LH>,
+// <#C024BE7LR|general>, <!here> or <https://example.com|example>.
+var referencePattern = regexp.MustCompile(`<([^<>]*)>`)
+
+// mentions of the message text resolved to chatter users.
+type mentions struct {
+	userIDs []string
+	here    bool
+	room    bool
+}
+
+// convertText turns Slack markup into plain chatter text, where users are mentioned as @name,
+// and collects mentions on the way.
+func (im *Importer) convertText(text string) (string, *mentions) {
+	found := &mentions{}
+	text = referencePattern.ReplaceAllStringFunc(text, func(ref string) string {
+		target, label, _ := strings.Cut(ref[1:len(ref)-1], "|")
+		switch {
+		case strings.HasPrefix(target, "@"):
+			if u, ok := im.users[target[1:]]; ok {
+				found.userIDs = append(found.userIDs, u.ID)
+				return "@" + u.Name
+			}
+			return "@" + or(label, target[1:])
+		case strings.HasPrefix(target, "#"):
+			return "#" + or(label, target[1:])
+		case target == "!here":
+			found.here = true
+			return "@here"
+		case target == "!channel" || target == "!everyone":
+			found.room = true
+			return "@room"
+		case strings.HasPrefix(target, "!"):
+			// User groups and dates are shown by their labels.
+			return label
+		case label != "" && label != target:
+			return label + " (" + target + ")"
+		default:
+			return target
+		}
+	})
+	// Slack escapes only these characters.
+	text = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&amp;", "&").Replace(text)
+	return text, found
+}
+
+func or(s, fallback string) string {
+	if s != "" {
+		return s
+	}
+	return fallback
+}
+
+// emoji maps names of the most common Slack reactions to emoji, as chatter reactions are emoji themselves.
+// Reactions with other names, e.g. custom emoji of the workspace, are not imported.
+var emoji = map[string]string{
+	"+1":                    "👍",
+	"thumbsup":              "👍",
+	"-1":                    "👎",
+	"thumbsdown":            "👎",
+	"heart":                 "❤️",
+	"smile":                 "😄",
+	"slightly_smiling_face": "🙂",
+	"laughing":              "😆",
+	"joy":                   "😂",
+	"tada":                  "🎉",
+	"eyes":                  "👀",
+	"white_check_mark":      "✅",
+	"heavy_check_mark":      "✔️",
+	"x":                     "❌",
+	"fire":                  "🔥",
+	"pray":                  "🙏",
+	"clap":                  "👏",
+	"raised_hands":          "🙌",
+	"ok_hand":               "👌",
+	"wave":                  "👋",
+	"rocket":                "🚀",
+	"thinking_face":         "🤔",
+	"100":                   "💯",
+	"heavy_plus_sign":       "➕",
+}
+
+// reactionEmoji returns emoji of Slack reaction, which could have skin tone as "+1::skin-tone-2".
+func reactionEmoji(name string) (string, bool) {
+	name, _, _ = strings.Cut(name, "::")
+	e, ok := emoji[name]
+	return e, ok
+}